@@ -5,17 +5,26 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/MrUsefull/boundation/internal/config"
 	"github.com/MrUsefull/boundation/internal/server"
 )
 
 func main() {
-	ctx := context.Background()
+	// kubernetes sends SIGTERM, then SIGKILL once the pod's termination grace period is over
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
 	cfg := mustLoadConfig()
 	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{AddSource: true, Level: cfg.LogLevel}))
 
-	server.Serve(ctx, cfg, logger)
+	if err := server.Serve(ctx, cfg, logger); err != nil {
+		logger.Error("server stopped", slog.Any("error", err))
+		stop()
+		os.Exit(1)
+	}
 }
 
 func mustLoadConfig() config.Config {
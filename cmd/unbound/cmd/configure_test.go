@@ -5,6 +5,7 @@ import (
 	"io"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/MrUsefull/boundation/internal/config"
 	"github.com/stretchr/testify/assert"
@@ -156,6 +157,9 @@ func Test_writeCfg(t *testing.T) {
 				Opnsense: config.Opnsense{
 					BaseURL: "https://some.url.here",
 					Creds:   "key:secret",
+					HTTP: config.HTTP{
+						Timeout: 30 * time.Second,
+					},
 				},
 			},
 		},
@@ -6,13 +6,13 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
 
 	"github.com/MrUsefull/boundation/internal/config"
 	"github.com/MrUsefull/boundation/internal/unbound"
 	"github.com/spf13/cobra"
 	"sigs.k8s.io/external-dns/endpoint"
 	"sigs.k8s.io/external-dns/plan"
-	"sigs.k8s.io/external-dns/provider"
 )
 
 var ErrMissingHosts = errors.New("1 host or more is required")
@@ -27,16 +27,20 @@ var deleteCMD = &cobra.Command{
 }
 
 func runDelete(cmd *cobra.Command, _ []string) error {
-	return deleteEndpoints(http.DefaultClient, pkgConfig, cmd)
+	return deleteEndpoints(pkgClient, recordsConfig(), cmd)
 }
 
-func deleteEndpoints(client *http.Client, cfg config.Config, cmd *cobra.Command) error {
+func deleteEndpoints(client *http.Client, cfg config.Config, cmd *cobra.Command, opts ...unbound.Opts) error {
 	ctx := cmd.Context()
 	toDeleteEP, err := parseDeleteFlags(cmd)
 	if err != nil {
 		return err
 	}
-	provider := unbound.New(client, cfg, logger)
+	flagOpts, err := applyOpts(cmd)
+	if err != nil {
+		return err
+	}
+	provider := newRecordProvider(client, cfg, logger, append(opts, flagOpts...)...)
 	changes, err := planChanges(ctx, provider, toDeleteEP)
 	if err != nil {
 		return err
@@ -73,8 +77,19 @@ func toSet(hosts []string) map[string]struct{} {
 	return out
 }
 
-func planChanges(ctx context.Context, unbonud provider.Provider, toDelete map[string]struct{}) (*plan.Changes, error) {
-	found, err := unbonud.Records(ctx)
+// recordFinder looks up the existing records of specific hosts.
+type recordFinder interface {
+	FindRecords(ctx context.Context, dnsNames ...string) ([]*endpoint.Endpoint, error)
+}
+
+func planChanges(ctx context.Context, unbonud recordFinder, toDelete map[string]struct{}) (*plan.Changes, error) {
+	hosts := make([]string, 0, len(toDelete))
+	for host := range toDelete {
+		hosts = append(hosts, host)
+	}
+	slices.Sort(hosts)
+
+	found, err := unbonud.FindRecords(ctx, hosts...)
 	if err != nil {
 		return nil, fmt.Errorf("check existing records: %w", err)
 	}
@@ -82,9 +97,9 @@ func planChanges(ctx context.Context, unbonud provider.Provider, toDelete map[st
 	deleteEps := make([]*endpoint.Endpoint, 0, len(toDelete))
 	for _, record := range found {
 		logger.DebugContext(ctx, "Checking endpoint", slog.Any("endpoint", record.DNSName))
-		if _, ok := toDelete[record.DNSName]; ok && record.SetIdentifier != "" {
+		if _, ok := toDelete[record.DNSName]; ok && record.Labels[unbound.RowsLabel] != "" {
 			logger.InfoContext(ctx, "found existing endpoint to delete",
-				slog.Any("endpoint", record.DNSName), slog.Any("SetIdentifier", record.SetIdentifier))
+				slog.Any("endpoint", record.DNSName), slog.Any("rows", record.Labels[unbound.RowsLabel]))
 			deleteEps = append(deleteEps, record)
 		}
 	}
@@ -96,4 +111,5 @@ func planChanges(ctx context.Context, unbonud provider.Provider, toDelete map[st
 
 func setDeleteCmdFlags(cmd *cobra.Command) {
 	cmd.Flags().StringArray(hostsFlag, []string{}, "FQDN for DNS entries to delete")
+	setApplyFlags(cmd)
 }
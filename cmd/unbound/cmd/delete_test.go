@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"testing"
 
@@ -118,6 +119,67 @@ func Test_deleteEndpoints(t *testing.T) {
 				assert.NoError(t, err)
 			},
 		},
+		{
+			name: "Dry run",
+			cmd: func() *cobra.Command {
+				cmd := &cobra.Command{}
+				cmd.SetContext(context.Background())
+				cmd.SetOut(io.Discard)
+				setDeleteCmdFlags(cmd)
+				require.NoError(t, cmd.Flags().Set(hostsFlag, "host1.com"))
+				require.NoError(t, cmd.Flags().Set(dryRunFlag, "true"))
+				return cmd
+			}(),
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodGet, r.Method, "only searches are sent")
+				_, err := w.Write([]byte(requireGenerateReadResponse(t, []unbound.Record{
+					{UUID: "some-uuid-here", Hostname: "host1", Domain: "com", Rr: "A", Server: "1.2.3.4", Enabled: "1", Description: "extdns/1 t=A"},
+				})))
+				assert.NoError(t, err)
+			},
+		},
+		{
+			name: "Unmanaged record",
+			cmd: func() *cobra.Command {
+				cmd := &cobra.Command{}
+				cmd.SetContext(context.Background())
+				setDeleteCmdFlags(cmd)
+				require.NoError(t, cmd.Flags().Set(hostsFlag, "host1.com"))
+				return cmd
+			}(),
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodGet, r.Method, "nothing is deleted")
+				_, err := w.Write([]byte(requireGenerateReadResponse(t, []unbound.Record{
+					{UUID: "some-uuid-here", Hostname: "host1", Domain: "com", Rr: "A", Server: "1.2.3.4", Enabled: "1", Description: "hand made"},
+				})))
+				assert.NoError(t, err)
+			},
+			wantErr: unbound.ErrUnmanagedRecord,
+		},
+		{
+			name: "Unmanaged record with force",
+			cmd: func() *cobra.Command {
+				cmd := &cobra.Command{}
+				cmd.SetContext(context.Background())
+				setDeleteCmdFlags(cmd)
+				require.NoError(t, cmd.Flags().Set(hostsFlag, "host1.com"))
+				require.NoError(t, cmd.Flags().Set(forceFlag, "true"))
+				return cmd
+			}(),
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				resp := testhelpers.DeleteSuccessServResp
+				if r.Method == http.MethodGet {
+					resp = requireGenerateReadResponse(t, []unbound.Record{
+						{UUID: "some-uuid-here", Hostname: "host1", Domain: "com", Rr: "A", Server: "1.2.3.4", Enabled: "1", Description: "hand made"},
+					})
+				}
+				if r.URL.Path == unbound.ApplyChangesEndpoint {
+					resp = testhelpers.ReconfigureResp
+				}
+				_, err := w.Write([]byte(resp))
+				assert.NoError(t, err)
+			},
+		},
 		{
 			name: "Bad cmd input: Missing hosts",
 			cmd: func() *cobra.Command {
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"text/tabwriter"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ErrMissingDomains      = errors.New("1 domain or more is required")
+	ErrUnequalDomainServer = errors.New("the domains and servers must have the same length")
+
+	exampleDomainsUpsert = fmt.Sprintf("domains upsert --%v=lab.example.com --%v=10.0.0.53", domainsFlag, serversFlag)
+	exampleDomainsDelete = fmt.Sprintf("domains delete --%v=lab.example.com", domainsFlag)
+)
+
+var domainsCMD = &cobra.Command{
+	Use:   "domains",
+	Short: "Manage OPNsense unbound domain overrides, which forward a zone to another DNS server",
+}
+
+var domainsReadCMD = &cobra.Command{
+	Use:     "read",
+	Short:   "Shows existing domain overrides in OPNSense unbound DNS",
+	Example: "domains read",
+	RunE:    configured(runDomainsRead),
+}
+
+var domainsUpsertCMD = &cobra.Command{
+	Use:     exampleDomainsUpsert,
+	Short:   "Upserts the provided domain overrides in OPNsense unbound",
+	Example: exampleDomainsUpsert,
+	RunE:    configured(runDomainsUpsert),
+}
+
+var domainsDeleteCMD = &cobra.Command{
+	Use:     exampleDomainsDelete,
+	Short:   "deletes the provided domain overrides in OPNsense unbound",
+	Example: exampleDomainsDelete,
+	RunE:    configured(runDomainsDelete),
+}
+
+func runDomainsRead(cmd *cobra.Command, _ []string) error {
+	return readDomains(pkgClient, domainsConfig(), os.Stdout, cmd)
+}
+
+func runDomainsUpsert(cmd *cobra.Command, _ []string) error {
+	return upsertDomains(pkgClient, domainsConfig(), cmd)
+}
+
+func runDomainsDelete(cmd *cobra.Command, _ []string) error {
+	return deleteDomains(pkgClient, domainsConfig(), cmd)
+}
+
+func readDomains(client *http.Client, cfg config.Config, output io.Writer, cmd *cobra.Command) error {
+	provider := newDomainProvider(client, cfg, logger)
+	found, err := provider.DomainOverrides(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("read domains: %w", err)
+	}
+	printDomains(output, found)
+	return nil
+}
+
+func upsertDomains(client *http.Client, cfg config.Config, cmd *cobra.Command) error {
+	ctx := cmd.Context()
+	wanted, err := parseDomainMappings(cmd)
+	if err != nil {
+		return err
+	}
+
+	provider := newDomainProvider(client, cfg, logger)
+	existing, err := provider.DomainOverrides(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to read existing domains: %w", err)
+	}
+
+	changes := domainUpsertChanges(existing, wanted)
+	logger.InfoContext(ctx, "domain plan created", slog.Any("plan", changes))
+	if err := provider.ApplyDomainChanges(ctx, changes); err != nil {
+		return fmt.Errorf("apply failed: %w", err)
+	}
+	return nil
+}
+
+func deleteDomains(client *http.Client, cfg config.Config, cmd *cobra.Command) error {
+	ctx := cmd.Context()
+	domains, err := cmd.Flags().GetStringArray(domainsFlag)
+	if err != nil {
+		return fmt.Errorf("missing domains: %w", err)
+	}
+	if len(domains) == 0 {
+		return ErrMissingDomains
+	}
+
+	provider := newDomainProvider(client, cfg, logger)
+	existing, err := provider.DomainOverrides(ctx)
+	if err != nil {
+		return fmt.Errorf("check existing domains: %w", err)
+	}
+
+	changes := domainDeleteChanges(existing, toSet(domains))
+	if !changes.HasChanges() {
+		fmt.Printf("No changes needed for delete\n")
+		return nil
+	}
+	if err := provider.ApplyDomainChanges(ctx, changes); err != nil {
+		return fmt.Errorf("apply changes: %w", err)
+	}
+	return nil
+}
+
+func parseDomainMappings(cmd *cobra.Command) (map[string]string, error) {
+	domains, err := cmd.Flags().GetStringArray(domainsFlag)
+	if err != nil {
+		return nil, fmt.Errorf("missing domains: %w", err)
+	}
+	servers, err := cmd.Flags().GetStringArray(serversFlag)
+	if err != nil {
+		return nil, fmt.Errorf("missing servers: %w", err)
+	}
+	if len(domains) != len(servers) {
+		return nil, ErrUnequalDomainServer
+	}
+	return toMapping(domains, servers)
+}
+
+// domainUpsertChanges forwards each wanted domain to a single server. An existing override
+// already pointing at the wanted server is kept, otherwise the first override for the domain is
+// edited in place. Any other override for the domain is removed.
+func domainUpsertChanges(existing []unbound.DomainOverride, wanted map[string]string) unbound.DomainChanges {
+	out := unbound.DomainChanges{}
+	kept := make(map[string]string)
+	for _, domain := range existing {
+		if server, ok := wanted[domain.Domain]; ok && domain.Server == server {
+			if _, done := kept[domain.Domain]; !done {
+				kept[domain.Domain] = domain.UUID
+			}
+		}
+	}
+
+	handled := make(map[string]struct{})
+	for _, domain := range existing {
+		server, ok := wanted[domain.Domain]
+		if !ok {
+			continue
+		}
+		uuid, found := kept[domain.Domain]
+		_, done := handled[domain.Domain]
+		switch {
+		case found && uuid == domain.UUID:
+			handled[domain.Domain] = struct{}{}
+		case !found && !done:
+			out.Update = append(out.Update, unbound.DomainUpdate{Override: domain, Server: server})
+			handled[domain.Domain] = struct{}{}
+		default:
+			out.Delete = append(out.Delete, domain)
+		}
+	}
+
+	for domain, server := range wanted {
+		if _, ok := handled[domain]; ok {
+			continue
+		}
+		out.Create = append(out.Create, unbound.DomainOverride{
+			Enabled: "1",
+			Domain:  domain,
+			Server:  server,
+		})
+	}
+	return out
+}
+
+func domainDeleteChanges(existing []unbound.DomainOverride, toDelete map[string]struct{}) unbound.DomainChanges {
+	out := unbound.DomainChanges{}
+	for _, domain := range existing {
+		if _, ok := toDelete[domain.Domain]; ok {
+			out.Delete = append(out.Delete, domain)
+		}
+	}
+	return out
+}
+
+func printDomains(w io.Writer, domains []unbound.DomainOverride) {
+	writer := tabwriter.NewWriter(w, 0, 5, 5, ' ', 0)
+	fmt.Fprintf(writer, "\n")
+	fmt.Fprint(writer, "Domain\tServer\tEnabled\t\n")
+	for _, domain := range domains {
+		fmt.Fprintf(writer, "%v\t%v\t%v\n", domain.Domain, domain.Server, domain.Enabled)
+	}
+	writer.Flush()
+}
+
+func setDomainsCmdFlags() {
+	domainsUpsertCMD.Flags().StringArray(domainsFlag, []string{}, "domain to forward")
+	domainsUpsertCMD.Flags().StringArray(serversFlag, []string{}, "DNS server address mapping to domains")
+	domainsDeleteCMD.Flags().StringArray(domainsFlag, []string{}, "domain overrides to delete")
+
+	domainsCMD.AddCommand(domainsReadCMD)
+	domainsCMD.AddCommand(domainsUpsertCMD)
+	domainsCMD.AddCommand(domainsDeleteCMD)
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/unbound"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_domainUpsertChanges(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		existing []unbound.DomainOverride
+		wanted   map[string]string
+		want     unbound.DomainChanges
+	}{
+		{
+			name:   "must be created",
+			wanted: map[string]string{"lab.example": "10.0.0.53"},
+			want: unbound.DomainChanges{
+				Create: []unbound.DomainOverride{{Enabled: "1", Domain: "lab.example", Server: "10.0.0.53"}},
+			},
+		},
+		{
+			name: "already exists",
+			existing: []unbound.DomainOverride{
+				{UUID: "uuid-1", Enabled: "1", Domain: "lab.example", Server: "10.0.0.53"},
+				{UUID: "uuid-2", Enabled: "1", Domain: "other.example", Server: "10.0.0.54"},
+			},
+			wanted: map[string]string{"lab.example": "10.0.0.53"},
+			want:   unbound.DomainChanges{},
+		},
+		{
+			name: "server changed in place",
+			existing: []unbound.DomainOverride{
+				{UUID: "uuid-1", Enabled: "1", Domain: "lab.example", Server: "10.0.0.1", Description: "lab"},
+				{UUID: "uuid-2", Enabled: "1", Domain: "lab.example", Server: "10.0.0.2"},
+			},
+			wanted: map[string]string{"lab.example": "10.0.0.53"},
+			want: unbound.DomainChanges{
+				Update: []unbound.DomainUpdate{{
+					Override: unbound.DomainOverride{UUID: "uuid-1", Enabled: "1", Domain: "lab.example", Server: "10.0.0.1", Description: "lab"},
+					Server:   "10.0.0.53",
+				}},
+				Delete: []unbound.DomainOverride{
+					{UUID: "uuid-2", Enabled: "1", Domain: "lab.example", Server: "10.0.0.2"},
+				},
+			},
+		},
+		{
+			name: "server changed and duplicates removed",
+			existing: []unbound.DomainOverride{
+				{UUID: "uuid-1", Enabled: "1", Domain: "lab.example", Server: "10.0.0.1"},
+				{UUID: "uuid-2", Enabled: "1", Domain: "lab.example", Server: "10.0.0.53"},
+				{UUID: "uuid-3", Enabled: "1", Domain: "lab.example", Server: "10.0.0.53"},
+			},
+			wanted: map[string]string{"lab.example": "10.0.0.53"},
+			want: unbound.DomainChanges{
+				Delete: []unbound.DomainOverride{
+					{UUID: "uuid-1", Enabled: "1", Domain: "lab.example", Server: "10.0.0.1"},
+					{UUID: "uuid-3", Enabled: "1", Domain: "lab.example", Server: "10.0.0.53"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, domainUpsertChanges(tt.existing, tt.wanted))
+		})
+	}
+}
+
+func Test_printDomains(t *testing.T) {
+	t.Parallel()
+	w := &bytes.Buffer{}
+	printDomains(w, []unbound.DomainOverride{
+		{Domain: "lab.example", Server: "10.0.0.53", Enabled: "1"},
+	})
+	assert.Equal(t, `
+Domain          Server        Enabled     
+lab.example     10.0.0.53     1
+`, w.String())
+}
+
+func Test_deleteDomains(t *testing.T) {
+	t.Parallel()
+	handler, gotRequests := testhelpers.TestHandler(t, []string{
+		`{"rows": [
+			{"uuid": "uuid-1", "enabled": "1", "domain": "lab.example", "server": "10.0.0.53"},
+			{"uuid": "uuid-2", "enabled": "1", "domain": "other.example", "server": "10.0.0.54"}
+		]}`,
+		testhelpers.DeleteSuccessServResp,
+		testhelpers.ReconfigureResp,
+	})
+	testServe := testhelpers.ServerForTest(t, handler)
+
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	cmd.Flags().StringArray(domainsFlag, []string{}, "")
+	require.NoError(t, cmd.Flags().Set(domainsFlag, "lab.example"))
+
+	require.NoError(t, deleteDomains(testServe.Client(), testServe.Config(), cmd))
+	assert.Equal(t, map[string][]string{
+		unbound.SearchDomainsEndpoint:        {""},
+		unbound.DelDomainEndpoint + "uuid-1": {`"{}"`},
+		unbound.ApplyChangesEndpoint:         {`"{}"`},
+	}, gotRequests)
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// ErrDrift is returned when an instance differs from the primary, so scripts can alert on it.
+	ErrDrift = errors.New("opnsense instances have drifted")
+	// ErrRouted is returned for routed instances, which serve different zones and never match.
+	ErrRouted = errors.New("instances are routed by zone, drift only applies to replicated instances")
+)
+
+var driftCMD = &cobra.Command{
+	Use:     "drift",
+	Short:   "Compares the overrides of every OPNSense instance with the primary",
+	Example: "drift",
+	RunE:    configured(runDrift),
+}
+
+func runDrift(cmd *cobra.Command, _ []string) error {
+	return reportDrift(pkgClient, allConfig, os.Stdout, cmd)
+}
+
+func reportDrift(client *http.Client, cfg config.Config, output io.Writer, cmd *cobra.Command) error {
+	if len(cfg.Routes) > 0 {
+		return ErrRouted
+	}
+
+	provider := unbound.NewFanOut(client, cfg, logger)
+	drift, err := provider.Drift(cmd.Context())
+	for _, d := range drift {
+		fmt.Fprintln(output, d.String())
+	}
+	if err != nil {
+		return fmt.Errorf("drift: %w", err)
+	}
+
+	if len(drift) > 0 {
+		return fmt.Errorf("%d records: %w", len(drift), ErrDrift)
+	}
+	fmt.Fprintln(output, "No drift found")
+	return nil
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func Test_reportDrift(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		backupHost string
+		wantOut    string
+		wantErr    error
+	}{
+		{
+			name:    "in sync",
+			wantOut: "No drift found\n",
+		},
+		{
+			name:       "backup has an extra record",
+			backupHost: "host1.com",
+			wantOut:    "backup host1.com A: not on primary, found 1.2.3.4\n",
+			wantErr:    ErrDrift,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cmd := &cobra.Command{}
+			cmd.SetContext(context.Background())
+			outWriter := &bytes.Buffer{}
+
+			primary := httptest.NewServer(testhelpers.NewFakeOpnsense())
+			defer primary.Close()
+			backup := httptest.NewServer(testhelpers.NewFakeOpnsense())
+			defer backup.Close()
+
+			if tt.backupHost != "" {
+				backupCfg := config.Config{Opnsense: config.Opnsense{BaseURL: backup.URL, Creds: "key:secret"}}
+				require.NoError(t, unbound.New(backup.Client(), backupCfg, logger).ApplyChanges(cmd.Context(), &plan.Changes{
+					Create: []*endpoint.Endpoint{endpoint.NewEndpoint(tt.backupHost, endpoint.RecordTypeA, "1.2.3.4")},
+				}))
+			}
+
+			cfg := config.Config{Opnsense: config.Opnsense{Instances: []config.Instance{
+				{Name: "primary", BaseURL: primary.URL, Creds: "key:secret"},
+				{Name: "backup", BaseURL: backup.URL, Creds: "key:secret"},
+			}}}
+			err := reportDrift(primary.Client(), cfg, outWriter, cmd)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantOut, outWriter.String())
+		})
+	}
+}
+
+func Test_selectInstance(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{Opnsense: config.Opnsense{Instances: []config.Instance{
+		{Name: "main", BaseURL: "https://main.domain.fqdn", Creds: "key:main"},
+		{Name: "backup", BaseURL: "https://backup.domain.fqdn", Creds: "key:backup"},
+	}}}
+
+	tests := []struct {
+		name        string
+		cfg         config.Config
+		instance    string
+		wantBaseURL string
+		wantErr     error
+	}{
+		{
+			name:        "single instance",
+			cfg:         config.Config{Opnsense: config.Opnsense{BaseURL: "https://some.domain.fqdn"}},
+			wantBaseURL: "https://some.domain.fqdn",
+		},
+		{
+			name:        "primary by default",
+			cfg:         cfg,
+			wantBaseURL: "https://main.domain.fqdn",
+		},
+		{
+			name:        "named instance",
+			cfg:         cfg,
+			instance:    "backup",
+			wantBaseURL: "https://backup.domain.fqdn",
+		},
+		{
+			name:     "unknown instance",
+			cfg:      cfg,
+			instance: "other",
+			wantErr:  ErrUnknownInstance,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := selectInstance(tt.cfg, tt.instance)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantBaseURL, got.BaseURL)
+			assert.Empty(t, got.Instances)
+		})
+	}
+}
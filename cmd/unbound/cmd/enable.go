@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound"
+	"github.com/spf13/cobra"
+)
+
+var exampleDisable = fmt.Sprintf("disable --%v=hostname.example.com --%v=host2.example.com", hostsFlag, hostsFlag)
+
+var disableCMD = &cobra.Command{
+	Use:     exampleDisable,
+	Short:   "disables the provided dns overrides in OPNsense unbound, keeping them so they can be enabled again",
+	Example: exampleDisable,
+	RunE:    configured(runDisable),
+}
+
+var exampleEnable = fmt.Sprintf("enable --%v=hostname.example.com --%v=host2.example.com", hostsFlag, hostsFlag)
+
+var enableCMD = &cobra.Command{
+	Use:     exampleEnable,
+	Short:   "enables the provided dns overrides in OPNsense unbound after they were disabled",
+	Example: exampleEnable,
+	RunE:    configured(runEnable),
+}
+
+func runDisable(cmd *cobra.Command, _ []string) error {
+	return deleteEndpoints(pkgClient, recordsConfig(), cmd, unbound.WithSoftDelete())
+}
+
+func runEnable(cmd *cobra.Command, _ []string) error {
+	return enableEndpoints(pkgClient, recordsConfig(), os.Stdout, cmd)
+}
+
+func enableEndpoints(client *http.Client, cfg config.Config, output io.Writer, cmd *cobra.Command, opts ...unbound.Opts) error {
+	hosts, err := cmd.Flags().GetStringArray(hostsFlag)
+	if err != nil {
+		return fmt.Errorf("missing hosts: %w", err)
+	}
+	if len(hosts) == 0 {
+		return ErrMissingHosts
+	}
+	flagOpts, err := applyOpts(cmd)
+	if err != nil {
+		return err
+	}
+
+	provider := newRecordProvider(client, cfg, logger, append(opts, flagOpts...)...)
+	enabled, err := provider.Enable(cmd.Context(), hosts...)
+	if err != nil {
+		return fmt.Errorf("enable: %w", err)
+	}
+	fmt.Fprintf(output, "Enabled %d records\n", enabled)
+	return nil
+}
+
+func setEnableCmdFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray(hostsFlag, []string{}, "FQDN for DNS entries to enable")
+	setApplyFlags(cmd)
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func Test_enableEndpoints(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		hosts   []string
+		wantOut string
+		wantErr error
+	}{
+		{
+			name:    "disabled host",
+			hosts:   []string{"host1.com"},
+			wantOut: "Enabled 1 records\n",
+		},
+		{
+			name:    "unknown host",
+			hosts:   []string{"host2.com"},
+			wantOut: "Enabled 0 records\n",
+		},
+		{
+			name:    "no hosts",
+			wantErr: ErrMissingHosts,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			server := httptest.NewServer(testhelpers.NewFakeOpnsense())
+			defer server.Close()
+			cfg := config.Config{Opnsense: config.Opnsense{BaseURL: server.URL, Creds: "key:secret"}}
+
+			require.NoError(t, unbound.New(server.Client(), cfg, logger).ApplyChanges(ctx, &plan.Changes{
+				Create: []*endpoint.Endpoint{endpoint.NewEndpoint("host1.com", endpoint.RecordTypeA, "1.2.3.4")},
+			}))
+
+			disable := &cobra.Command{}
+			disable.SetContext(ctx)
+			setDeleteCmdFlags(disable)
+			require.NoError(t, disable.Flags().Set(hostsFlag, "host1.com"))
+			require.NoError(t, deleteEndpoints(server.Client(), cfg, disable, unbound.WithSoftDelete()))
+
+			found, err := unbound.New(server.Client(), cfg, logger).FindRecords(ctx, "host1.com")
+			require.NoError(t, err)
+			require.Empty(t, found)
+
+			cmd := &cobra.Command{}
+			cmd.SetContext(ctx)
+			setEnableCmdFlags(cmd)
+			for _, host := range tt.hosts {
+				require.NoError(t, cmd.Flags().Set(hostsFlag, host))
+			}
+			outWriter := &bytes.Buffer{}
+			err = enableEndpoints(server.Client(), cfg, outWriter, cmd)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantOut, outWriter.String())
+		})
+	}
+}
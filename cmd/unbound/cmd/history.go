@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MrUsefull/boundation/internal/audit"
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/spf13/cobra"
+)
+
+const (
+	sinceFlag   = "since"
+	untilFlag   = "until"
+	outcomeFlag = "outcome"
+	jsonFlag    = "json"
+)
+
+var (
+	// ErrNoAuditLog is returned when the history is asked for without an audit log configured.
+	ErrNoAuditLog = errors.New("no audit log configured, set audit.path")
+	// ErrInvalidTime is returned for times that are neither a duration ago nor a RFC 3339 time.
+	ErrInvalidTime = errors.New("invalid time - must be a duration ago, eg 24h, or a RFC 3339 time")
+)
+
+var exampleHistory = fmt.Sprintf("history --%v=hostname.example.com --%v=24h --%v=failure", hostsFlag, sinceFlag, outcomeFlag)
+
+var historyCMD = &cobra.Command{
+	Use:     "history",
+	Short:   "Shows the changes made to OPNsense unbound, from the audit log",
+	Example: exampleHistory,
+	RunE:    configured(runHistory),
+}
+
+func runHistory(cmd *cobra.Command, _ []string) error {
+	return showHistory(pkgConfig, os.Stdout, cmd, time.Now())
+}
+
+func showHistory(cfg config.Config, output io.Writer, cmd *cobra.Command, now time.Time) error {
+	if cfg.Audit.Path == "" {
+		return ErrNoAuditLog
+	}
+
+	query, err := parseHistoryFlags(cmd, now)
+	if err != nil {
+		return err
+	}
+	entries, err := audit.Read(cfg.Audit.Path, query)
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+
+	asJSON, err := cmd.Flags().GetBool(jsonFlag)
+	if err != nil {
+		return fmt.Errorf("json flag: %w", err)
+	}
+	if asJSON {
+		encoder := json.NewEncoder(output)
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return fmt.Errorf("print history: %w", err)
+			}
+		}
+		return nil
+	}
+
+	printHistory(output, entries)
+	return nil
+}
+
+func parseHistoryFlags(cmd *cobra.Command, now time.Time) (audit.Query, error) {
+	query := audit.Query{}
+
+	var err error
+	if query.Host, err = cmd.Flags().GetString(hostsFlag); err != nil {
+		return query, fmt.Errorf("host flag: %w", err)
+	}
+	if query.Outcome, err = cmd.Flags().GetString(outcomeFlag); err != nil {
+		return query, fmt.Errorf("outcome flag: %w", err)
+	}
+
+	for flag, bound := range map[string]*time.Time{sinceFlag: &query.Since, untilFlag: &query.Until} {
+		value, err := cmd.Flags().GetString(flag)
+		if err != nil {
+			return query, fmt.Errorf("%v flag: %w", flag, err)
+		}
+		if *bound, err = parseTime(value, now); err != nil {
+			return query, fmt.Errorf("%v: %w", flag, err)
+		}
+	}
+
+	return query, query.Validate()
+}
+
+// parseTime reads a duration ago, eg 24h, or a RFC 3339 time. Empty values are the zero time.
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil {
+		return now.Add(-ago), nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q: %w", value, ErrInvalidTime)
+	}
+	return parsed, nil
+}
+
+func printHistory(w io.Writer, entries []audit.Entry) {
+	writer := tabwriter.NewWriter(w, 0, 5, 5, ' ', 0)
+	fmt.Fprint(writer, "Time\tSource\tInstance\tAction\tOutcome\tHosts\tRows\tError\t\n")
+	for _, entry := range entries {
+		rows := make([]string, 0, len(entry.Changes))
+		for _, change := range entry.Changes {
+			row := change.Kind + " " + change.UUID
+			if change.Rollback {
+				row = "rollback " + row
+			}
+			rows = append(rows, row)
+		}
+		fmt.Fprintf(writer, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t\n",
+			entry.Time.Local().Format(time.RFC3339), entry.Source, entry.Instance, entry.Action,
+			entry.Outcome, strings.Join(entry.Hosts, ","), strings.Join(rows, ","), strings.ReplaceAll(entry.Error, "\n", "; "))
+	}
+	writer.Flush()
+}
+
+func setHistoryCmdFlags(cmd *cobra.Command) {
+	cmd.Flags().String(hostsFlag, "", "only show changes to this FQDN")
+	cmd.Flags().String(sinceFlag, "", "only show changes after this time, a duration ago like 24h or a RFC 3339 time")
+	cmd.Flags().String(untilFlag, "", "only show changes before this time, a duration ago like 24h or a RFC 3339 time")
+	cmd.Flags().String(outcomeFlag, "", "only show changes that ended in \"success\" or \"failure\"")
+	cmd.Flags().Bool(jsonFlag, false, "print the matching audit log entries as JSON lines")
+}
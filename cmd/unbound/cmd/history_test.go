@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MrUsefull/boundation/internal/audit"
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_showHistory(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log := audit.New(path)
+	require.NoError(t, log.Append(audit.Entry{
+		Time:     now.Add(-48 * time.Hour),
+		Source:   audit.Source{Kind: audit.SourceCLI, User: "alice", Hostname: "laptop"},
+		Instance: config.PrimaryInstance,
+		Action:   "apply",
+		Hosts:    []string{"host1.com"},
+		Changes:  []audit.Change{{Kind: "create", UUID: "uuid-1", DNSName: "host1.com", RecordType: "A", Target: "1.2.3.4"}},
+		Outcome:  audit.OutcomeSuccess,
+	}))
+	require.NoError(t, log.Append(audit.Entry{
+		Time:     now.Add(-time.Hour),
+		Source:   audit.Source{Kind: audit.SourceWebhook, RemoteAddr: "10.0.0.5:41234"},
+		Instance: config.PrimaryInstance,
+		Action:   "apply",
+		Hosts:    []string{"host1.com", "host2.com"},
+		Changes: []audit.Change{
+			{Kind: "delete", UUID: "uuid-1", DNSName: "host1.com", RecordType: "A", Target: "1.2.3.4"},
+			{Kind: "create", UUID: "uuid-2", DNSName: "host1.com", RecordType: "A", Target: "1.2.3.4", Rollback: true},
+		},
+		Outcome: audit.OutcomeFailure,
+		Error:   "too many deletions\nchanges rolled back",
+	}))
+
+	tests := []struct {
+		name      string
+		path      string
+		flags     map[string]string
+		wantLines int
+		want      []string
+		wantErr   error
+	}{
+		{
+			name:      "everything",
+			path:      path,
+			wantLines: 3,
+			want:      []string{"cli alice@laptop", "create uuid-1", "webhook 10.0.0.5:41234", "delete uuid-1,rollback create uuid-2", "too many deletions; changes rolled back"},
+		},
+		{
+			name:      "host",
+			path:      path,
+			flags:     map[string]string{hostsFlag: "host2.com"},
+			wantLines: 2,
+			want:      []string{"failure"},
+		},
+		{
+			name:      "since a duration ago",
+			path:      path,
+			flags:     map[string]string{sinceFlag: "24h"},
+			wantLines: 2,
+			want:      []string{"webhook"},
+		},
+		{
+			name:      "until a time",
+			path:      path,
+			flags:     map[string]string{untilFlag: now.Add(-24 * time.Hour).Format(time.RFC3339), outcomeFlag: audit.OutcomeSuccess},
+			wantLines: 2,
+			want:      []string{"alice"},
+		},
+		{
+			name:      "json",
+			path:      path,
+			flags:     map[string]string{outcomeFlag: audit.OutcomeFailure, jsonFlag: "true"},
+			wantLines: 1,
+			want:      []string{`"remoteAddr":"10.0.0.5:41234"`},
+		},
+		{
+			name:    "invalid time",
+			path:    path,
+			flags:   map[string]string{sinceFlag: "yesterday"},
+			wantErr: ErrInvalidTime,
+		},
+		{
+			name:    "invalid outcome",
+			path:    path,
+			flags:   map[string]string{outcomeFlag: "rolled back"},
+			wantErr: audit.ErrInvalidOutcome,
+		},
+		{
+			name:    "no audit log",
+			wantErr: ErrNoAuditLog,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cmd := &cobra.Command{}
+			setHistoryCmdFlags(cmd)
+			for flag, value := range tt.flags {
+				require.NoError(t, cmd.Flags().Set(flag, value))
+			}
+			output := &bytes.Buffer{}
+
+			err := showHistory(config.Config{Audit: config.Audit{Path: tt.path}}, output, cmd, now)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, strings.Split(strings.TrimSpace(output.String()), "\n"), tt.wantLines)
+			for _, want := range tt.want {
+				assert.Contains(t, output.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound"
+	"github.com/spf13/cobra"
+)
+
+var migrateCMD = &cobra.Command{
+	Use:     "migrate",
+	Short:   "Rewrites legacy base64 descriptions in the versioned metadata format",
+	Example: "migrate",
+	RunE:    configured(runMigrate),
+}
+
+func runMigrate(cmd *cobra.Command, _ []string) error {
+	return migrateDescriptions(pkgClient, pkgConfig, os.Stdout, cmd)
+}
+
+func migrateDescriptions(client *http.Client, cfg config.Config, output io.Writer, cmd *cobra.Command, opts ...unbound.Opts) error {
+	provider := unbound.New(client, cfg, logger, opts...)
+	migrated, err := provider.MigrateDescriptions(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	fmt.Fprintf(output, "Migrated %d records\n", migrated)
+	return nil
+}
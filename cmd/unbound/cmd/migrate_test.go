@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/unbound"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_migrateDescriptions(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name           string
+		serveResponses []string
+		wantOut        string
+		wantErr        error
+	}{
+		{
+			name: "legacy description is rewritten",
+			serveResponses: []string{
+				`{"rows": [{"uuid": "some-uuid-here", "hostname": "host1", "domain": "com", "rr": "A", "server": "1.2.3.4", "enabled": "1", "description": "Managed by K8s external-dns aGVyaXRhZ2U9ZXh0ZXJuYWwtZG5zLGV4dGVybmFsLWRucy9vd25lcj1kZWZhdWx0"}]}`, //nolint:lll
+				`{"rows": []}`,
+				testhelpers.CreateSuccessServResp,
+				testhelpers.ReconfigureResp,
+			},
+			wantOut: "Migrated 1 records\n",
+		},
+		{
+			name: "read failure",
+			serveResponses: []string{
+				`not json`,
+			},
+			wantErr: unbound.ErrMarshalling,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cmd := &cobra.Command{}
+			cmd.SetContext(context.Background())
+			outWriter := &bytes.Buffer{}
+			handler, _ := testhelpers.TestHandler(t, tt.serveResponses)
+			testServe := testhelpers.ServerForTest(t, handler)
+			err := migrateDescriptions(testServe.Client(), testServe.Config(), outWriter, cmd, unbound.WithClock(testClock))
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantOut, outWriter.String())
+		})
+	}
+}
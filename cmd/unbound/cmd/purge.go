@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound"
+	"github.com/spf13/cobra"
+)
+
+var purgeCMD = &cobra.Command{
+	Use:     "purge",
+	Short:   "Deletes the overrides disabled longer ago than the soft delete retention",
+	Example: "purge",
+	RunE:    configured(runPurge),
+}
+
+func runPurge(cmd *cobra.Command, _ []string) error {
+	return purgeEndpoints(pkgClient, recordsConfig(), os.Stdout, cmd)
+}
+
+func purgeEndpoints(client *http.Client, cfg config.Config, output io.Writer, cmd *cobra.Command, opts ...unbound.Opts) error {
+	flagOpts, err := applyOpts(cmd)
+	if err != nil {
+		return err
+	}
+
+	provider := newRecordProvider(client, cfg, logger, append(opts, flagOpts...)...)
+	purged, err := provider.Purge(cmd.Context())
+	fmt.Fprintf(output, "Purged %d records\n", purged)
+	if err != nil {
+		return fmt.Errorf("purge: %w", err)
+	}
+	return nil
+}
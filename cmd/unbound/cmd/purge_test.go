@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func Test_purgeEndpoints(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		after   time.Duration
+		wantOut string
+	}{
+		{
+			name:    "within retention",
+			after:   time.Hour,
+			wantOut: "Purged 0 records\n",
+		},
+		{
+			name:    "past retention",
+			after:   25 * time.Hour,
+			wantOut: "Purged 1 records\n",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			server := httptest.NewServer(testhelpers.NewFakeOpnsense())
+			defer server.Close()
+			cfg := config.Config{
+				Opnsense:   config.Opnsense{BaseURL: server.URL, Creds: "key:secret"},
+				SoftDelete: config.SoftDelete{Enabled: true, Retention: 24 * time.Hour},
+			}
+
+			created := endpoint.NewEndpoint("host1.com", endpoint.RecordTypeA, "1.2.3.4")
+			provider := unbound.New(server.Client(), cfg, logger, unbound.WithClock(testClock))
+			require.NoError(t, provider.ApplyChanges(ctx, &plan.Changes{Create: []*endpoint.Endpoint{created}}))
+			current, err := provider.Records(ctx)
+			require.NoError(t, err)
+			require.NoError(t, provider.ApplyChanges(ctx, &plan.Changes{Delete: current}))
+
+			cmd := &cobra.Command{}
+			cmd.SetContext(ctx)
+			setApplyFlags(cmd)
+			outWriter := &bytes.Buffer{}
+			later := unbound.WithClock(func() time.Time { return testClock().Add(tt.after) })
+			require.NoError(t, purgeEndpoints(server.Client(), cfg, outWriter, cmd, later))
+			assert.Equal(t, tt.wantOut, outWriter.String())
+		})
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"text/tabwriter"
 
 	"github.com/MrUsefull/boundation/internal/config"
-	"github.com/MrUsefull/boundation/internal/unbound"
 	"github.com/spf13/cobra"
 	"sigs.k8s.io/external-dns/endpoint"
 )
@@ -21,12 +20,12 @@ var readCMD = &cobra.Command{
 }
 
 func runRead(cmd *cobra.Command, _ []string) error {
-	return readEndpoints(http.DefaultClient, pkgConfig, os.Stdout, cmd)
+	return readEndpoints(pkgClient, recordsConfig(), os.Stdout, cmd)
 }
 
 func readEndpoints(client *http.Client, cfg config.Config, output io.Writer, cmd *cobra.Command) error {
 	ctx := cmd.Context()
-	provider := unbound.New(client, cfg, logger)
+	provider := newRecordProvider(client, cfg, logger)
 	found, err := provider.Records(ctx)
 	if err != nil {
 		return fmt.Errorf("read records: %w", err)
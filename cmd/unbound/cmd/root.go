@@ -4,20 +4,35 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/user"
 	"path"
 
+	"github.com/MrUsefull/boundation/internal/audit"
 	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound"
 	"github.com/spf13/cobra"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
 )
 
 var (
 	// cfgFile is the path to the config file.
 	cfgFile string
 
+	// pkgConfig is the loaded config, narrowed to the instance selected by instanceName.
 	pkgConfig config.Config
+	// allConfig is the loaded config with every instance.
+	allConfig config.Config
+	// instanceName selects the instance managed when several are configured.
+	instanceName string
+	// pkgClient reaches opnsense, built from pkgConfig.
+	pkgClient *http.Client
 
 	logger = slog.New(
 		slog.NewTextHandler(
@@ -40,8 +55,13 @@ var (
 )
 
 const (
+	instanceFlag      = "instance"
 	hostsFlag         = "host"
 	targetsFlag       = "target"
+	domainsFlag       = "domain"
+	serversFlag       = "server"
+	dryRunFlag        = "dry-run"
+	forceFlag         = "force"
 	defaultConfigFile = "/unbound.yml"
 )
 
@@ -70,15 +90,32 @@ func init() {
 		cfgFile = path.Join(homedir, ".unbound", defaultConfigFile)
 		rootCmd.PersistentFlags().StringVar(&cfgFile, "config", cfgFile, "config file path")
 	}
+	rootCmd.PersistentFlags().StringVar(&instanceName, instanceFlag, "",
+		"name of the OPNSense instance to manage when several are configured, the primary by default")
 
 	setCreateCmdFlags(upsertCMD)
 	setDeleteCmdFlags(deleteCMD)
+	setDeleteCmdFlags(disableCMD)
+	setEnableCmdFlags(enableCMD)
+	setApplyFlags(purgeCMD)
+	setHistoryCmdFlags(historyCMD)
+	setDomainsCmdFlags()
 	rootCmd.AddCommand(upsertCMD)
 	rootCmd.AddCommand(configureCMD)
 	rootCmd.AddCommand(readCMD)
 	rootCmd.AddCommand(deleteCMD)
+	rootCmd.AddCommand(domainsCMD)
+	rootCmd.AddCommand(migrateCMD)
+	rootCmd.AddCommand(driftCMD)
+	rootCmd.AddCommand(disableCMD)
+	rootCmd.AddCommand(enableCMD)
+	rootCmd.AddCommand(purgeCMD)
+	rootCmd.AddCommand(historyCMD)
 }
 
+// ErrUnknownInstance is returned when --instance names no configured instance.
+var ErrUnknownInstance = errors.New("unknown opnsense instance")
+
 type runEFn func(cmd *cobra.Command, args []string) error
 
 // configured decorates wrapped to ensure configuration is loaded correctly.
@@ -88,8 +125,132 @@ func configured(wrapped runEFn) runEFn {
 		if err != nil {
 			return fmt.Errorf("configuration: %w", err)
 		}
-		pkgConfig = loadedCfg
+		allConfig = loadedCfg
+		pkgConfig, err = selectInstance(loadedCfg, instanceName)
+		if err != nil {
+			return err
+		}
 		logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{AddSource: true, Level: pkgConfig.LogLevel}))
+		client, err := unbound.NewClient(pkgConfig.Opnsense, logger)
+		if err != nil {
+			return fmt.Errorf("opnsense client: %w", err)
+		}
+		pkgClient = client
+		cmd.SetContext(audit.WithSource(cmd.Context(), cliSource()))
 		return wrapped(cmd, args)
 	}
 }
+
+// cliSource is the user and host running the CLI, recorded in the audit log as the source of its changes.
+func cliSource() audit.Source {
+	source := audit.Source{Kind: audit.SourceCLI, User: os.Getenv("USER")}
+	if current, err := user.Current(); err == nil {
+		source.User = current.Username
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		source.Hostname = hostname
+	}
+	return source
+}
+
+// selectInstance narrows cfg to the named instance, or the primary when name is empty.
+func selectInstance(cfg config.Config, name string) (config.Config, error) {
+	if len(cfg.Instances) == 0 && name == "" {
+		return cfg, nil
+	}
+
+	for _, instance := range cfg.AllInstances() {
+		if name == "" || instance.Name == name {
+			return cfg.ForInstance(instance), nil
+		}
+	}
+	return cfg, fmt.Errorf("%q: %w", name, ErrUnknownInstance)
+}
+
+// recordsConfig is the config records are managed with: every instance when several are
+// configured and no instance is selected, otherwise the selected instance.
+func recordsConfig() config.Config {
+	if len(allConfig.Instances) > 0 && instanceName == "" {
+		return allConfig
+	}
+	return pkgConfig
+}
+
+// domainsConfig is the config domain overrides are managed with: every instance when several are
+// configured without routes and no instance is selected, otherwise the selected instance.
+func domainsConfig() config.Config {
+	if len(allConfig.Routes) > 0 {
+		return pkgConfig
+	}
+	return recordsConfig()
+}
+
+// recordProvider manages the records of a single instance, of every instance, or of every routed instance.
+type recordProvider interface {
+	recordFinder
+	Records(ctx context.Context) ([]*endpoint.Endpoint, error)
+	ApplyChanges(ctx context.Context, changes *plan.Changes) error
+	Enable(ctx context.Context, dnsNames ...string) (int, error)
+	Purge(ctx context.Context) (int, error)
+}
+
+// newRecordProvider routes records by zone when cfg has routes, writes them to every instance
+// when cfg has several, and manages its single instance otherwise.
+func newRecordProvider(client *http.Client, cfg config.Config, logger *slog.Logger, opts ...unbound.Opts) recordProvider {
+	switch {
+	case len(cfg.Routes) > 0:
+		return unbound.NewRouter(client, cfg, logger, opts...)
+	case len(cfg.Instances) > 0:
+		return unbound.NewFanOut(client, cfg, logger, opts...)
+	default:
+		return unbound.New(client, cfg, logger, opts...)
+	}
+}
+
+// domainProvider manages the domain overrides of a single instance, or of every instance.
+type domainProvider interface {
+	DomainOverrides(ctx context.Context) ([]unbound.DomainOverride, error)
+	ApplyDomainChanges(ctx context.Context, changes unbound.DomainChanges) error
+}
+
+// newDomainProvider writes domain overrides to every instance when cfg has several, and manages
+// its single instance otherwise.
+func newDomainProvider(client *http.Client, cfg config.Config, logger *slog.Logger) domainProvider {
+	if len(cfg.Instances) > 0 {
+		return unbound.NewFanOut(client, cfg, logger)
+	}
+	return unbound.New(client, cfg, logger)
+}
+
+// setApplyFlags adds the flags controlling how changes are applied to cmd.
+func setApplyFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool(dryRunFlag, false, "print the opnsense api calls that would change records instead of making them")
+	cmd.Flags().Bool(forceFlag, false, "change records not managed by external-dns, and ignore the deletion limit")
+}
+
+// applyOpts makes the provider print its planned changes to the output of cmd instead of
+// sending them when --dry-run is set, and lifts the safeguards when --force is set.
+func applyOpts(cmd *cobra.Command) ([]unbound.Opts, error) {
+	var opts []unbound.Opts
+
+	dryRun, err := cmd.Flags().GetBool(dryRunFlag)
+	if err != nil {
+		return nil, fmt.Errorf("dry run flag: %w", err)
+	}
+	if dryRun {
+		out := cmd.OutOrStdout()
+		opts = append(opts, unbound.WithDryRun(func(_ context.Context, request unbound.PlannedRequest) {
+			fmt.Fprintln(out, request)
+		}))
+	}
+
+	force, err := cmd.Flags().GetBool(forceFlag)
+	if err != nil {
+		return nil, fmt.Errorf("force flag: %w", err)
+	}
+	if force {
+		opts = append(opts, unbound.WithForce())
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_everyInstance runs the commands changing records and domains against a primary and a
+// backup, checking each change is written to both.
+func Test_everyInstance(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	primary := testhelpers.NewFakeOpnsense()
+	primaryServer := httptest.NewServer(primary)
+	defer primaryServer.Close()
+	backup := testhelpers.NewFakeOpnsense()
+	backupServer := httptest.NewServer(backup)
+	defer backupServer.Close()
+
+	cfg := config.Config{
+		Opnsense: config.Opnsense{Instances: []config.Instance{
+			{Name: "primary", BaseURL: primaryServer.URL, Creds: "key:secret"},
+			{Name: "backup", BaseURL: backupServer.URL, Creds: "key:secret"},
+		}},
+		SoftDelete: config.SoftDelete{Enabled: true, Retention: 24 * time.Hour},
+	}
+	client := primaryServer.Client()
+	clock := unbound.WithClock(testClock)
+	later := unbound.WithClock(func() time.Time { return testClock().Add(25 * time.Hour) })
+
+	hostsCmd := func(setFlags func(cmd *cobra.Command)) *cobra.Command {
+		cmd := &cobra.Command{}
+		cmd.SetContext(ctx)
+		setFlags(cmd)
+		require.NoError(t, cmd.Flags().Set(hostsFlag, "host1.com"))
+		return cmd
+	}
+
+	steps := []struct {
+		name     string
+		run      func() error
+		wantPath string
+		want     int
+	}{
+		{
+			name: "upsert",
+			run: func() error {
+				cmd := hostsCmd(setCreateCmdFlags)
+				require.NoError(t, cmd.Flags().Set(targetsFlag, "1.2.3.4"))
+				return newUpsert(client, cfg, logger, clock).doUpsert(cmd)
+			},
+			wantPath: unbound.AddOverrideEndpoint,
+			want:     1,
+		},
+		{
+			name: "disable",
+			run: func() error {
+				return deleteEndpoints(client, cfg, hostsCmd(setDeleteCmdFlags), clock, unbound.WithSoftDelete())
+			},
+			wantPath: unbound.ToggleOverrideEndpoint + "uuid-1/0",
+			want:     1,
+		},
+		{
+			name: "enable",
+			run: func() error {
+				return enableEndpoints(client, cfg, &bytes.Buffer{}, hostsCmd(setEnableCmdFlags), clock)
+			},
+			wantPath: unbound.ToggleOverrideEndpoint + "uuid-1/1",
+			want:     1,
+		},
+		{
+			// soft delete is enabled, so deleting disables
+			name: "delete",
+			run: func() error {
+				return deleteEndpoints(client, cfg, hostsCmd(setDeleteCmdFlags), clock)
+			},
+			wantPath: unbound.ToggleOverrideEndpoint + "uuid-1/0",
+			want:     2,
+		},
+		{
+			name: "purge",
+			run: func() error {
+				return purgeEndpoints(client, cfg, &bytes.Buffer{}, hostsCmd(setDeleteCmdFlags), later)
+			},
+			wantPath: unbound.DelOverrideEndpoint + "uuid-1",
+			want:     1,
+		},
+		{
+			name: "domains upsert",
+			run: func() error {
+				cmd := &cobra.Command{}
+				cmd.SetContext(ctx)
+				cmd.Flags().StringArray(domainsFlag, []string{}, "")
+				cmd.Flags().StringArray(serversFlag, []string{}, "")
+				require.NoError(t, cmd.Flags().Set(domainsFlag, "lab.example"))
+				require.NoError(t, cmd.Flags().Set(serversFlag, "10.0.0.53"))
+				return upsertDomains(client, cfg, cmd)
+			},
+			wantPath: unbound.AddDomainEndpoint,
+			want:     1,
+		},
+	}
+	for _, step := range steps {
+		require.NoError(t, step.run(), step.name)
+		assert.Equal(t, step.want, countPath(primary.Requests(), step.wantPath), "%s on the primary", step.name)
+		assert.Equal(t, step.want, countPath(backup.Requests(), step.wantPath), "%s on the backup", step.name)
+	}
+}
+
+func countPath(requests []string, path string) int {
+	count := 0
+	for _, request := range requests {
+		if request == path {
+			count++
+		}
+	}
+	return count
+}
@@ -4,7 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/MrUsefull/boundation/internal/config"
 	"github.com/MrUsefull/boundation/internal/unbound"
@@ -33,7 +36,11 @@ var upsertCMD = &cobra.Command{
 }
 
 func runUpsert(cmd *cobra.Command, _ []string) error {
-	creator := newUpsert(http.DefaultClient, pkgConfig, logger)
+	opts, err := applyOpts(cmd)
+	if err != nil {
+		return err
+	}
+	creator := newUpsert(pkgClient, recordsConfig(), logger, opts...)
 	return creator.doUpsert(cmd)
 }
 
@@ -62,13 +69,13 @@ func toMapping(hosts []string, targets []string) (map[string]string, error) {
 
 type upsert struct {
 	logger   *slog.Logger
-	provider *unbound.Unbound
+	provider recordProvider
 }
 
-func newUpsert(client *http.Client, cfg config.Config, logger *slog.Logger) *upsert {
+func newUpsert(client *http.Client, cfg config.Config, logger *slog.Logger, opts ...unbound.Opts) *upsert {
 	return &upsert{
 		logger:   logger,
-		provider: unbound.New(client, cfg, logger),
+		provider: newRecordProvider(client, cfg, logger, opts...),
 	}
 }
 
@@ -79,7 +86,13 @@ func (c *upsert) doUpsert(cmd *cobra.Command) error {
 		return err
 	}
 
-	existing, err := c.provider.Records(ctx)
+	hosts := make([]string, 0, len(hostMappings))
+	for host := range hostMappings {
+		hosts = append(hosts, host)
+	}
+	slices.Sort(hosts)
+
+	existing, err := c.provider.FindRecords(ctx, hosts...)
 	if err != nil {
 		return fmt.Errorf("unable to read existing records: %w", err)
 	}
@@ -94,14 +107,35 @@ func (c *upsert) doUpsert(cmd *cobra.Command) error {
 
 func (c *upsert) createChangeSet(existing []*endpoint.Endpoint, hostMappings map[string]string) *plan.Changes {
 	out := &plan.Changes{}
+	// handled tracks the hosts already satisfied by an existing record of the type of their target,
+	// any further records of that type are removed. Records of other types, eg the MX record next
+	// to an A record, are left alone.
+	handled := make(map[endpoint.EndpointKey]struct{})
 	for _, ep := range existing {
-		if val, ok := hostMappings[ep.DNSName]; ok && val == ep.Targets[0] {
-			delete(hostMappings, ep.DNSName)
-		} else if ok && val != ep.Targets[0] {
+		val, ok := hostMappings[ep.DNSName]
+		if !ok || ep.RecordType != targetType(val) {
+			continue
+		}
+		key := endpoint.EndpointKey{DNSName: ep.DNSName, RecordType: ep.RecordType}
+		if _, ok := handled[key]; ok {
 			out.Delete = append(out.Delete, ep)
+			continue
+		}
+		handled[key] = struct{}{}
+		if len(ep.Targets) != 1 || val != ep.Targets[0] {
+			// update in place so the host keeps resolving
+			out.UpdateOld = append(out.UpdateOld, ep)
+			out.UpdateNew = append(out.UpdateNew, endpoint.NewEndpoint(ep.DNSName, ep.RecordType, val))
 		}
 	}
-	out.Create = toEndpoints(hostMappings)
+
+	missing := make(map[string]string, len(hostMappings))
+	for host, val := range hostMappings {
+		if _, ok := handled[endpoint.EndpointKey{DNSName: host, RecordType: targetType(val)}]; !ok {
+			missing[host] = val
+		}
+	}
+	out.Create = toEndpoints(missing)
 	c.logger.Info("create plan created", slog.Any("plan", out))
 	return out
 }
@@ -109,12 +143,25 @@ func (c *upsert) createChangeSet(existing []*endpoint.Endpoint, hostMappings map
 func toEndpoints(in map[string]string) []*endpoint.Endpoint {
 	out := make([]*endpoint.Endpoint, 0, len(in))
 	for host, target := range in {
-		out = append(out, endpoint.NewEndpoint(host, endpoint.RecordTypeA, target))
+		out = append(out, endpoint.NewEndpoint(host, targetType(target), target))
 	}
 	return out
 }
 
+// targetType infers the record type of target. A "priority host" pair such as
+// "10 mail.example.com" is an MX record, an IPv6 address is AAAA and anything else is A.
+func targetType(target string) string {
+	if len(strings.Fields(target)) == 2 {
+		return endpoint.RecordTypeMX
+	}
+	if ip := net.ParseIP(target); ip != nil && ip.To4() == nil {
+		return endpoint.RecordTypeAAAA
+	}
+	return endpoint.RecordTypeA
+}
+
 func setCreateCmdFlags(cmd *cobra.Command) {
 	cmd.Flags().StringArray(hostsFlag, []string{}, "FQDN for DNS entries to add")
-	cmd.Flags().StringArray(targetsFlag, []string{}, "ip addresses mapping to hosts")
+	cmd.Flags().StringArray(targetsFlag, []string{}, "ip addresses or MX \"priority host\" pairs mapping to hosts")
+	setApplyFlags(cmd)
 }
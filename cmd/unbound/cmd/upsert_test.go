@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/MrUsefull/boundation/internal/unbound"
 	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
@@ -12,8 +13,14 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
 )
 
+// testClock is the fixed clock of providers under test, so created times are predictable.
+func testClock() time.Time {
+	return time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+}
+
 func Test_toEndpoints(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
@@ -32,6 +39,17 @@ func Test_toEndpoints(t *testing.T) {
 				endpoint.NewEndpoint("host2.fqdn", endpoint.RecordTypeA, "5.6.7.8"),
 			},
 		},
+		{
+			name: "Record type from target",
+			in: map[string]string{
+				"host1.fqdn": "fd00::1",
+				"host2.fqdn": "10 mail.fqdn",
+			},
+			want: []*endpoint.Endpoint{
+				endpoint.NewEndpoint("host1.fqdn", endpoint.RecordTypeAAAA, "fd00::1"),
+				endpoint.NewEndpoint("host2.fqdn", endpoint.RecordTypeMX, "10 mail.fqdn"),
+			},
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -149,7 +167,7 @@ func Test_create_doUpsert(t *testing.T) {
 			wantRequests: map[string][]string{
 				unbound.SearchOverridesEndpoint: {""},
 				unbound.AddOverrideEndpoint: {
-					`{"host":{"hostname":"host1","domain":"com","rr":"A","server":"1.2.3.4","enabled":"1","description":"Managed by K8s external-dns "}}`, //nolint
+					`{"host":{"hostname":"host1","domain":"com","rr":"A","server":"1.2.3.4","enabled":"1","description":"extdns/1 t=A c=2024-05-01T10:00:00Z"}}`, //nolint
 				},
 				unbound.ApplyChangesEndpoint: {`"{}"`},
 			},
@@ -193,23 +211,22 @@ func Test_create_doUpsert(t *testing.T) {
 			serveResponses: []string{
 				requireGenerateReadResponse(t, []unbound.Record{
 					{
-						UUID:     "some-uuid-here",
-						Hostname: "host1",
-						Domain:   "domain.com",
-						Rr:       "A",
-						Server:   "5.6.7.8",
-						Enabled:  "1",
+						UUID:        "some-uuid-here",
+						Hostname:    "host1",
+						Domain:      "domain.com",
+						Rr:          "A",
+						Server:      "5.6.7.8",
+						Enabled:     "1",
+						Description: "extdns/1 t=A c=2024-05-01T10:00:00Z",
 					},
 				}),
-				requiregenerateOpResponse(t, unbound.DeleteOpSuccessResponse),
-				requiregenerateOpResponse(t, unbound.CreateOpSuccessResponse),
+				requiregenerateOpResponse(t, unbound.UpdateOpSuccessResponse),
 				testhelpers.ReconfigureResp,
 			},
 			wantRequests: map[string][]string{
-				fmt.Sprintf("%v%v", unbound.DelOverrideEndpoint, "some-uuid-here"): {`"{}"`},
-				unbound.SearchOverridesEndpoint:                                    {""},
-				unbound.AddOverrideEndpoint: {
-					`{"host":{"hostname":"host1","domain":"domain.com","rr":"A","server":"1.2.3.4","enabled":"1","description":"Managed by K8s external-dns "}}`, //nolint
+				unbound.SearchOverridesEndpoint: {""},
+				fmt.Sprintf("%v%v", unbound.SetOverrideEndpoint, "some-uuid-here"): {
+					`{"host":{"hostname":"host1","domain":"domain.com","rr":"A","server":"1.2.3.4","enabled":"1","description":"extdns/1 t=A c=2024-05-01T10:00:00Z"}}`, //nolint
 				},
 				unbound.ApplyChangesEndpoint: {`"{}"`},
 			},
@@ -222,6 +239,39 @@ func Test_create_doUpsert(t *testing.T) {
 				return cmd
 			}(),
 		},
+		{
+			name: "happy path - MX next to an A record",
+			serveResponses: []string{
+				requireGenerateReadResponse(t, []unbound.Record{
+					{
+						UUID:        "some-uuid-here",
+						Hostname:    "host1",
+						Domain:      "domain.com",
+						Rr:          "A",
+						Server:      "5.6.7.8",
+						Enabled:     "1",
+						Description: "extdns/1 t=A c=2024-05-01T10:00:00Z",
+					},
+				}),
+				testhelpers.CreateSuccessServResp,
+				testhelpers.ReconfigureResp,
+			},
+			wantRequests: map[string][]string{
+				unbound.SearchOverridesEndpoint: {""},
+				unbound.AddOverrideEndpoint: {
+					`{"host":{"hostname":"host1","domain":"domain.com","rr":"MX","server":"","mxprio":"10","mx":"mail.domain.com","enabled":"1","description":"extdns/1 t=MX c=2024-05-01T10:00:00Z"}}`, //nolint
+				},
+				unbound.ApplyChangesEndpoint: {`"{}"`},
+			},
+			cmd: func() *cobra.Command {
+				cmd := &cobra.Command{}
+				cmd.SetContext(context.Background())
+				setCreateCmdFlags(cmd)
+				require.NoError(t, cmd.Flags().Set(hostsFlag, "host1.domain.com"))
+				require.NoError(t, cmd.Flags().Set(targetsFlag, "10 mail.domain.com"))
+				return cmd
+			}(),
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -229,13 +279,61 @@ func Test_create_doUpsert(t *testing.T) {
 			t.Parallel()
 			handler, gotRequests := testhelpers.TestHandler(t, tt.serveResponses)
 			testServe := testhelpers.ServerForTest(t, handler)
-			c := newUpsert(testServe.Client(), testServe.Config(), logger)
+			c := newUpsert(testServe.Client(), testServe.Config(), logger, unbound.WithClock(testClock))
 			assert.NoError(t, c.doUpsert(tt.cmd))
 			assert.Equal(t, tt.wantRequests, gotRequests)
 		})
 	}
 }
 
+func Test_upsert_createChangeSet(t *testing.T) {
+	t.Parallel()
+
+	mx := endpoint.NewEndpoint("example.com", endpoint.RecordTypeMX, "10 mail.example.com")
+	a := endpoint.NewEndpoint("example.com", endpoint.RecordTypeA, "1.1.1.1")
+	txt := endpoint.NewEndpoint("a-example.com", endpoint.RecordTypeTXT, "heritage=external-dns")
+
+	tests := []struct {
+		name     string
+		existing []*endpoint.Endpoint
+		mappings map[string]string
+		want     *plan.Changes
+	}{
+		{
+			name:     "updates the record of the target type",
+			existing: []*endpoint.Endpoint{mx, a, txt},
+			mappings: map[string]string{"example.com": "2.2.2.2"},
+			want: &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{a},
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("example.com", endpoint.RecordTypeA, "2.2.2.2")},
+				Create:    []*endpoint.Endpoint{},
+			},
+		},
+		{
+			name:     "creates a record next to one of another type",
+			existing: []*endpoint.Endpoint{a},
+			mappings: map[string]string{"example.com": "10 mail.example.com"},
+			want: &plan.Changes{
+				Create: []*endpoint.Endpoint{endpoint.NewEndpoint("example.com", endpoint.RecordTypeMX, "10 mail.example.com")},
+			},
+		},
+		{
+			name:     "leaves a matching record alone",
+			existing: []*endpoint.Endpoint{mx, a},
+			mappings: map[string]string{"example.com": "1.1.1.1"},
+			want:     &plan.Changes{Create: []*endpoint.Endpoint{}},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c := &upsert{logger: logger}
+			assert.Equal(t, tt.want, c.createChangeSet(tt.existing, tt.mappings))
+		})
+	}
+}
+
 func requireGenerateReadResponse(tb testing.TB, hosts []unbound.Record) string {
 	tb.Helper()
 	shr := unbound.SearchHostResp{
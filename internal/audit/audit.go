@@ -0,0 +1,222 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/external-dns/plan"
+)
+
+// Outcomes of an audited action.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Sources of an audited action.
+const (
+	SourceCLI     = "cli"
+	SourceWebhook = "webhook"
+)
+
+// maxLineSize bounds a single entry read back, plans of a full sync can be large.
+const maxLineSize = 16 << 20
+
+// ErrInvalidOutcome is returned when a query filters on an unknown outcome.
+var ErrInvalidOutcome = errors.New("invalid outcome - must be \"success\" or \"failure\"")
+
+// Source is who asked for a change: a CLI user on a host, or a webhook client.
+type Source struct {
+	Kind       string `json:"kind"`
+	User       string `json:"user,omitempty"`
+	Hostname   string `json:"hostname,omitempty"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+}
+
+func (s Source) String() string {
+	switch {
+	case s.RemoteAddr != "":
+		return s.Kind + " " + s.RemoteAddr
+	case s.User != "" || s.Hostname != "":
+		return s.Kind + " " + s.User + "@" + s.Hostname
+	default:
+		return s.Kind
+	}
+}
+
+// Change is a single opnsense row created, updated or deleted.
+type Change struct {
+	Kind       string `json:"kind"`
+	UUID       string `json:"uuid"`
+	DNSName    string `json:"dnsName"`
+	RecordType string `json:"recordType"`
+	Target     string `json:"target,omitempty"`
+	// Rollback is set on changes undoing an earlier change of a failed action.
+	Rollback bool `json:"rollback,omitempty"`
+}
+
+// Entry is a single audited action, one line of the log.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Source   Source    `json:"source"`
+	Instance string    `json:"instance"`
+	// Action is what was asked for, eg "apply", "enable", "purge", "migrate" or "domains".
+	Action string `json:"action"`
+	// Hosts are the dns names the action was asked for or touched, lower case and sorted.
+	Hosts []string `json:"hosts"`
+	// Plan is the plan applied, if any.
+	Plan *plan.Changes `json:"plan,omitempty"`
+	// Changes are the rows touched, including those of a failed action that were rolled back.
+	Changes []Change `json:"changes"`
+	Outcome string   `json:"outcome"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Log is an append-only JSON lines file of entries. A nil Log discards entries, so auditing is optional.
+type Log struct {
+	path string
+	mu   sync.Mutex
+}
+
+// New creates a Log stored at path, nil when path is empty. The file is created on the first entry.
+func New(path string) *Log {
+	if path == "" {
+		return nil
+	}
+	return &Log{path: path}
+}
+
+// Append writes entry as a single line at the end of the log.
+func (l *Log) Append(entry Entry) error {
+	if l == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshal audit entry: %w", err)
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o750); err != nil {
+		return fmt.Errorf("audit log dir: %w", err)
+	}
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+
+	// a single write keeps lines whole when several processes append to the same file
+	if _, err := file.Write(data); err != nil {
+		return errors.Join(fmt.Errorf("write audit log: %w", err), file.Close())
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close audit log: %w", err)
+	}
+
+	return nil
+}
+
+// Query selects entries. Zero fields match every entry.
+type Query struct {
+	// Host matches entries for the dns name, ignoring case and a trailing dot.
+	Host string
+	// Since and Until bound the time of the entries, inclusive.
+	Since time.Time
+	Until time.Time
+	// Outcome is OutcomeSuccess or OutcomeFailure.
+	Outcome string
+}
+
+// Validate checks q can match entries.
+func (q Query) Validate() error {
+	switch q.Outcome {
+	case "", OutcomeSuccess, OutcomeFailure:
+		return nil
+	default:
+		return fmt.Errorf("%q: %w", q.Outcome, ErrInvalidOutcome)
+	}
+}
+
+func (q Query) matches(entry Entry) bool {
+	if q.Host != "" && !slices.Contains(entry.Hosts, NormalizeHost(q.Host)) {
+		return false
+	}
+	if !q.Since.IsZero() && entry.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && entry.Time.After(q.Until) {
+		return false
+	}
+	return q.Outcome == "" || entry.Outcome == q.Outcome
+}
+
+// Read returns the entries of the log at path matching q, oldest first. A missing log has no entries.
+func Read(path string, q Query) ([]Entry, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	defer file.Close()
+
+	out := make([]Entry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		entry := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("parse audit log %v line %d: %w", path, line, err)
+		}
+		if q.matches(entry) {
+			out = append(out, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read audit log: %w", err)
+	}
+
+	return out, nil
+}
+
+// NormalizeHost is the form dns names are stored in Entry.Hosts.
+func NormalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+type sourceKey struct{}
+
+// WithSource records in ctx who asked for the changes made with it.
+func WithSource(ctx context.Context, source Source) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// SourceFrom returns the source recorded in ctx by WithSource, if any.
+func SourceFrom(ctx context.Context) Source {
+	source, _ := ctx.Value(sourceKey{}).(Source)
+	return source
+}
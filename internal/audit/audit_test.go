@@ -0,0 +1,133 @@
+package audit_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MrUsefull/boundation/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRead(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	created := audit.Entry{
+		Time:    start,
+		Source:  audit.Source{Kind: audit.SourceCLI, User: "alice", Hostname: "laptop"},
+		Action:  "apply",
+		Hosts:   []string{"host.example.domain"},
+		Changes: []audit.Change{{Kind: "create", UUID: "uuid-1", DNSName: "host.example.domain", RecordType: "A", Target: "10.0.0.1"}},
+		Outcome: audit.OutcomeSuccess,
+	}
+	failed := audit.Entry{
+		Time:    start.Add(time.Hour),
+		Source:  audit.Source{Kind: audit.SourceWebhook, RemoteAddr: "10.0.0.5:41234"},
+		Action:  "apply",
+		Hosts:   []string{"host.example.domain", "other.example.domain"},
+		Changes: []audit.Change{},
+		Outcome: audit.OutcomeFailure,
+		Error:   "refused",
+	}
+	purged := audit.Entry{
+		Time:    start.Add(2 * time.Hour),
+		Source:  audit.Source{Kind: audit.SourceCLI, User: "bob", Hostname: "desktop"},
+		Action:  "purge",
+		Hosts:   []string{"other.example.domain"},
+		Changes: []audit.Change{{Kind: "delete", UUID: "uuid-2", DNSName: "other.example.domain", RecordType: "A"}},
+		Outcome: audit.OutcomeSuccess,
+	}
+
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	log := audit.New(path)
+	for _, entry := range []audit.Entry{created, failed, purged} {
+		require.NoError(t, log.Append(entry))
+	}
+
+	tests := []struct {
+		name    string
+		query   audit.Query
+		want    []audit.Entry
+		wantErr error
+	}{
+		{
+			name:  "everything",
+			query: audit.Query{},
+			want:  []audit.Entry{created, failed, purged},
+		},
+		{
+			name:  "host",
+			query: audit.Query{Host: "Other.example.domain."},
+			want:  []audit.Entry{failed, purged},
+		},
+		{
+			name:  "time range",
+			query: audit.Query{Since: start.Add(time.Hour), Until: start.Add(time.Hour)},
+			want:  []audit.Entry{failed},
+		},
+		{
+			name:  "outcome",
+			query: audit.Query{Host: "host.example.domain", Outcome: audit.OutcomeSuccess},
+			want:  []audit.Entry{created},
+		},
+		{
+			name:    "invalid outcome",
+			query:   audit.Query{Outcome: "rolled back"},
+			wantErr: audit.ErrInvalidOutcome,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := audit.Read(path, tt.query)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRead_missing(t *testing.T) {
+	t.Parallel()
+
+	got, err := audit.Read(filepath.Join(t.TempDir(), "audit.jsonl"), audit.Query{})
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestRead_malformed(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"action\":\"apply\"}\n{\"action\":\n"), 0600))
+
+	_, err := audit.Read(path, audit.Query{})
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestLog_nil(t *testing.T) {
+	t.Parallel()
+
+	log := audit.New("")
+	assert.Nil(t, log)
+	assert.NoError(t, log.Append(audit.Entry{}))
+}
+
+func TestSourceFrom(t *testing.T) {
+	t.Parallel()
+
+	source := audit.Source{Kind: audit.SourceWebhook, RemoteAddr: "10.0.0.5:41234"}
+	assert.Equal(t, source, audit.SourceFrom(audit.WithSource(context.Background(), source)))
+	assert.Equal(t, audit.Source{}, audit.SourceFrom(context.Background()))
+	assert.Equal(t, "webhook 10.0.0.5:41234", source.String())
+	assert.Equal(t, "cli alice@laptop", audit.Source{Kind: audit.SourceCLI, User: "alice", Hostname: "laptop"}.String())
+}
@@ -5,14 +5,24 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
 var (
-	ErrInvalidBaseURL = errors.New("invalid base url - must start with http:// or https://")
-	ErrInvalidCreds   = errors.New("invalid creds - must be in format \"apiKey:apiSecret\"")
+	ErrInvalidBaseURL    = errors.New("invalid base url - must start with http:// or https://")
+	ErrInvalidCreds      = errors.New("invalid creds - must be in format \"apiKey:apiSecret\"")
+	ErrInvalidRegistry   = errors.New("invalid registry - type must be \"description\" or \"file\" with a path")
+	ErrInvalidTXT        = errors.New("invalid txt - prefix and suffix are mutually exclusive")
+	ErrInvalidTLS        = errors.New("invalid tls - client cert and key must be set together")
+	ErrInvalidProxy      = errors.New("invalid proxy - must be an absolute url")
+	ErrInvalidInstance   = errors.New("invalid instance - each needs a unique name, base url and creds, with at most one primary")
+	ErrInvalidRoute      = errors.New("invalid route - each needs a unique zone and a configured instance, and replaces the domain filter")
+	ErrInvalidSafeguards = errors.New("invalid safeguards - max deletions must not be negative")
+	ErrInvalidListen     = errors.New("invalid listen - tls cert and key must be set together, and client certificates need tls")
 )
 
 type Config struct {
@@ -21,6 +31,15 @@ type Config struct {
 	// Filter is the domains to match for this provider
 	DomainFilter `yaml:"filter"`
 	LogLevel     slog.Level `yaml:"loglevel" env:"LOG_LEVEL"`
+	Registry     `yaml:"registry"`
+	TXT          `yaml:"txt"`
+	Retry        `yaml:"retry"`
+	Breaker      `yaml:"breaker"`
+	Safeguards   `yaml:"safeguards"`
+	SoftDelete   `yaml:"softDelete"`
+	Audit        `yaml:"audit"`
+	// DryRun logs the opnsense api calls that would change records instead of sending them
+	DryRun bool `yaml:"dryRun" env:"DRY_RUN"`
 }
 
 type Opnsense struct {
@@ -30,11 +49,177 @@ type Opnsense struct {
 	// Creds in the form of APIKey:Secret
 	// obtained from OPNSense
 	Creds string `yaml:"creds" env:"OPNSENSE_CREDS"`
+	// Instances replace BaseURL and Creds when there is more than one OPNSense node, eg a
+	// CARP pair. Every plan is applied to all of them, records are read from the primary.
+	Instances []Instance `yaml:"instances,omitempty"`
+	// Routes send each zone to the instance serving it, instead of applying every plan to all
+	// instances. Records are read from every routed instance.
+	Routes []Route `yaml:"routes,omitempty"`
+	TLS    `yaml:"tls"`
+	HTTP   `yaml:"http"`
+}
+
+// PrimaryInstance names the only instance when BaseURL and Creds are used instead of Instances.
+const PrimaryInstance = "primary"
+
+// Instance is a single OPNSense node.
+type Instance struct {
+	// Name identifies the instance in logs and errors
+	Name    string `yaml:"name"`
+	BaseURL string `yaml:"baseurl"`
+	Creds   string `yaml:"creds"`
+	// Primary marks the instance records are read from, the first instance when none is marked
+	Primary bool `yaml:"primary"`
+}
+
+// Route sends the records of Zone, and every name under it, to the named instance.
+// Names matching several zones use the longest.
+type Route struct {
+	Zone     string `yaml:"zone"`
+	Instance string `yaml:"instance"`
+}
+
+// AllInstances returns every configured instance, primary first. Without Instances, BaseURL
+// and Creds are the single primary instance.
+func (o Opnsense) AllInstances() []Instance {
+	if len(o.Instances) == 0 {
+		return []Instance{{Name: PrimaryInstance, BaseURL: o.BaseURL, Creds: o.Creds, Primary: true}}
+	}
+
+	primary := 0
+	for i, instance := range o.Instances {
+		if instance.Primary {
+			primary = i
+		}
+	}
+
+	out := make([]Instance, 0, len(o.Instances))
+	out = append(out, o.Instances[primary])
+	out[0].Primary = true
+	for i, instance := range o.Instances {
+		if i != primary {
+			out = append(out, instance)
+		}
+	}
+	return out
+}
+
+// ForInstance returns cfg with instance as its only OPNSense node.
+func (cfg Config) ForInstance(instance Instance) Config {
+	cfg.BaseURL = instance.BaseURL
+	cfg.Creds = instance.Creds
+	cfg.Instances = nil
+	cfg.Routes = nil
+	return cfg
+}
+
+// TLS controls how the OPNSense certificate is verified, and the certificate presented to it.
+type TLS struct {
+	// CAFile is a PEM bundle of CAs trusted in addition to the system CAs
+	CAFile string `yaml:"caFile" env:"OPNSENSE_TLS_CA_FILE"`
+	// InsecureSkipVerify disables verification of the OPNSense certificate. Only for testing.
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify" env:"OPNSENSE_TLS_INSECURE_SKIP_VERIFY"`
+	// CertFile and KeyFile are a PEM client certificate and key, set both or neither
+	CertFile string `yaml:"certFile" env:"OPNSENSE_TLS_CERT_FILE"`
+	KeyFile  string `yaml:"keyFile" env:"OPNSENSE_TLS_KEY_FILE"`
+}
+
+// HTTP controls the http client used to reach OPNSense.
+type HTTP struct {
+	// Timeout is the limit for a single request, including reading the response
+	Timeout time.Duration `yaml:"timeout" env:"OPNSENSE_HTTP_TIMEOUT" env-default:"30s"`
+	// Proxy is the url of the proxy OPNSense is reached through. When empty the
+	// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables are used.
+	Proxy string `yaml:"proxy" env:"OPNSENSE_HTTP_PROXY"`
 }
 
 type Listen struct {
 	// Addr is the address + port we listen on
 	Addr string `yaml:"addr" env:"LISTEN_ADDR" env-default:":8080"`
+	// Socket is a unix socket path listened on instead of Addr, for sidecars only reached locally
+	Socket string `yaml:"socket" env:"LISTEN_SOCKET"`
+	// ShutdownGrace is how long requests in flight, eg a plan being applied, are given to finish
+	// once the server is asked to stop. The default leaves 5s of the 30s Kubernetes waits before
+	// killing a pod by default, so keep it below the pod's terminationGracePeriodSeconds
+	ShutdownGrace time.Duration `yaml:"shutdownGrace" env:"LISTEN_SHUTDOWN_GRACE" env-default:"25s"`
+	ServerTLS     ServerTLS     `yaml:"tls"`
+	Auth          Auth          `yaml:"auth"`
+}
+
+// ServerTLS serves the webhook over https.
+type ServerTLS struct {
+	// CertFile and KeyFile are the PEM certificate and key served, set both or neither. They are
+	// reloaded when either file changes
+	CertFile string `yaml:"certFile" env:"LISTEN_TLS_CERT_FILE"`
+	KeyFile  string `yaml:"keyFile" env:"LISTEN_TLS_KEY_FILE"`
+	// ClientCAFile is a PEM bundle of CAs. When set, requests must present a client certificate
+	// signed by one of them
+	ClientCAFile string `yaml:"clientCAFile" env:"LISTEN_TLS_CLIENT_CA_FILE"`
+}
+
+// Auth protects the webhook routes. The health and readiness checks are always open.
+type Auth struct {
+	// Token, when set, must be sent as a bearer token in the Authorization header
+	Token string `yaml:"token" env:"LISTEN_AUTH_TOKEN"`
+}
+
+type Registry struct {
+	// Type is where external-dns ownership is stored, "description" or "file"
+	Type string `yaml:"type" env:"REGISTRY_TYPE" env-default:"description"`
+	// Path is the json file ownership is stored in when Type is "file"
+	Path string `yaml:"path" env:"REGISTRY_PATH"`
+}
+
+// TXT mirrors the external-dns TXT registry flags, so the ownership TXT names reported
+// match the ones external-dns expects. Both affixes may contain the %{record_type} template.
+type TXT struct {
+	// Prefix is the external-dns --txt-prefix
+	Prefix string `yaml:"prefix" env:"TXT_PREFIX"`
+	// Suffix is the external-dns --txt-suffix
+	Suffix string `yaml:"suffix" env:"TXT_SUFFIX"`
+	// WildcardReplacement is the external-dns --txt-wildcard-replacement
+	WildcardReplacement string `yaml:"wildcardReplacement" env:"TXT_WILDCARD_REPLACEMENT"`
+}
+
+// Retry controls how api requests failing with a transient error, eg a 5xx or a connection
+// reset, are retried.
+type Retry struct {
+	// Attempts is the number of times a request is tried, 1 disables retries
+	Attempts int `yaml:"attempts" env:"RETRY_ATTEMPTS" env-default:"3"`
+	// Backoff is the wait before the first retry, doubled for every retry after it
+	Backoff time.Duration `yaml:"backoff" env:"RETRY_BACKOFF" env-default:"500ms"`
+	// MaxBackoff caps the wait between retries
+	MaxBackoff time.Duration `yaml:"maxBackoff" env:"RETRY_MAX_BACKOFF" env-default:"10s"`
+}
+
+// Breaker controls the circuit breaker that fails requests fast while opnsense is down.
+type Breaker struct {
+	// Failures is the number of consecutive transient failures that opens the breaker, a negative value disables it
+	Failures int `yaml:"failures" env:"BREAKER_FAILURES" env-default:"5"`
+	// Cooldown is how long the breaker stays open before a request is let through to probe opnsense
+	Cooldown time.Duration `yaml:"cooldown" env:"BREAKER_COOLDOWN" env-default:"30s"`
+}
+
+// Safeguards protect the records external-dns did not create, and limit what a single plan may delete.
+type Safeguards struct {
+	// AllowUnmanaged lets plans delete and modify records without external-dns metadata in their description
+	AllowUnmanaged bool `yaml:"allowUnmanaged" env:"SAFEGUARDS_ALLOW_UNMANAGED"`
+	// MaxDeletions aborts a plan deleting more records than this, 0 disables the limit
+	MaxDeletions int `yaml:"maxDeletions" env:"SAFEGUARDS_MAX_DELETIONS"`
+}
+
+// SoftDelete disables records instead of deleting them, so an accidental deletion can be undone.
+type SoftDelete struct {
+	// Enabled makes deletions disable the record, stamping the time in its metadata
+	Enabled bool `yaml:"enabled" env:"SOFT_DELETE_ENABLED"`
+	// Retention is how long a disabled record is kept before it may be purged
+	Retention time.Duration `yaml:"retention" env:"SOFT_DELETE_RETENTION" env-default:"168h"`
+}
+
+// Audit keeps a durable trail of every change made to OPNSense.
+type Audit struct {
+	// Path is the JSON lines file every change is appended to, no trail is kept when empty
+	Path string `yaml:"path" env:"AUDIT_PATH"`
 }
 
 type DomainFilter struct {
@@ -54,16 +239,56 @@ func Load(path string) (Config, error) {
 }
 
 func (cfg *Config) Validate() error {
-	if cfg.BaseURL == "" || !hasProtocol(cfg.BaseURL) {
-		return fmt.Errorf("%v: %w", cfg.BaseURL, ErrInvalidBaseURL)
+	if len(cfg.Instances) > 0 {
+		if err := validInstances(cfg.Opnsense); err != nil {
+			return err
+		}
+	} else {
+		if cfg.BaseURL == "" || !hasProtocol(cfg.BaseURL) {
+			return fmt.Errorf("%v: %w", cfg.BaseURL, ErrInvalidBaseURL)
+		}
+
+		if cfg.Creds == "" || !credFormat(cfg.Creds) {
+			return ErrInvalidCreds
+		}
 	}
 
-	if cfg.Creds == "" || !credFormat(cfg.Creds) {
-		return ErrInvalidCreds
+	if err := validRoutes(*cfg); err != nil {
+		return err
+	}
+
+	if cfg.MaxDeletions < 0 {
+		return fmt.Errorf("%v: %w", cfg.MaxDeletions, ErrInvalidSafeguards)
+	}
+
+	if !validRegistry(cfg.Registry) {
+		return fmt.Errorf("%v: %w", cfg.Registry.Type, ErrInvalidRegistry)
+	}
+
+	if cfg.TXT.Prefix != "" && cfg.TXT.Suffix != "" {
+		return ErrInvalidTXT
+	}
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return ErrInvalidTLS
+	}
+
+	if cfg.Proxy != "" && !validProxy(cfg.Proxy) {
+		return fmt.Errorf("%v: %w", cfg.Proxy, ErrInvalidProxy)
+	}
+
+	serverTLS := cfg.ServerTLS
+	if (serverTLS.CertFile == "") != (serverTLS.KeyFile == "") || (serverTLS.ClientCAFile != "" && serverTLS.CertFile == "") {
+		return ErrInvalidListen
 	}
 
 	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "\n")
 	cfg.Creds = strings.TrimSuffix(cfg.Creds, "\n")
+	cfg.Auth.Token = strings.TrimSuffix(cfg.Auth.Token, "\n")
+	for i := range cfg.Instances {
+		cfg.Instances[i].BaseURL = strings.TrimSuffix(cfg.Instances[i].BaseURL, "\n")
+		cfg.Instances[i].Creds = strings.TrimSuffix(cfg.Instances[i].Creds, "\n")
+	}
 
 	return nil
 }
@@ -74,6 +299,80 @@ func hasProtocol(baseURL string) bool {
 	return http || https
 }
 
+func validInstances(opnsense Opnsense) error {
+	if opnsense.BaseURL != "" || opnsense.Creds != "" {
+		return fmt.Errorf("baseurl and creds are set alongside instances: %w", ErrInvalidInstance)
+	}
+
+	names := make(map[string]struct{}, len(opnsense.Instances))
+	primaries := 0
+	for _, instance := range opnsense.Instances {
+		if _, ok := names[instance.Name]; ok || instance.Name == "" {
+			return fmt.Errorf("name %q: %w", instance.Name, ErrInvalidInstance)
+		}
+		names[instance.Name] = struct{}{}
+
+		if !hasProtocol(instance.BaseURL) {
+			return fmt.Errorf("%q: %v: %w", instance.Name, instance.BaseURL, ErrInvalidBaseURL)
+		}
+		if !credFormat(instance.Creds) {
+			return fmt.Errorf("%q: %w", instance.Name, ErrInvalidCreds)
+		}
+		if instance.Primary {
+			primaries++
+		}
+	}
+
+	if primaries > 1 {
+		return fmt.Errorf("%d primaries: %w", primaries, ErrInvalidInstance)
+	}
+	return nil
+}
+
+func validRoutes(cfg Config) error {
+	if len(cfg.Routes) == 0 {
+		return nil
+	}
+	if len(cfg.Filter) > 0 {
+		return fmt.Errorf("filter is set alongside routes: %w", ErrInvalidRoute)
+	}
+
+	instances := make(map[string]struct{}, len(cfg.Instances))
+	for _, instance := range cfg.Instances {
+		instances[instance.Name] = struct{}{}
+	}
+
+	zones := make(map[string]struct{}, len(cfg.Routes))
+	for _, route := range cfg.Routes {
+		zone := strings.ToLower(strings.Trim(route.Zone, "."))
+		if _, ok := zones[zone]; ok || zone == "" {
+			return fmt.Errorf("zone %q: %w", route.Zone, ErrInvalidRoute)
+		}
+		zones[zone] = struct{}{}
+
+		if _, ok := instances[route.Instance]; !ok {
+			return fmt.Errorf("zone %q instance %q: %w", route.Zone, route.Instance, ErrInvalidRoute)
+		}
+	}
+	return nil
+}
+
+func validRegistry(registry Registry) bool {
+	switch registry.Type {
+	case "", "description":
+		return true
+	case "file":
+		return registry.Path != ""
+	default:
+		return false
+	}
+}
+
+func validProxy(proxy string) bool {
+	proxyURL, err := url.Parse(proxy)
+	return err == nil && proxyURL.Scheme != "" && proxyURL.Host != ""
+}
+
 func credFormat(cred string) bool {
 	credSlice := strings.Split(cred, ":")
 	return len(credSlice) == 2
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -15,6 +16,130 @@ opnsense:
     creds: API_KEY_HERE:API_SECRET_HERE
 `
 
+const testYamlFileRegistry string = `---
+opnsense:
+    baseurl: "https://some.domain.fqdn"
+    creds: API_KEY_HERE:API_SECRET_HERE
+registry:
+    type: file
+`
+
+const testYamlTXTAffixes string = `---
+opnsense:
+    baseurl: "https://some.domain.fqdn"
+    creds: API_KEY_HERE:API_SECRET_HERE
+txt:
+    prefix: "%{record_type}-owner."
+    suffix: "-owner"
+`
+
+const testYamlClient string = `---
+opnsense:
+    baseurl: "https://some.domain.fqdn"
+    creds: API_KEY_HERE:API_SECRET_HERE
+    tls:
+        caFile: /etc/boundation/ca.pem
+        certFile: /etc/boundation/client.pem
+        keyFile: /etc/boundation/client-key.pem
+    http:
+        timeout: 5s
+        proxy: http://proxy.domain.fqdn:3128
+`
+
+const testYamlAudit string = `---
+opnsense:
+    baseurl: "https://some.domain.fqdn"
+    creds: API_KEY_HERE:API_SECRET_HERE
+audit:
+    path: /var/lib/boundation/audit.jsonl
+`
+
+const testYamlCertWithoutKey string = `---
+opnsense:
+    baseurl: "https://some.domain.fqdn"
+    creds: API_KEY_HERE:API_SECRET_HERE
+    tls:
+        certFile: /etc/boundation/client.pem
+`
+
+const testYamlBadProxy string = `---
+opnsense:
+    baseurl: "https://some.domain.fqdn"
+    creds: API_KEY_HERE:API_SECRET_HERE
+    http:
+        proxy: proxy.domain.fqdn
+`
+
+const testYamlSafeguards string = `---
+opnsense:
+    baseurl: "https://some.domain.fqdn"
+    creds: API_KEY_HERE:API_SECRET_HERE
+safeguards:
+    allowUnmanaged: true
+    maxDeletions: -1
+`
+
+const testYamlListen string = `---
+opnsense:
+    baseurl: "https://some.domain.fqdn"
+    creds: API_KEY_HERE:API_SECRET_HERE
+listen:
+    socket: /run/boundation/webhook.sock
+    tls:
+        clientCAFile: /etc/boundation/clients.pem
+    auth:
+        token: some-token
+`
+
+const testYamlInstances string = `---
+opnsense:
+    instances:
+        - name: main
+          baseurl: "https://main.domain.fqdn"
+          creds: API_KEY_HERE:API_SECRET_HERE
+        - name: backup
+          baseurl: "https://backup.domain.fqdn"
+          creds: API_KEY_HERE:API_SECRET_HERE
+`
+
+const testYamlDuplicateInstances string = `---
+opnsense:
+    instances:
+        - name: main
+          baseurl: "https://main.domain.fqdn"
+          creds: API_KEY_HERE:API_SECRET_HERE
+        - name: main
+          baseurl: "https://backup.domain.fqdn"
+          creds: API_KEY_HERE:API_SECRET_HERE
+`
+
+const testYamlRoutes string = `---
+opnsense:
+    instances:
+        - name: site-a
+          baseurl: "https://site-a.domain.fqdn"
+          creds: API_KEY_HERE:API_SECRET_HERE
+        - name: site-b
+          baseurl: "https://site-b.domain.fqdn"
+          creds: API_KEY_HERE:API_SECRET_HERE
+    routes:
+        - zone: site-a.corp
+          instance: site-a
+        - zone: site-b.corp
+          instance: site-b
+`
+
+const testYamlUnknownRoute string = `---
+opnsense:
+    instances:
+        - name: site-a
+          baseurl: "https://site-a.domain.fqdn"
+          creds: API_KEY_HERE:API_SECRET_HERE
+    routes:
+        - zone: site-b.corp
+          instance: site-b
+`
+
 const testYamlBadURL string = `---
 opnsense:
     baseurl: "some.domain.fqdn"
@@ -52,9 +177,28 @@ func TestLoad(t *testing.T) {
 				Opnsense: Opnsense{
 					BaseURL: "https://some.domain.fqdn",
 					Creds:   "API_KEY_HERE:API_SECRET_HERE",
+					HTTP: HTTP{
+						Timeout: 30 * time.Second,
+					},
 				},
 				Listen: Listen{
-					Addr: ":8080",
+					Addr:          ":8080",
+					ShutdownGrace: 25 * time.Second,
+				},
+				Retry: Retry{
+					Attempts:   3,
+					Backoff:    500 * time.Millisecond,
+					MaxBackoff: 10 * time.Second,
+				},
+				Breaker: Breaker{
+					Failures: 5,
+					Cooldown: 30 * time.Second,
+				},
+				SoftDelete: SoftDelete{
+					Retention: 168 * time.Hour,
+				},
+				Registry: Registry{
+					Type: "description",
 				},
 			},
 		},
@@ -69,9 +213,28 @@ func TestLoad(t *testing.T) {
 				Opnsense: Opnsense{
 					BaseURL: "some.domain.fqdn", // <-- problem
 					Creds:   "API_KEY_HERE:API_SECRET_HERE",
+					HTTP: HTTP{
+						Timeout: 30 * time.Second,
+					},
 				},
 				Listen: Listen{
-					Addr: ":8080",
+					Addr:          ":8080",
+					ShutdownGrace: 25 * time.Second,
+				},
+				Retry: Retry{
+					Attempts:   3,
+					Backoff:    500 * time.Millisecond,
+					MaxBackoff: 10 * time.Second,
+				},
+				Breaker: Breaker{
+					Failures: 5,
+					Cooldown: 30 * time.Second,
+				},
+				SoftDelete: SoftDelete{
+					Retention: 168 * time.Hour,
+				},
+				Registry: Registry{
+					Type: "description",
 				},
 			},
 			wantErr: true,
@@ -87,9 +250,538 @@ func TestLoad(t *testing.T) {
 				Opnsense: Opnsense{
 					BaseURL: "http://some.domain.fqdn",
 					Creds:   "invalid_creds",
+					HTTP: HTTP{
+						Timeout: 30 * time.Second,
+					},
+				},
+				Listen: Listen{
+					Addr:          ":8080",
+					ShutdownGrace: 25 * time.Second,
+				},
+				Retry: Retry{
+					Attempts:   3,
+					Backoff:    500 * time.Millisecond,
+					MaxBackoff: 10 * time.Second,
+				},
+				Breaker: Breaker{
+					Failures: 5,
+					Cooldown: 30 * time.Second,
+				},
+				SoftDelete: SoftDelete{
+					Retention: 168 * time.Hour,
+				},
+				Registry: Registry{
+					Type: "description",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "file registry without path",
+			path: func() string {
+				cfgPath := path.Join(t.TempDir(), "config.yml")
+				require.NoError(t, os.WriteFile(cfgPath, []byte(testYamlFileRegistry), 0600))
+				return cfgPath
+			}(),
+			want: Config{
+				Opnsense: Opnsense{
+					BaseURL: "https://some.domain.fqdn",
+					Creds:   "API_KEY_HERE:API_SECRET_HERE",
+					HTTP: HTTP{
+						Timeout: 30 * time.Second,
+					},
+				},
+				Listen: Listen{
+					Addr:          ":8080",
+					ShutdownGrace: 25 * time.Second,
+				},
+				Retry: Retry{
+					Attempts:   3,
+					Backoff:    500 * time.Millisecond,
+					MaxBackoff: 10 * time.Second,
+				},
+				Breaker: Breaker{
+					Failures: 5,
+					Cooldown: 30 * time.Second,
+				},
+				SoftDelete: SoftDelete{
+					Retention: 168 * time.Hour,
+				},
+				Registry: Registry{
+					Type: "file",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "txt prefix and suffix",
+			path: func() string {
+				cfgPath := path.Join(t.TempDir(), "config.yml")
+				require.NoError(t, os.WriteFile(cfgPath, []byte(testYamlTXTAffixes), 0600))
+				return cfgPath
+			}(),
+			want: Config{
+				Opnsense: Opnsense{
+					BaseURL: "https://some.domain.fqdn",
+					Creds:   "API_KEY_HERE:API_SECRET_HERE",
+					HTTP: HTTP{
+						Timeout: 30 * time.Second,
+					},
+				},
+				Listen: Listen{
+					Addr:          ":8080",
+					ShutdownGrace: 25 * time.Second,
+				},
+				Retry: Retry{
+					Attempts:   3,
+					Backoff:    500 * time.Millisecond,
+					MaxBackoff: 10 * time.Second,
+				},
+				Breaker: Breaker{
+					Failures: 5,
+					Cooldown: 30 * time.Second,
+				},
+				SoftDelete: SoftDelete{
+					Retention: 168 * time.Hour,
+				},
+				Registry: Registry{
+					Type: "description",
+				},
+				TXT: TXT{
+					Prefix: "%{record_type}-owner.",
+					Suffix: "-owner",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tls and http client",
+			path: func() string {
+				cfgPath := path.Join(t.TempDir(), "config.yml")
+				require.NoError(t, os.WriteFile(cfgPath, []byte(testYamlClient), 0600))
+				return cfgPath
+			}(),
+			want: Config{
+				Opnsense: Opnsense{
+					BaseURL: "https://some.domain.fqdn",
+					Creds:   "API_KEY_HERE:API_SECRET_HERE",
+					TLS: TLS{
+						CAFile:   "/etc/boundation/ca.pem",
+						CertFile: "/etc/boundation/client.pem",
+						KeyFile:  "/etc/boundation/client-key.pem",
+					},
+					HTTP: HTTP{
+						Timeout: 5 * time.Second,
+						Proxy:   "http://proxy.domain.fqdn:3128",
+					},
+				},
+				Listen: Listen{
+					Addr:          ":8080",
+					ShutdownGrace: 25 * time.Second,
+				},
+				Retry: Retry{
+					Attempts:   3,
+					Backoff:    500 * time.Millisecond,
+					MaxBackoff: 10 * time.Second,
+				},
+				Breaker: Breaker{
+					Failures: 5,
+					Cooldown: 30 * time.Second,
+				},
+				SoftDelete: SoftDelete{
+					Retention: 168 * time.Hour,
+				},
+				Registry: Registry{
+					Type: "description",
+				},
+			},
+		},
+		{
+			name: "audit log",
+			path: func() string {
+				cfgPath := path.Join(t.TempDir(), "config.yml")
+				require.NoError(t, os.WriteFile(cfgPath, []byte(testYamlAudit), 0600))
+				return cfgPath
+			}(),
+			want: Config{
+				Opnsense: Opnsense{
+					BaseURL: "https://some.domain.fqdn",
+					Creds:   "API_KEY_HERE:API_SECRET_HERE",
+					HTTP: HTTP{
+						Timeout: 30 * time.Second,
+					},
+				},
+				Listen: Listen{
+					Addr:          ":8080",
+					ShutdownGrace: 25 * time.Second,
+				},
+				Retry: Retry{
+					Attempts:   3,
+					Backoff:    500 * time.Millisecond,
+					MaxBackoff: 10 * time.Second,
+				},
+				Breaker: Breaker{
+					Failures: 5,
+					Cooldown: 30 * time.Second,
+				},
+				SoftDelete: SoftDelete{
+					Retention: 168 * time.Hour,
+				},
+				Registry: Registry{
+					Type: "description",
+				},
+				Audit: Audit{
+					Path: "/var/lib/boundation/audit.jsonl",
+				},
+			},
+		},
+		{
+			name: "client cert without key",
+			path: func() string {
+				cfgPath := path.Join(t.TempDir(), "config.yml")
+				require.NoError(t, os.WriteFile(cfgPath, []byte(testYamlCertWithoutKey), 0600))
+				return cfgPath
+			}(),
+			want: Config{
+				Opnsense: Opnsense{
+					BaseURL: "https://some.domain.fqdn",
+					Creds:   "API_KEY_HERE:API_SECRET_HERE",
+					TLS: TLS{
+						CertFile: "/etc/boundation/client.pem",
+					},
+					HTTP: HTTP{
+						Timeout: 30 * time.Second,
+					},
+				},
+				Listen: Listen{
+					Addr:          ":8080",
+					ShutdownGrace: 25 * time.Second,
+				},
+				Retry: Retry{
+					Attempts:   3,
+					Backoff:    500 * time.Millisecond,
+					MaxBackoff: 10 * time.Second,
+				},
+				Breaker: Breaker{
+					Failures: 5,
+					Cooldown: 30 * time.Second,
+				},
+				SoftDelete: SoftDelete{
+					Retention: 168 * time.Hour,
+				},
+				Registry: Registry{
+					Type: "description",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "proxy without scheme",
+			path: func() string {
+				cfgPath := path.Join(t.TempDir(), "config.yml")
+				require.NoError(t, os.WriteFile(cfgPath, []byte(testYamlBadProxy), 0600))
+				return cfgPath
+			}(),
+			want: Config{
+				Opnsense: Opnsense{
+					BaseURL: "https://some.domain.fqdn",
+					Creds:   "API_KEY_HERE:API_SECRET_HERE",
+					HTTP: HTTP{
+						Timeout: 30 * time.Second,
+						Proxy:   "proxy.domain.fqdn",
+					},
+				},
+				Listen: Listen{
+					Addr:          ":8080",
+					ShutdownGrace: 25 * time.Second,
+				},
+				Retry: Retry{
+					Attempts:   3,
+					Backoff:    500 * time.Millisecond,
+					MaxBackoff: 10 * time.Second,
+				},
+				Breaker: Breaker{
+					Failures: 5,
+					Cooldown: 30 * time.Second,
+				},
+				SoftDelete: SoftDelete{
+					Retention: 168 * time.Hour,
+				},
+				Registry: Registry{
+					Type: "description",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative max deletions",
+			path: func() string {
+				cfgPath := path.Join(t.TempDir(), "config.yml")
+				require.NoError(t, os.WriteFile(cfgPath, []byte(testYamlSafeguards), 0600))
+				return cfgPath
+			}(),
+			want: Config{
+				Opnsense: Opnsense{
+					BaseURL: "https://some.domain.fqdn",
+					Creds:   "API_KEY_HERE:API_SECRET_HERE",
+					HTTP: HTTP{
+						Timeout: 30 * time.Second,
+					},
+				},
+				Listen: Listen{
+					Addr:          ":8080",
+					ShutdownGrace: 25 * time.Second,
+				},
+				Retry: Retry{
+					Attempts:   3,
+					Backoff:    500 * time.Millisecond,
+					MaxBackoff: 10 * time.Second,
+				},
+				Breaker: Breaker{
+					Failures: 5,
+					Cooldown: 30 * time.Second,
+				},
+				SoftDelete: SoftDelete{
+					Retention: 168 * time.Hour,
+				},
+				Safeguards: Safeguards{
+					AllowUnmanaged: true,
+					MaxDeletions:   -1,
+				},
+				Registry: Registry{
+					Type: "description",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "client certificates without tls",
+			path: func() string {
+				cfgPath := path.Join(t.TempDir(), "config.yml")
+				require.NoError(t, os.WriteFile(cfgPath, []byte(testYamlListen), 0600))
+				return cfgPath
+			}(),
+			want: Config{
+				Opnsense: Opnsense{
+					BaseURL: "https://some.domain.fqdn",
+					Creds:   "API_KEY_HERE:API_SECRET_HERE",
+					HTTP: HTTP{
+						Timeout: 30 * time.Second,
+					},
 				},
 				Listen: Listen{
-					Addr: ":8080",
+					Addr:          ":8080",
+					Socket:        "/run/boundation/webhook.sock",
+					ShutdownGrace: 25 * time.Second,
+					ServerTLS: ServerTLS{
+						ClientCAFile: "/etc/boundation/clients.pem",
+					},
+					Auth: Auth{
+						Token: "some-token",
+					},
+				},
+				Retry: Retry{
+					Attempts:   3,
+					Backoff:    500 * time.Millisecond,
+					MaxBackoff: 10 * time.Second,
+				},
+				Breaker: Breaker{
+					Failures: 5,
+					Cooldown: 30 * time.Second,
+				},
+				SoftDelete: SoftDelete{
+					Retention: 168 * time.Hour,
+				},
+				Registry: Registry{
+					Type: "description",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "instances",
+			path: func() string {
+				cfgPath := path.Join(t.TempDir(), "config.yml")
+				require.NoError(t, os.WriteFile(cfgPath, []byte(testYamlInstances), 0600))
+				return cfgPath
+			}(),
+			want: Config{
+				Opnsense: Opnsense{
+					Instances: []Instance{
+						{
+							Name:    "main",
+							BaseURL: "https://main.domain.fqdn",
+							Creds:   "API_KEY_HERE:API_SECRET_HERE",
+						},
+						{
+							Name:    "backup",
+							BaseURL: "https://backup.domain.fqdn",
+							Creds:   "API_KEY_HERE:API_SECRET_HERE",
+						},
+					},
+					HTTP: HTTP{
+						Timeout: 30 * time.Second,
+					},
+				},
+				Listen: Listen{
+					Addr:          ":8080",
+					ShutdownGrace: 25 * time.Second,
+				},
+				Retry: Retry{
+					Attempts:   3,
+					Backoff:    500 * time.Millisecond,
+					MaxBackoff: 10 * time.Second,
+				},
+				Breaker: Breaker{
+					Failures: 5,
+					Cooldown: 30 * time.Second,
+				},
+				SoftDelete: SoftDelete{
+					Retention: 168 * time.Hour,
+				},
+				Registry: Registry{
+					Type: "description",
+				},
+			},
+		},
+		{
+			name: "duplicate instance names",
+			path: func() string {
+				cfgPath := path.Join(t.TempDir(), "config.yml")
+				require.NoError(t, os.WriteFile(cfgPath, []byte(testYamlDuplicateInstances), 0600))
+				return cfgPath
+			}(),
+			want: Config{
+				Opnsense: Opnsense{
+					Instances: []Instance{
+						{
+							Name:    "main",
+							BaseURL: "https://main.domain.fqdn",
+							Creds:   "API_KEY_HERE:API_SECRET_HERE",
+						},
+						{
+							Name:    "main",
+							BaseURL: "https://backup.domain.fqdn",
+							Creds:   "API_KEY_HERE:API_SECRET_HERE",
+						},
+					},
+					HTTP: HTTP{
+						Timeout: 30 * time.Second,
+					},
+				},
+				Listen: Listen{
+					Addr:          ":8080",
+					ShutdownGrace: 25 * time.Second,
+				},
+				Retry: Retry{
+					Attempts:   3,
+					Backoff:    500 * time.Millisecond,
+					MaxBackoff: 10 * time.Second,
+				},
+				Breaker: Breaker{
+					Failures: 5,
+					Cooldown: 30 * time.Second,
+				},
+				SoftDelete: SoftDelete{
+					Retention: 168 * time.Hour,
+				},
+				Registry: Registry{
+					Type: "description",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "routes",
+			path: func() string {
+				cfgPath := path.Join(t.TempDir(), "config.yml")
+				require.NoError(t, os.WriteFile(cfgPath, []byte(testYamlRoutes), 0600))
+				return cfgPath
+			}(),
+			want: Config{
+				Opnsense: Opnsense{
+					Instances: []Instance{
+						{
+							Name:    "site-a",
+							BaseURL: "https://site-a.domain.fqdn",
+							Creds:   "API_KEY_HERE:API_SECRET_HERE",
+						},
+						{
+							Name:    "site-b",
+							BaseURL: "https://site-b.domain.fqdn",
+							Creds:   "API_KEY_HERE:API_SECRET_HERE",
+						},
+					},
+					Routes: []Route{
+						{Zone: "site-a.corp", Instance: "site-a"},
+						{Zone: "site-b.corp", Instance: "site-b"},
+					},
+					HTTP: HTTP{
+						Timeout: 30 * time.Second,
+					},
+				},
+				Listen: Listen{
+					Addr:          ":8080",
+					ShutdownGrace: 25 * time.Second,
+				},
+				Retry: Retry{
+					Attempts:   3,
+					Backoff:    500 * time.Millisecond,
+					MaxBackoff: 10 * time.Second,
+				},
+				Breaker: Breaker{
+					Failures: 5,
+					Cooldown: 30 * time.Second,
+				},
+				SoftDelete: SoftDelete{
+					Retention: 168 * time.Hour,
+				},
+				Registry: Registry{
+					Type: "description",
+				},
+			},
+		},
+		{
+			name: "route to unknown instance",
+			path: func() string {
+				cfgPath := path.Join(t.TempDir(), "config.yml")
+				require.NoError(t, os.WriteFile(cfgPath, []byte(testYamlUnknownRoute), 0600))
+				return cfgPath
+			}(),
+			want: Config{
+				Opnsense: Opnsense{
+					Instances: []Instance{
+						{
+							Name:    "site-a",
+							BaseURL: "https://site-a.domain.fqdn",
+							Creds:   "API_KEY_HERE:API_SECRET_HERE",
+						},
+					},
+					Routes: []Route{
+						{Zone: "site-b.corp", Instance: "site-b"},
+					},
+					HTTP: HTTP{
+						Timeout: 30 * time.Second,
+					},
+				},
+				Listen: Listen{
+					Addr:          ":8080",
+					ShutdownGrace: 25 * time.Second,
+				},
+				Retry: Retry{
+					Attempts:   3,
+					Backoff:    500 * time.Millisecond,
+					MaxBackoff: 10 * time.Second,
+				},
+				Breaker: Breaker{
+					Failures: 5,
+					Cooldown: 30 * time.Second,
+				},
+				SoftDelete: SoftDelete{
+					Retention: 168 * time.Hour,
+				},
+				Registry: Registry{
+					Type: "description",
 				},
 			},
 			wantErr: true,
@@ -108,3 +800,36 @@ func TestLoad(t *testing.T) {
 		})
 	}
 }
+
+func TestOpnsense_AllInstances(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		opnsense Opnsense
+		want     []Instance
+	}{
+		{
+			name:     "single instance",
+			opnsense: Opnsense{BaseURL: "https://some.domain.fqdn", Creds: "key:secret"},
+			want:     []Instance{{Name: "primary", BaseURL: "https://some.domain.fqdn", Creds: "key:secret", Primary: true}},
+		},
+		{
+			name:     "first instance is the default primary",
+			opnsense: Opnsense{Instances: []Instance{{Name: "a"}, {Name: "b"}}},
+			want:     []Instance{{Name: "a", Primary: true}, {Name: "b"}},
+		},
+		{
+			name:     "marked primary first",
+			opnsense: Opnsense{Instances: []Instance{{Name: "a"}, {Name: "b"}, {Name: "c", Primary: true}}},
+			want:     []Instance{{Name: "c", Primary: true}, {Name: "a"}, {Name: "b"}},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, tt.opnsense.AllInstances())
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "boundation"
+
+// unmatchedRoute labels the requests that matched no webhook route.
+const unmatchedRoute = "unmatched"
+
+// Metrics are the collectors of a webhook server, registered on their own registry. A nil
+// *Metrics records nothing, so providers built without metrics need no checks.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+
+	apiCalls    *prometheus.CounterVec
+	apiErrors   *prometheus.CounterVec
+	apiDuration *prometheus.HistogramVec
+
+	records   *prometheus.GaugeVec
+	lastApply *prometheus.GaugeVec
+	cacheRows *prometheus.GaugeVec
+}
+
+// New creates the metrics, along with the go runtime and process metrics.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Webhook requests served, by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to serve webhook requests, by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		apiCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "opnsense_requests_total",
+			Help:      "Requests sent to the opnsense api, by instance and endpoint. Retries are counted.",
+		}, []string{"instance", "endpoint"}),
+		apiErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "opnsense_request_errors_total",
+			Help:      "Requests to the opnsense api that failed or were not answered with 200 OK, by instance and endpoint.",
+		}, []string{"instance", "endpoint"}),
+		apiDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "opnsense_request_duration_seconds",
+			Help:      "Time taken by requests to the opnsense api, by instance and endpoint.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"instance", "endpoint"}),
+		records: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "managed_records",
+			Help:      "Records carrying external-dns metadata as of the last read, by instance and record type.",
+		}, []string{"instance", "type"}),
+		lastApply: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_apply_success_timestamp_seconds",
+			Help:      "Unix time of the last plan applied successfully, by instance.",
+		}, []string{"instance"}),
+		cacheRows: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cache_rows",
+			Help:      "Opnsense rows held in the record cache, by instance.",
+		}, []string{"instance"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.requestDuration,
+		m.apiCalls, m.apiErrors, m.apiDuration,
+		m.records, m.lastApply, m.cacheRows,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the prometheus text exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware counts and times the requests served by a chi router, labelled by route pattern
+// rather than path so the label values stay bounded.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := unmatchedRoute
+		if routeCtx := chi.RouteContext(r.Context()); routeCtx != nil && routeCtx.RoutePattern() != "" {
+			route = routeCtx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		m.requests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		m.requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ObserveAPICall records a request sent to endpoint, an opnsense api action such as
+// searchHostOverride. failed is whether it errored or was not answered with 200 OK.
+func (m *Metrics) ObserveAPICall(instance string, endpoint string, took time.Duration, failed bool) {
+	if m == nil {
+		return
+	}
+
+	m.apiCalls.WithLabelValues(instance, endpoint).Inc()
+	m.apiDuration.WithLabelValues(instance, endpoint).Observe(took.Seconds())
+	if failed {
+		m.apiErrors.WithLabelValues(instance, endpoint).Inc()
+	}
+}
+
+// SetRecords replaces the managed record counts of instance, keyed by record type.
+func (m *Metrics) SetRecords(instance string, counts map[string]int) {
+	if m == nil {
+		return
+	}
+
+	m.records.DeletePartialMatch(prometheus.Labels{"instance": instance})
+	for recordType, count := range counts {
+		m.records.WithLabelValues(instance, recordType).Set(float64(count))
+	}
+}
+
+// SetLastApply records when instance last applied a plan successfully.
+func (m *Metrics) SetLastApply(instance string, at time.Time) {
+	if m == nil {
+		return
+	}
+
+	m.lastApply.WithLabelValues(instance).Set(float64(at.Unix()))
+}
+
+// SetCacheRows records the number of rows in the record cache of instance.
+func (m *Metrics) SetCacheRows(instance string, rows int) {
+	if m == nil {
+		return
+	}
+
+	m.cacheRows.WithLabelValues(instance).Set(float64(rows))
+}
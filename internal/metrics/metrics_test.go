@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	body, err := io.ReadAll(recorder.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMetrics_SetRecords(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	m.SetRecords("primary", map[string]int{"A": 2, "CNAME": 1})
+	m.SetRecords("backup", map[string]int{"A": 2})
+	m.SetRecords("primary", map[string]int{"A": 3})
+
+	got := scrape(t, m)
+	assert.Contains(t, got, `boundation_managed_records{instance="primary",type="A"} 3`)
+	assert.Contains(t, got, `boundation_managed_records{instance="backup",type="A"} 2`)
+	// types no longer read are dropped
+	assert.NotContains(t, got, `type="CNAME"`)
+}
+
+func TestMetrics_ObserveAPICall(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	m.ObserveAPICall("primary", "delHostOverride", time.Second, false)
+	m.ObserveAPICall("primary", "delHostOverride", time.Second, true)
+
+	got := scrape(t, m)
+	assert.Contains(t, got, `boundation_opnsense_requests_total{endpoint="delHostOverride",instance="primary"} 2`)
+	assert.Contains(t, got, `boundation_opnsense_request_errors_total{endpoint="delHostOverride",instance="primary"} 1`)
+	assert.Contains(t, got, `boundation_opnsense_request_duration_seconds_sum{endpoint="delHostOverride",instance="primary"} 2`)
+}
+
+func TestMetrics_nil(t *testing.T) {
+	t.Parallel()
+
+	var m *Metrics
+	assert.NotPanics(t, func() {
+		m.ObserveAPICall("primary", "reconfigure", time.Second, false)
+		m.SetRecords("primary", map[string]int{"A": 1})
+		m.SetLastApply("primary", time.Now())
+		m.SetCacheRows("primary", 1)
+	})
+}
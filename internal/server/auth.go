@@ -0,0 +1,52 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/MrUsefull/boundation/internal/config"
+)
+
+var (
+	errClientCertRequired = errors.New("client certificate required")
+	errInvalidToken       = errors.New("invalid bearer token")
+)
+
+// authenticate rejects requests with 401 Unauthorized unless they carry the configured bearer
+// token and, when client certificates are required, a verified client certificate.
+// Without either, every request is let through.
+func authenticate(auth config.Auth, requireClientCert bool, log *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requireClientCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+				log.WarnContext(r.Context(), "rejecting request without a client certificate",
+					slog.String("remote", r.RemoteAddr))
+				writeWebhookError(r.Context(), w, http.StatusUnauthorized, errClientCertRequired, log)
+
+				return
+			}
+
+			if auth.Token != "" && !validToken(r, auth.Token) {
+				log.WarnContext(r.Context(), "rejecting request without a valid bearer token",
+					slog.String("remote", r.RemoteAddr))
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeWebhookError(r.Context(), w, http.StatusUnauthorized, errInvalidToken, log)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func validToken(r *http.Request, token string) bool {
+	scheme, got, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
@@ -0,0 +1,101 @@
+package server_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_bearerToken(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		token  string
+		path   string
+		header string
+		want   int
+		// wantErr is the error answered, as JSON of the webhook media type
+		wantErr string
+	}{
+		{
+			name: "no token configured",
+			path: server.RecordsEndpoint,
+			want: http.StatusOK,
+		},
+		{
+			name:   "valid token",
+			token:  "secret",
+			path:   server.RecordsEndpoint,
+			header: "Bearer secret",
+			want:   http.StatusOK,
+		},
+		{
+			name:    "missing token",
+			token:   "secret",
+			path:    server.RecordsEndpoint,
+			want:    http.StatusUnauthorized,
+			wantErr: "invalid bearer token",
+		},
+		{
+			name:    "wrong token",
+			token:   "secret",
+			path:    server.MetricsEndpoint,
+			header:  "Bearer guess",
+			want:    http.StatusUnauthorized,
+			wantErr: "invalid bearer token",
+		},
+		{
+			name:    "wrong scheme",
+			token:   "secret",
+			path:    server.RecordsEndpoint,
+			header:  "Basic secret",
+			want:    http.StatusUnauthorized,
+			wantErr: "invalid bearer token",
+		},
+		{
+			name:  "health check is open",
+			token: "secret",
+			path:  server.HealthEndpoint,
+			want:  http.StatusOK,
+		},
+		{
+			name:  "readiness check is open",
+			token: "secret",
+			path:  server.ReadyEndpoint,
+			want:  http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := config.Config{Listen: config.Listen{Auth: config.Auth{Token: tt.token}}}
+			subject, err := server.New(cfg, slog.Default(), server.WithProvider(testProvider{}))
+			require.NoError(t, err)
+
+			request := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				request.Header.Set("Authorization", tt.header)
+			}
+			recorder := httptest.NewRecorder()
+			subject.Routes().ServeHTTP(recorder, request)
+
+			assert.Equal(t, tt.want, recorder.Code)
+			if tt.wantErr != "" {
+				assert.Equal(t, server.MediaType, recorder.Header().Get("Content-Type"))
+				var got server.ErrorResponse
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &got))
+				assert.Equal(t, server.ErrorResponse{Error: tt.wantErr}, got)
+			}
+		})
+	}
+}
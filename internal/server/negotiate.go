@@ -0,0 +1,164 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// webhookMediaType is the media type of the external-dns webhook protocol, its version parameter
+// selects the version of the protocol.
+const webhookMediaType = "application/external.dns.webhook+json"
+
+var (
+	// ErrUnsupportedMediaType is returned when a request body is not of a served protocol version.
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	// ErrNotAcceptable is returned when a request accepts none of the served protocol versions.
+	ErrNotAcceptable = errors.New("not acceptable")
+)
+
+// protocol is a version of the webhook protocol. Every version is served by routes of its own,
+// so a new version can be served next to the ones older external-dns releases still speak.
+type protocol struct {
+	version string
+	routes  func(s Server, router chi.Router)
+}
+
+// protocols are the versions of the webhook protocol served, the preferred one first.
+var protocols = []protocol{
+	{version: "1", routes: Server.routesV1},
+}
+
+// mediaType is the Content-Type of the responses of p. external-dns compares it as a string, so it
+// is formatted without spaces.
+func (p protocol) mediaType() string {
+	return webhookMediaType + ";version=" + p.version
+}
+
+// mediaTypes lists the media types served.
+func mediaTypes() []string {
+	out := make([]string, 0, len(protocols))
+	for _, p := range protocols {
+		out = append(out, p.mediaType())
+	}
+	return out
+}
+
+// negotiate selects the protocol version of r. A request body must be of a served version,
+// otherwise 415 Unsupported Media Type is returned, and the response is of the same version.
+// Among the versions left, the one r accepts with the highest quality is chosen, the preferred
+// version when r has no Accept header, and 406 Not Acceptable is returned when it accepts none.
+func negotiate(r *http.Request) (protocol, int, error) {
+	candidates := protocols
+	if contentType := r.Header.Get("Content-Type"); contentType != "" && r.ContentLength != 0 {
+		p, ok := protocolOf(contentType)
+		if !ok {
+			return protocol{}, http.StatusUnsupportedMediaType,
+				fmt.Errorf("%w %q, send one of %v", ErrUnsupportedMediaType, contentType, mediaTypes())
+		}
+		candidates = []protocol{p}
+	}
+
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return candidates[0], http.StatusOK, nil
+	}
+
+	ranges := parseAccept(strings.Join(accept, ","))
+	var (
+		best        protocol
+		bestQuality float64
+	)
+	for _, p := range candidates {
+		if quality := p.quality(ranges); quality > bestQuality {
+			best, bestQuality = p, quality
+		}
+	}
+	if bestQuality == 0 {
+		var served []string
+		for _, p := range candidates {
+			served = append(served, p.mediaType())
+		}
+		return protocol{}, http.StatusNotAcceptable,
+			fmt.Errorf("%w: %q, accept one of %v", ErrNotAcceptable, strings.Join(accept, ","), served)
+	}
+
+	return best, http.StatusOK, nil
+}
+
+// protocolOf returns the served protocol of a Content-Type.
+func protocolOf(contentType string) (protocol, bool) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != webhookMediaType {
+		return protocol{}, false
+	}
+	for _, p := range protocols {
+		if params["version"] == p.version {
+			return p, true
+		}
+	}
+	return protocol{}, false
+}
+
+// mediaRange is a media range of an Accept header.
+type mediaRange struct {
+	mediaType string
+	version   string
+	quality   float64
+}
+
+// parseAccept parses the media ranges of an Accept header, skipping those that are malformed.
+func parseAccept(accept string) []mediaRange {
+	var out []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		out = append(out, mediaRange{mediaType: mediaType, version: params["version"], quality: quality})
+	}
+	return out
+}
+
+// quality is how much p is accepted, the quality of the most specific of ranges matching it,
+// 0 when none does.
+func (p protocol) quality(ranges []mediaRange) float64 {
+	bestSpecificity, quality := -1, 0.0
+	for _, r := range ranges {
+		if specificity := p.specificity(r); specificity > bestSpecificity {
+			bestSpecificity, quality = specificity, r.quality
+		}
+	}
+	return quality
+}
+
+// specificity ranks how closely r matches p, -1 when it does not.
+func (p protocol) specificity(r mediaRange) int {
+	switch {
+	case r.mediaType == webhookMediaType && r.version == p.version:
+		return 3
+	case r.mediaType == webhookMediaType && r.version == "":
+		return 2
+	case r.mediaType == "application/*":
+		return 1
+	case r.mediaType == "*/*":
+		return 0
+	default:
+		return -1
+	}
+}
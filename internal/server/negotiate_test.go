@@ -0,0 +1,212 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_negotiation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		accept      string
+		contentType string
+		body        string
+		recordsErr  error
+		want        int
+		wantType    string
+		wantError   bool
+	}{
+		{
+			name:     "no accept header",
+			method:   http.MethodGet,
+			path:     server.RecordsEndpoint,
+			want:     http.StatusOK,
+			wantType: server.MediaType,
+		},
+		{
+			name:     "version 1",
+			method:   http.MethodGet,
+			path:     "/",
+			accept:   server.MediaType,
+			want:     http.StatusOK,
+			wantType: server.MediaType,
+		},
+		{
+			name:     "any version",
+			method:   http.MethodGet,
+			path:     server.RecordsEndpoint,
+			accept:   "application/external.dns.webhook+json",
+			want:     http.StatusOK,
+			wantType: server.MediaType,
+		},
+		{
+			name:     "any media type",
+			method:   http.MethodGet,
+			path:     server.RecordsEndpoint,
+			accept:   "*/*",
+			want:     http.StatusOK,
+			wantType: server.MediaType,
+		},
+		{
+			name:     "lower quality alternative",
+			method:   http.MethodGet,
+			path:     server.RecordsEndpoint,
+			accept:   "text/html, application/external.dns.webhook+json;version=2, application/*;q=0.5",
+			want:     http.StatusOK,
+			wantType: server.MediaType,
+		},
+		{
+			name:      "plain json",
+			method:    http.MethodGet,
+			path:      server.RecordsEndpoint,
+			accept:    "application/json",
+			want:      http.StatusNotAcceptable,
+			wantType:  "application/json",
+			wantError: true,
+		},
+		{
+			name:      "unsupported version",
+			method:    http.MethodGet,
+			path:      "/",
+			accept:    "application/external.dns.webhook+json;version=2",
+			want:      http.StatusNotAcceptable,
+			wantType:  "application/json",
+			wantError: true,
+		},
+		{
+			name:      "version 1 refused",
+			method:    http.MethodGet,
+			path:      server.RecordsEndpoint,
+			accept:    "*/*, application/external.dns.webhook+json;version=1;q=0",
+			want:      http.StatusNotAcceptable,
+			wantType:  "application/json",
+			wantError: true,
+		},
+		{
+			name:        "plan of version 1",
+			method:      http.MethodPost,
+			path:        server.RecordsEndpoint,
+			accept:      server.MediaType,
+			contentType: server.MediaType,
+			body:        `{}`,
+			want:        http.StatusNoContent,
+			wantType:    server.MediaType,
+		},
+		{
+			name:        "plan of plain json",
+			method:      http.MethodPost,
+			path:        server.RecordsEndpoint,
+			contentType: "application/json",
+			body:        `{}`,
+			want:        http.StatusUnsupportedMediaType,
+			wantType:    "application/json",
+			wantError:   true,
+		},
+		{
+			name:        "endpoints of an unsupported version",
+			method:      http.MethodPost,
+			path:        server.AdjustEndpoint,
+			contentType: "application/external.dns.webhook+json;version=2",
+			body:        `[]`,
+			want:        http.StatusUnsupportedMediaType,
+			wantType:    "application/json",
+			wantError:   true,
+		},
+		{
+			name:        "malformed plan",
+			method:      http.MethodPost,
+			path:        server.RecordsEndpoint,
+			contentType: server.MediaType,
+			body:        `{`,
+			want:        http.StatusBadRequest,
+			wantType:    server.MediaType,
+			wantError:   true,
+		},
+		{
+			name:       "provider error",
+			method:     http.MethodGet,
+			path:       server.RecordsEndpoint,
+			accept:     server.MediaType,
+			recordsErr: errors.New("opnsense unreachable"),
+			want:       http.StatusInternalServerError,
+			wantType:   server.MediaType,
+			wantError:  true,
+		},
+		{
+			name:      "unknown route",
+			method:    http.MethodGet,
+			path:      "/unknown",
+			accept:    server.MediaType,
+			want:      http.StatusNotFound,
+			wantType:  server.MediaType,
+			wantError: true,
+		},
+		{
+			name:      "unknown method",
+			method:    http.MethodDelete,
+			path:      server.RecordsEndpoint,
+			want:      http.StatusMethodNotAllowed,
+			wantType:  server.MediaType,
+			wantError: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			subject, err := server.New(config.Config{}, slog.Default(), server.WithProvider(testProvider{recordsErr: tt.recordsErr}))
+			require.NoError(t, err)
+
+			request := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.accept != "" {
+				request.Header.Set("Accept", tt.accept)
+			}
+			if tt.contentType != "" {
+				request.Header.Set("Content-Type", tt.contentType)
+			}
+			recorder := httptest.NewRecorder()
+			subject.Routes().ServeHTTP(recorder, request)
+
+			assert.Equal(t, tt.want, recorder.Code)
+			assert.Equal(t, tt.wantType, recorder.Header().Get("Content-Type"))
+			assert.Equal(t, "Accept", recorder.Header().Get("Vary"))
+			if tt.wantError {
+				errResp := server.ErrorResponse{}
+				require.NoError(t, json.NewDecoder(recorder.Body).Decode(&errResp))
+				assert.NotEmpty(t, errResp.Error)
+			}
+		})
+	}
+}
+
+func TestServer_notNegotiated(t *testing.T) {
+	t.Parallel()
+
+	subject, err := server.New(config.Config{}, slog.Default(), server.WithProvider(testProvider{}))
+	require.NoError(t, err)
+
+	for _, path := range []string{server.HealthEndpoint, server.ReadyEndpoint, server.MetricsEndpoint} {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("Accept", "text/plain")
+		recorder := httptest.NewRecorder()
+		subject.Routes().ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code, path)
+		assert.NotEqual(t, server.MediaType, recorder.Header().Get("Content-Type"), path)
+	}
+}
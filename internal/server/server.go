@@ -2,12 +2,21 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"runtime/debug"
+	"sync"
 	"time"
 
+	"github.com/MrUsefull/boundation/internal/audit"
 	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/metrics"
 	"github.com/MrUsefull/boundation/internal/unbound"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -19,12 +28,37 @@ import (
 const (
 	RecordsEndpoint string = "/records"
 	AdjustEndpoint  string = "/adjustendpoints"
+	MetricsEndpoint string = "/metrics"
+	HealthEndpoint  string = "/healthz"
+	ReadyEndpoint   string = "/readyz"
 
-	MediaType string = "application/external.dns.webhook+json;version=1"
+	// MediaType is the media type of version 1 of the webhook protocol.
+	MediaType string = webhookMediaType + ";version=1"
+
+	// readyCacheTTL is how long a readiness check is reused, so frequent probes do not load opnsense.
+	readyCacheTTL = 5 * time.Second
 )
 
 type Opts func(*Server)
 
+// ReadinessChecker is implemented by providers that can check their backend is reachable.
+type ReadinessChecker interface {
+	Ready(ctx context.Context) []unbound.Readiness
+}
+
+// ErrorResponse is the body of the errors answered to webhook requests.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// ReadyStatus is the body of ReadyEndpoint.
+type ReadyStatus struct {
+	// Ready is set when every instance is ready.
+	Ready     bool                `json:"ready"`
+	CheckedAt time.Time           `json:"checkedAt"`
+	Instances []unbound.Readiness `json:"instances"`
+}
+
 func WithProvider(p provider.Provider) Opts {
 	return func(s *Server) {
 		s.provider = p
@@ -35,69 +69,245 @@ type Server struct {
 	log      *slog.Logger
 	cfg      config.Config
 	provider provider.Provider
+	metrics  *metrics.Metrics
+	ready    *readyCache
+	// tls is set when the webhook is served over https.
+	tls *tls.Config
 }
 
-func New(cfg config.Config, log *slog.Logger, opts ...Opts) *Server {
+// New creates a Server. Unless WithProvider is given, it serves an unbound provider using
+// an http client built from cfg.Opnsense. With several instances configured, records are routed
+// to them by zone when cfg.Routes is set, and plans fan out to all of them otherwise.
+// The requests served, and the opnsense calls of that provider, are reported on MetricsEndpoint.
+// cfg.Listen sets how the webhook is served, over https and with authentication.
+func New(cfg config.Config, log *slog.Logger, opts ...Opts) (*Server, error) {
 	s := &Server{
-		log:      log,
-		cfg:      cfg,
-		provider: unbound.New(http.DefaultClient, cfg, log),
+		log:     log,
+		cfg:     cfg,
+		metrics: metrics.New(),
+		ready:   &readyCache{},
 	}
 
 	for _, opt := range opts {
 		opt(s)
 	}
 
-	return s
-}
+	tlsConfig, err := serverTLS(cfg.ServerTLS, log)
+	if err != nil {
+		return nil, fmt.Errorf("webhook tls: %w", err)
+	}
+	s.tls = tlsConfig
 
-func Serve(ctx context.Context, cfg config.Config, log *slog.Logger) {
-	server := New(cfg, log)
-	DoServe(ctx, server)
+	if s.provider == nil {
+		client, err := unbound.NewClient(cfg.Opnsense, log)
+		if err != nil {
+			return nil, fmt.Errorf("opnsense client: %w", err)
+		}
+		withMetrics := unbound.WithMetrics(s.metrics)
+		switch {
+		case len(cfg.Routes) > 0:
+			s.provider = unbound.NewRouter(client, cfg, log, withMetrics)
+		case len(cfg.Instances) > 0:
+			s.provider = unbound.NewFanOut(client, cfg, log, withMetrics)
+		default:
+			s.provider = unbound.New(client, cfg, log, withMetrics)
+		}
+	}
+
+	if cfg.DryRun {
+		log.Warn("dry run enabled, changes to opnsense are logged and not sent")
+	}
+
+	return s, nil
 }
 
-func DoServe(ctx context.Context, server *Server) {
-	shutdownFN := server.ListenAndServe(ctx, server.Routes())
+// Serve serves the webhook until ctx is done, see ListenAndServe.
+func Serve(ctx context.Context, cfg config.Config, log *slog.Logger) error {
+	server, err := New(cfg, log)
+	if err != nil {
+		return err
+	}
+
+	return DoServe(ctx, server)
+}
 
-	<-ctx.Done()
-	shutdownFN()
+// DoServe serves the routes of server until ctx is done, see ListenAndServe.
+func DoServe(ctx context.Context, server *Server) error {
+	return server.ListenAndServe(ctx, server.Routes())
 }
 
 func (s Server) Routes() *chi.Mux {
 	router := chi.NewRouter()
 	router.Use(middleware.Logger)
-	router.Use(middleware.Recoverer)
-	router.Use(middleware.SetHeader("Content-Type", MediaType))
+	router.Use(recoverer(s.log))
+	router.Use(s.metrics.Middleware)
 
-	router.Get("/", filterHandler(s.provider, s.log))
+	router.Get(HealthEndpoint, healthCheck)
+	router.Get(ReadyEndpoint, readyCheck(s.provider, s.ready, s.log))
+
+	router.Group(func(router chi.Router) {
+		router.Use(authenticate(s.cfg.Auth, s.cfg.ServerTLS.ClientCAFile != "", s.log))
+		router.Use(auditSource)
+
+		router.Method(http.MethodGet, MetricsEndpoint, s.metrics.Handler())
+		router.Mount("/", s.webhook())
+	})
+
+	return router
+}
+
+// webhook serves every version of the webhook protocol, the version of each request being
+// negotiated from its Content-Type and Accept headers. Responses, errors included, are JSON of
+// the media type of the negotiated version.
+func (s Server) webhook() http.Handler {
+	versions := make(map[string]http.Handler, len(protocols))
+	for _, p := range protocols {
+		router := chi.NewRouter()
+		router.NotFound(func(w http.ResponseWriter, r *http.Request) {
+			writeError(r.Context(), w, http.StatusNotFound, errors.New(http.StatusText(http.StatusNotFound)), s.log)
+		})
+		router.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+			writeError(r.Context(), w, http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed)), s.log)
+		})
+		p.routes(s, router)
+		versions[p.version] = router
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		w.Header().Add("Vary", "Accept")
+
+		p, status, err := negotiate(r)
+		if err != nil {
+			s.log.WarnContext(ctx, "unable to negotiate the webhook protocol version", slog.Any("error", err))
+			w.Header().Set("Content-Type", "application/json")
+			writeError(ctx, w, status, err, s.log)
+
+			return
+		}
 
-	router.Get("/healthz", healthCheck())
+		w.Header().Set("Content-Type", p.mediaType())
+		versions[p.version].ServeHTTP(w, r)
+	})
+}
+
+// routesV1 serves version 1 of the webhook protocol.
+func (s Server) routesV1(router chi.Router) {
+	router.Get("/", filterHandler(s.provider, s.log))
 
 	router.Get(RecordsEndpoint, recordsHandler(s.provider, s.log))
 	router.Post(RecordsEndpoint, applyHandler(s.provider, s.log))
 
 	router.Post(AdjustEndpoint, adjustEndpointsHandler(s.provider, s.log))
-
-	return router
 }
 
-func (s Server) ListenAndServe(ctx context.Context, router *chi.Mux) func() {
-	httpServer := http.Server{
-		Addr:              s.cfg.Addr,
+// ListenAndServe serves router until ctx is done, then shuts down gracefully: no new requests are
+// accepted, and the requests in flight, such as a plan being applied, are given cfg.ShutdownGrace
+// to finish before their connections are closed. Failing to listen, eg on an address in use,
+// is returned straight away.
+func (s Server) ListenAndServe(ctx context.Context, router http.Handler) error {
+	listener, err := s.listen()
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	httpServer := &http.Server{
 		ReadHeaderTimeout: 3 * time.Second,
 		Handler:           router,
 	}
 
+	served := make(chan error, 1)
 	go func() {
-		if err := httpServer.ListenAndServe(); err != nil {
-			s.log.ErrorContext(ctx, "error serving", slog.Any("error", err))
-		}
+		served <- httpServer.Serve(listener)
 	}()
 
-	return func() {
-		if err := httpServer.Shutdown(ctx); err != nil {
-			s.log.ErrorContext(ctx, "issue shutting down server", slog.Any("error", err))
+	select {
+	case err := <-served:
+		return fmt.Errorf("serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	s.log.InfoContext(ctx, "shutting down, waiting for requests in flight", slog.Duration("grace", s.cfg.ShutdownGrace))
+
+	// ctx is already done, the grace period starts now
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.cfg.ShutdownGrace)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		closeErr := httpServer.Close()
+		<-served
+		return errors.Join(fmt.Errorf("shutdown: %w", err), closeErr)
+	}
+
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve: %w", err)
+	}
+
+	return nil
+}
+
+// listen on the unix socket or address of the config, over tls when it is enabled.
+func (s Server) listen() (net.Listener, error) {
+	var (
+		listener net.Listener
+		err      error
+	)
+	if s.cfg.Socket != "" {
+		// a socket left behind by a previous process would make listening fail
+		if info, statErr := os.Stat(s.cfg.Socket); statErr == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(s.cfg.Socket); err != nil {
+				return nil, fmt.Errorf("remove stale socket: %w", err)
+			}
 		}
+		listener, err = net.Listen("unix", s.cfg.Socket)
+	} else {
+		listener, err = net.Listen("tcp", s.cfg.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s.tls != nil {
+		listener = tls.NewListener(listener, s.tls)
+	}
+	return listener, nil
+}
+
+// auditSource records the client of the webhook as the source of the changes it asks for.
+func auditSource(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := audit.WithSource(r.Context(), audit.Source{Kind: audit.SourceWebhook, RemoteAddr: r.RemoteAddr})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// recoverer answers a panicking handler with 500 Internal Server Error as an ErrorResponse, in the
+// Content-Type negotiated before the panic or else of the preferred protocol version.
+func recoverer(log *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+				if err, ok := recovered.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					// the connection is aborted on purpose, there is nothing to answer
+					panic(recovered)
+				}
+
+				ctx := r.Context()
+				log.ErrorContext(ctx, "recovered from panic",
+					slog.Any("panic", recovered),
+					slog.String("stack", string(debug.Stack())))
+				if w.Header().Get("Content-Type") == "" {
+					w.Header().Set("Content-Type", MediaType)
+				}
+				writeError(ctx, w, http.StatusInternalServerError, errors.New(http.StatusText(http.StatusInternalServerError)), log)
+			}()
+
+			next.ServeHTTP(w, r)
+		})
 	}
 }
 
@@ -116,7 +326,7 @@ func recordsHandler(provider provider.Provider, log *slog.Logger) http.HandlerFu
 		endpoints, err := provider.Records(ctx)
 		if err != nil {
 			log.ErrorContext(ctx, "error getting records", slog.Any("err", err))
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(ctx, w, http.StatusInternalServerError, err, log)
 
 			return
 		}
@@ -136,14 +346,14 @@ func applyHandler(provider provider.Provider, log *slog.Logger) http.HandlerFunc
 		err := json.NewDecoder(request.Body).Decode(thePlan)
 		if err != nil {
 			log.ErrorContext(ctx, "failed to unmarshal the plan", slog.Any("error", err))
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(ctx, w, http.StatusBadRequest, err, log)
 
 			return
 		}
 
 		if err := provider.ApplyChanges(ctx, thePlan); err != nil {
 			log.ErrorContext(ctx, "failed to apply the plan", slog.Any("error", err))
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(ctx, w, http.StatusInternalServerError, err, log)
 
 			return
 		}
@@ -159,7 +369,7 @@ func adjustEndpointsHandler(provider provider.Provider, log *slog.Logger) http.H
 		endpoints := []*endpoint.Endpoint{}
 		if err := json.NewDecoder(r.Body).Decode(&endpoints); err != nil {
 			log.ErrorContext(ctx, "failed to unmarshal endpoints", slog.Any("error", err))
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(ctx, w, http.StatusBadRequest, err, log)
 
 			return
 		}
@@ -167,7 +377,7 @@ func adjustEndpointsHandler(provider provider.Provider, log *slog.Logger) http.H
 		results, err := provider.AdjustEndpoints(endpoints)
 		if err != nil {
 			log.ErrorContext(ctx, "failed to adjust enpoints", slog.Any("error", err))
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(ctx, w, http.StatusInternalServerError, err, log)
 
 			return
 		}
@@ -176,9 +386,44 @@ func adjustEndpointsHandler(provider provider.Provider, log *slog.Logger) http.H
 	}
 }
 
-func healthCheck() http.HandlerFunc {
-	return func(w http.ResponseWriter, _ *http.Request) {
-		w.WriteHeader(http.StatusOK)
+// healthCheck is the liveness check, it only shows the server is serving.
+func healthCheck(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// readyCache holds the last readiness check. Its lock is held during a check, so concurrent
+// probes wait for a single check.
+type readyCache struct {
+	mu     sync.Mutex
+	status ReadyStatus
+}
+
+// readyCheck reports whether provider can reach its backend, answering 503 Service Unavailable
+// when it cannot. Providers that are not a ReadinessChecker are always ready.
+func readyCheck(provider provider.Provider, cache *readyCache, log *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		cache.mu.Lock()
+		if time.Since(cache.status.CheckedAt) >= readyCacheTTL {
+			status := ReadyStatus{Ready: true, CheckedAt: time.Now(), Instances: []unbound.Readiness{}}
+			if checker, ok := provider.(ReadinessChecker); ok {
+				status.Instances = checker.Ready(ctx)
+			}
+			for _, instance := range status.Instances {
+				status.Ready = status.Ready && instance.Ready
+			}
+			cache.status = status
+		}
+		status := cache.status
+		cache.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if !status.Ready {
+			log.WarnContext(ctx, "not ready", slog.Any("instances", status.Instances))
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		writeJSON(ctx, w, status, log)
 	}
 }
 
@@ -186,7 +431,7 @@ func writeJSON(ctx context.Context, w http.ResponseWriter, output any, log *slog
 	jsonBytes, err := json.Marshal(output)
 	if err != nil {
 		log.ErrorContext(ctx, "error marshalling records", slog.Any("err", err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(ctx, w, http.StatusInternalServerError, err, log)
 
 		return
 	}
@@ -195,3 +440,18 @@ func writeJSON(ctx context.Context, w http.ResponseWriter, output any, log *slog
 		log.ErrorContext(ctx, "error writing response", slog.Any("err", err))
 	}
 }
+
+// writeWebhookError answers err as an ErrorResponse of the preferred protocol version, for
+// requests refused before their version is negotiated.
+func writeWebhookError(ctx context.Context, w http.ResponseWriter, status int, err error, log *slog.Logger) {
+	w.Header().Set("Content-Type", MediaType)
+	writeError(ctx, w, status, err, log)
+}
+
+// writeError answers err as an ErrorResponse, in the Content-Type already set.
+func writeError(ctx context.Context, w http.ResponseWriter, status int, err error, log *slog.Logger) {
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()}); err != nil {
+		log.ErrorContext(ctx, "error writing response", slog.Any("err", err))
+	}
+}
@@ -7,18 +7,26 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/MrUsefull/boundation/internal/audit"
 	"github.com/MrUsefull/boundation/internal/config"
 	"github.com/MrUsefull/boundation/internal/server"
+	"github.com/MrUsefull/boundation/internal/unbound"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/goleak"
 	"sigs.k8s.io/external-dns/endpoint"
 	"sigs.k8s.io/external-dns/plan"
+	"sigs.k8s.io/external-dns/provider"
 )
 
 func TestMain(m *testing.M) {
@@ -48,6 +56,15 @@ func (tp testProvider) GetDomainFilter() endpoint.DomainFilter {
 	return tp.domainFilterResp
 }
 
+// panickingProvider panics reading records.
+type panickingProvider struct {
+	testProvider
+}
+
+func (p panickingProvider) Records(_ context.Context) ([]*endpoint.Endpoint, error) {
+	panic("boom")
+}
+
 func TestServe(t *testing.T) {
 	t.Parallel()
 	cfg := config.Config{
@@ -57,19 +74,109 @@ func TestServe(t *testing.T) {
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	cancel()
 
-	var wg sync.WaitGroup
-	defer wg.Wait()
+	// just testing we shut down, the rest is tested in other tests
+	assert.NoError(t, server.Serve(ctx, cfg, slog.Default()))
+}
 
-	wg.Add(1)
+func TestServe_addressInUse(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	cfg := config.Config{Listen: config.Listen{Addr: listener.Addr().String()}}
+	assert.Error(t, server.Serve(context.Background(), cfg, slog.Default()))
+}
+
+// blockingProvider applies a plan once release is closed, closing applying when a plan arrives.
+type blockingProvider struct {
+	testProvider
+	applying chan struct{}
+	release  chan struct{}
+}
+
+func (p blockingProvider) ApplyChanges(_ context.Context, _ *plan.Changes) error {
+	close(p.applying)
+	<-p.release
+	return nil
+}
+
+// startApply serves subject on addr until the returned cancel is called, and posts a plan to it.
+// It returns once the plan is being applied, with channels for the outcome of the post and serve.
+func startApply(t *testing.T, addr string, subject *server.Server, provider blockingProvider) (context.CancelFunc, <-chan error, <-chan int) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
 	go func() {
-		defer wg.Done()
-		go server.Serve(ctx, cfg, slog.Default())
+		served <- server.DoServe(ctx, subject)
+	}()
+
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(fmt.Sprintf("http://%v%v", addr, server.HealthEndpoint)) //nolint:noctx
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+
+	status := make(chan int, 1)
+	go func() {
+		body, _ := json.Marshal(plan.Changes{Create: []*endpoint.Endpoint{endpoint.NewEndpoint("foo.bar.fqdn", endpoint.RecordTypeA, "10.0.0.1")}})
+		resp, err := http.Post(fmt.Sprintf("http://%v%v", addr, server.RecordsEndpoint), server.MediaType, bytes.NewReader(body)) //nolint:noctx
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
 	}()
+	<-provider.applying
+
+	return cancel, served, status
+}
 
+func TestDoServe_drainsApplies(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{Listen: config.Listen{Addr: "127.0.0.1:6790", ShutdownGrace: 5 * time.Second}}
+	provider := blockingProvider{applying: make(chan struct{}), release: make(chan struct{})}
+	subject, err := server.New(cfg, slog.Default(), server.WithProvider(provider))
+	require.NoError(t, err)
+
+	cancel, served, status := startApply(t, cfg.Addr, subject, provider)
 	cancel()
-	// just testing we shut down, the rest is tested in other tests
+
+	select {
+	case err := <-served:
+		t.Fatalf("stopped serving during an apply: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(provider.release)
+	assert.Equal(t, http.StatusNoContent, <-status)
+	assert.NoError(t, <-served)
+}
+
+func TestDoServe_graceExpires(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{Listen: config.Listen{Addr: "127.0.0.1:6791", ShutdownGrace: 50 * time.Millisecond}}
+	provider := blockingProvider{applying: make(chan struct{}), release: make(chan struct{})}
+	subject, err := server.New(cfg, slog.Default(), server.WithProvider(provider))
+	require.NoError(t, err)
+
+	cancel, served, status := startApply(t, cfg.Addr, subject, provider)
+	cancel()
+
+	assert.ErrorIs(t, <-served, context.DeadlineExceeded)
+	close(provider.release)
+	// the connection was closed without a response
+	assert.Equal(t, 0, <-status)
 }
 
 func TestServer(t *testing.T) {
@@ -98,7 +205,8 @@ func TestServer(t *testing.T) {
 		},
 		recordsResp: expectedEndpoints,
 	}
-	subject := server.New(cfg, slog.Default(), server.WithProvider(provider))
+	subject, err := server.New(cfg, slog.Default(), server.WithProvider(provider))
+	require.NoError(t, err)
 
 	var wg sync.WaitGroup
 	defer wg.Wait()
@@ -109,7 +217,7 @@ func TestServer(t *testing.T) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		server.DoServe(ctx, subject)
+		assert.NoError(t, server.DoServe(ctx, subject))
 	}()
 
 	require.Eventually(t, func() bool {
@@ -206,3 +314,313 @@ func decodeJSON(tb testing.TB, data io.Reader, val interface{}) {
 	tb.Helper()
 	require.NoError(tb, json.NewDecoder(data).Decode(val))
 }
+
+func TestServer_concurrentRequests(t *testing.T) {
+	t.Parallel()
+
+	opnsense := httptest.NewServer(testhelpers.NewFakeOpnsense())
+	defer opnsense.Close()
+
+	cfg := config.Config{Opnsense: config.Opnsense{BaseURL: opnsense.URL, Creds: "foo:bar"}}
+	provider := unbound.New(opnsense.Client(), cfg, slog.Default())
+	subject, err := server.New(cfg, slog.Default(), server.WithProvider(provider))
+	require.NoError(t, err)
+	webhook := httptest.NewServer(subject.Routes())
+	defer webhook.Close()
+
+	const requests = 10
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		i := i
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			body, err := json.Marshal(plan.Changes{
+				Create: []*endpoint.Endpoint{
+					endpoint.NewEndpoint(fmt.Sprintf("host%d.example.domain", i), endpoint.RecordTypeA, "10.0.0.1"),
+					endpoint.NewEndpoint(fmt.Sprintf("host%d.example.domain", i), endpoint.RecordTypeTXT, "heritage=external-dns"),
+				},
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusNoContent, doRequest(t, http.MethodPost, webhook.URL+server.RecordsEndpoint, body))
+		}()
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, http.StatusOK, doRequest(t, http.MethodGet, webhook.URL+server.RecordsEndpoint, nil))
+		}()
+	}
+	wg.Wait()
+
+	resp, err := http.Get(webhook.URL + server.RecordsEndpoint) //nolint:noctx
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	endpoints := make([]*endpoint.Endpoint, 0)
+	decodeJSON(t, resp.Body, &endpoints)
+	// each host and its two ownership TXT records
+	assert.Len(t, endpoints, requests*3)
+}
+
+// doRequest returns the status code of the response. It is safe to call from any goroutine.
+func doRequest(tb testing.TB, method string, url string, body []byte) int {
+	tb.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), method, url, bytes.NewReader(body))
+	if !assert.NoError(tb, err) {
+		return 0
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(tb, err) {
+		return 0
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, resp.Body)
+	assert.NoError(tb, err)
+
+	return resp.StatusCode
+}
+
+type readyProvider struct {
+	testProvider
+	readiness []unbound.Readiness
+	checks    *atomic.Int32
+}
+
+func (p readyProvider) Ready(_ context.Context) []unbound.Readiness {
+	p.checks.Add(1)
+	return p.readiness
+}
+
+func TestServer_healthz(t *testing.T) {
+	t.Parallel()
+
+	// liveness does not depend on opnsense
+	notReady := readyProvider{readiness: []unbound.Readiness{{Instance: "primary"}}, checks: &atomic.Int32{}}
+	subject, err := server.New(config.Config{}, slog.Default(), server.WithProvider(notReady))
+	require.NoError(t, err)
+	webhook := httptest.NewServer(subject.Routes())
+	defer webhook.Close()
+
+	assert.Equal(t, http.StatusOK, doRequest(t, http.MethodGet, webhook.URL+server.HealthEndpoint, nil))
+	assert.Equal(t, int32(0), notReady.checks.Load())
+}
+
+func TestServer_readyz(t *testing.T) {
+	t.Parallel()
+
+	ready := unbound.Readiness{
+		Instance:      "primary",
+		Ready:         true,
+		Reachable:     true,
+		Authenticated: true,
+		Circuit:       unbound.CircuitClosed,
+	}
+	unauthorized := unbound.Readiness{
+		Instance:  "backup",
+		Reachable: true,
+		Circuit:   unbound.CircuitClosed,
+		Error:     "401 Unauthorized",
+	}
+
+	tests := []struct {
+		name      string
+		provider  provider.Provider
+		want      int
+		wantReady bool
+		wantLen   int
+	}{
+		{
+			name:      "no readiness check",
+			provider:  testProvider{},
+			want:      http.StatusOK,
+			wantReady: true,
+		},
+		{
+			name:      "ready",
+			provider:  readyProvider{readiness: []unbound.Readiness{ready}, checks: &atomic.Int32{}},
+			want:      http.StatusOK,
+			wantReady: true,
+			wantLen:   1,
+		},
+		{
+			name:     "an instance is not ready",
+			provider: readyProvider{readiness: []unbound.Readiness{ready, unauthorized}, checks: &atomic.Int32{}},
+			want:     http.StatusServiceUnavailable,
+			wantLen:  2,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			subject, err := server.New(config.Config{}, slog.Default(), server.WithProvider(tt.provider))
+			require.NoError(t, err)
+			webhook := httptest.NewServer(subject.Routes())
+			defer webhook.Close()
+
+			resp, err := http.Get(webhook.URL + server.ReadyEndpoint) //nolint:noctx
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.want, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			status := server.ReadyStatus{}
+			decodeJSON(t, resp.Body, &status)
+			assert.Equal(t, tt.wantReady, status.Ready)
+			assert.Len(t, status.Instances, tt.wantLen)
+		})
+	}
+}
+
+func TestServer_readyzCached(t *testing.T) {
+	t.Parallel()
+
+	provider := readyProvider{readiness: []unbound.Readiness{{Instance: "primary", Ready: true}}, checks: &atomic.Int32{}}
+	subject, err := server.New(config.Config{}, slog.Default(), server.WithProvider(provider))
+	require.NoError(t, err)
+	webhook := httptest.NewServer(subject.Routes())
+	defer webhook.Close()
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, doRequest(t, http.MethodGet, webhook.URL+server.ReadyEndpoint, nil))
+	}
+	assert.Equal(t, int32(1), provider.checks.Load())
+}
+
+func TestNew_invalidClient(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{Opnsense: config.Opnsense{TLS: config.TLS{CAFile: "missing.pem"}}}
+	_, err := server.New(cfg, slog.Default())
+	assert.Error(t, err)
+}
+
+func TestServer_metrics(t *testing.T) {
+	t.Parallel()
+
+	opnsense := httptest.NewServer(testhelpers.NewFakeOpnsense())
+	defer opnsense.Close()
+
+	cfg := config.Config{Opnsense: config.Opnsense{BaseURL: opnsense.URL, Creds: "foo:bar"}}
+	subject, err := server.New(cfg, slog.Default())
+	require.NoError(t, err)
+	webhook := httptest.NewServer(subject.Routes())
+	defer webhook.Close()
+
+	body, err := json.Marshal(plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("host.example.domain", endpoint.RecordTypeA, "10.0.0.1")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, doRequest(t, http.MethodPost, webhook.URL+server.RecordsEndpoint, body))
+	require.Equal(t, http.StatusOK, doRequest(t, http.MethodGet, webhook.URL+server.RecordsEndpoint, nil))
+
+	resp, err := http.Get(webhook.URL + server.MetricsEndpoint) //nolint:noctx
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+	scraped, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	for _, want := range []string{
+		`boundation_http_requests_total{code="204",method="POST",route="/records"} 1`,
+		`boundation_http_requests_total{code="200",method="GET",route="/records"} 1`,
+		`boundation_http_request_duration_seconds_count{method="GET",route="/records"} 1`,
+		`boundation_opnsense_requests_total{endpoint="addHostOverride",instance="primary"} 1`,
+		`boundation_opnsense_requests_total{endpoint="reconfigure",instance="primary"} 1`,
+		`boundation_opnsense_request_duration_seconds_count{endpoint="searchHostOverride",instance="primary"}`,
+		`boundation_managed_records{instance="primary",type="A"} 1`,
+		`boundation_cache_rows{instance="primary"} 1`,
+		`boundation_last_apply_success_timestamp_seconds{instance="primary"}`,
+	} {
+		assert.Contains(t, string(scraped), want)
+	}
+	assert.NotContains(t, string(scraped), "boundation_opnsense_request_errors_total{")
+}
+
+func TestServe_socket(t *testing.T) {
+	t.Parallel()
+
+	socket := filepath.Join(t.TempDir(), "webhook.sock")
+	// left behind by a previous process
+	stale, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	cfg := config.Config{Listen: config.Listen{Socket: socket, ShutdownGrace: time.Second}}
+	subject, err := server.New(cfg, slog.Default(), server.WithProvider(testProvider{}))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- server.DoServe(ctx, subject)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+		DisableKeepAlives: true,
+	}}
+	require.Eventually(t, func() bool {
+		resp, err := client.Get("http://webhook" + server.RecordsEndpoint) //nolint:noctx
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-served)
+	assert.NoFileExists(t, socket)
+}
+
+func TestServer_auditSource(t *testing.T) {
+	t.Parallel()
+
+	opnsense := httptest.NewServer(testhelpers.NewFakeOpnsense())
+	defer opnsense.Close()
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	cfg := config.Config{
+		Opnsense: config.Opnsense{BaseURL: opnsense.URL, Creds: "foo:bar"},
+		Audit:    config.Audit{Path: path},
+	}
+	subject, err := server.New(cfg, slog.Default())
+	require.NoError(t, err)
+	webhook := httptest.NewServer(subject.Routes())
+	defer webhook.Close()
+
+	body, err := json.Marshal(plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("host.example.domain", endpoint.RecordTypeA, "10.0.0.1")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, doRequest(t, http.MethodPost, webhook.URL+server.RecordsEndpoint, body))
+
+	entries, err := audit.Read(path, audit.Query{Host: "host.example.domain"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, audit.SourceWebhook, entries[0].Source.Kind)
+	assert.Contains(t, entries[0].Source.RemoteAddr, "127.0.0.1:")
+	assert.Equal(t, audit.OutcomeSuccess, entries[0].Outcome)
+}
+
+func TestServer_recoversPanics(t *testing.T) {
+	t.Parallel()
+
+	subject, err := server.New(config.Config{}, slog.Default(), server.WithProvider(panickingProvider{}))
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodGet, server.RecordsEndpoint, nil)
+	recorder := httptest.NewRecorder()
+	subject.Routes().ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Equal(t, server.MediaType, recorder.Header().Get("Content-Type"))
+	var got server.ErrorResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &got))
+	assert.Equal(t, server.ErrorResponse{Error: http.StatusText(http.StatusInternalServerError)}, got)
+}
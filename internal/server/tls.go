@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MrUsefull/boundation/internal/config"
+)
+
+// ErrInvalidClientCA is returned when the client CA file holds no PEM certificates.
+var ErrInvalidClientCA = errors.New("no certificates found in client ca file")
+
+// serverTLS builds the tls config the webhook is served with, or nil when cfg does not enable tls.
+// With a client CA, client certificates are verified when given, and authenticate rejects the
+// requests to the webhook routes that did not give one.
+func serverTLS(cfg config.ServerTLS, log *slog.Logger) (*tls.Config, error) {
+	if cfg.CertFile == "" {
+		return nil, nil
+	}
+
+	certs := &certReloader{certFile: cfg.CertFile, keyFile: cfg.KeyFile, log: log}
+	if _, err := certs.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client ca file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%v: %w", cfg.ClientCAFile, ErrInvalidClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		// the health and readiness checks are served to clients without a certificate
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
+
+// certReloader serves a certificate and key pair, loading them again whenever either file is
+// modified, eg by cert-manager renewing a mounted secret.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	log      *slog.Logger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// GetCertificate returns the current certificate. When the files cannot be loaded, the
+// certificate loaded last keeps being served.
+func (c *certReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	modTime, err := c.lastModified()
+	if err == nil && c.cert != nil && modTime.Equal(c.modTime) {
+		return c.cert, nil
+	}
+
+	if err == nil {
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err == nil {
+			c.cert, c.modTime = &cert, modTime
+			return c.cert, nil
+		}
+	}
+
+	if c.cert == nil {
+		return nil, fmt.Errorf("server certificate: %w", err)
+	}
+	c.log.Warn("unable to reload server certificate, serving the previous one", slog.Any("error", err))
+	return c.cert, nil
+}
+
+// lastModified is the latest modification time of the certificate and key files.
+func (c *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("stat server certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,212 @@
+package server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert writes a certificate for localhost to dir, signed by parent or self signed when
+// parent is nil.
+func newTestCert(t *testing.T, dir string, name string, parent *testCert) testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	out := testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".pem"),
+		keyFile:  filepath.Join(dir, name+"-key.pem"),
+	}
+	require.NoError(t, os.WriteFile(out.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(out.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return out
+}
+
+// serveTLS serves subject until the test ends.
+func serveTLS(t *testing.T, subject *server.Server) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- server.DoServe(ctx, subject)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-served)
+	})
+}
+
+func tlsClient(ca *x509.Certificate, certs ...tls.Certificate) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool, Certificates: certs, MinVersion: tls.VersionTLS12},
+		DisableKeepAlives: true,
+	}}
+}
+
+func TestServer_tlsReload(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	first := newTestCert(t, dir, "server", nil)
+	cfg := config.Config{Listen: config.Listen{
+		Addr:          "127.0.0.1:6792",
+		ShutdownGrace: time.Second,
+		ServerTLS:     config.ServerTLS{CertFile: first.certFile, KeyFile: first.keyFile},
+	}}
+	subject, err := server.New(cfg, slog.Default(), server.WithProvider(testProvider{}))
+	require.NoError(t, err)
+	serveTLS(t, subject)
+
+	served := func(ca *x509.Certificate) *big.Int {
+		var serial *big.Int
+		require.Eventually(t, func() bool {
+			resp, err := tlsClient(ca).Get(fmt.Sprintf("https://%v%v", cfg.Addr, server.HealthEndpoint)) //nolint:noctx
+			if err != nil {
+				return false
+			}
+			defer resp.Body.Close()
+			serial = resp.TLS.PeerCertificates[0].SerialNumber
+			return true
+		}, time.Second, 10*time.Millisecond)
+		return serial
+	}
+	assert.Equal(t, first.cert.SerialNumber, served(first.cert))
+
+	// the renewed certificate is written over the old one
+	second := newTestCert(t, dir, "server", nil)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(second.certFile, later, later))
+	require.NoError(t, os.Chtimes(second.keyFile, later, later))
+	assert.Equal(t, second.cert.SerialNumber, served(second.cert))
+}
+
+func TestServer_clientCertificates(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	serverCert := newTestCert(t, dir, "server", &ca)
+	clientCert := newTestCert(t, dir, "client", &ca)
+	untrusted := newTestCert(t, dir, "untrusted", nil)
+
+	cfg := config.Config{Listen: config.Listen{
+		Addr:          "127.0.0.1:6793",
+		ShutdownGrace: time.Second,
+		ServerTLS: config.ServerTLS{
+			CertFile:     serverCert.certFile,
+			KeyFile:      serverCert.keyFile,
+			ClientCAFile: ca.certFile,
+		},
+	}}
+	subject, err := server.New(cfg, slog.Default(), server.WithProvider(testProvider{}))
+	require.NoError(t, err)
+	serveTLS(t, subject)
+
+	keyPair := func(cert testCert) tls.Certificate {
+		pair, err := tls.LoadX509KeyPair(cert.certFile, cert.keyFile)
+		require.NoError(t, err)
+		return pair
+	}
+	status := func(client *http.Client, path string) int {
+		resp, err := client.Get(fmt.Sprintf("https://%v%v", cfg.Addr, path)) //nolint:noctx
+		if err != nil {
+			return 0
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	require.Eventually(t, func() bool {
+		return status(tlsClient(ca.cert), server.HealthEndpoint) == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusUnauthorized, status(tlsClient(ca.cert), server.RecordsEndpoint))
+	assert.Equal(t, http.StatusOK, status(tlsClient(ca.cert, keyPair(clientCert)), server.RecordsEndpoint))
+	// a certificate from another CA is not offered to the server
+	assert.Equal(t, http.StatusUnauthorized, status(tlsClient(ca.cert, keyPair(untrusted)), server.RecordsEndpoint))
+}
+
+func TestNew_invalidServerTLS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cert := newTestCert(t, dir, "server", nil)
+	tests := []struct {
+		name string
+		tls  config.ServerTLS
+	}{
+		{
+			name: "missing certificate",
+			tls:  config.ServerTLS{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: cert.keyFile},
+		},
+		{
+			name: "client ca without certificates",
+			tls:  config.ServerTLS{CertFile: cert.certFile, KeyFile: cert.keyFile, ClientCAFile: cert.keyFile},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := server.New(config.Config{Listen: config.Listen{ServerTLS: tt.tls}}, slog.Default(), server.WithProvider(testProvider{}))
+			assert.Error(t, err)
+		})
+	}
+}
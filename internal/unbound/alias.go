@@ -0,0 +1,125 @@
+package unbound
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// Alias is an opnsense host override alias, an extra name attached to an existing override.
+// Internally an alias is carried as a CNAME Record whose Server is the dns name of its override,
+// so the cache, journal and update planning treat overrides and aliases alike.
+type Alias struct {
+	UUID string `json:"uuid,omitempty"`
+	// Host is the uuid of the override the alias is attached to. Search responses may
+	// return the display name of the override instead.
+	Host        string `json:"host"`
+	Hostname    string `json:"hostname"`
+	Domain      string `json:"domain"`
+	Enabled     string `json:"enabled"`
+	Description string `json:"description"`
+}
+
+// DNSName is the name of the alias. Aliases at a zone apex have an empty hostname.
+func (a Alias) DNSName() string {
+	return joinDNSName(a.Hostname, a.Domain)
+}
+
+type AddAliasRequest struct {
+	Alias Alias `json:"alias"`
+}
+
+// aliasRecords converts aliases into CNAME records targeting the override they are attached to.
+// Aliases attached to an override that cannot be found are dropped.
+func aliasRecords(aliases []Alias, overrides []Record) []Record {
+	parents := make(map[string]string, len(overrides))
+	for _, override := range overrides {
+		parents[override.UUID] = override.DNSName()
+	}
+
+	out := make([]Record, 0, len(aliases))
+	for _, alias := range aliases {
+		target, ok := parents[alias.Host]
+		if !ok {
+			// not a uuid, the search response holds the display name of the override
+			target = strings.TrimSuffix(alias.Host, ".")
+		}
+		if !strings.Contains(target, ".") {
+			continue
+		}
+
+		out = append(out, Record{
+			UUID:        alias.UUID,
+			Hostname:    alias.Hostname,
+			Domain:      alias.Domain,
+			Rr:          endpoint.RecordTypeCNAME,
+			Server:      target,
+			Enabled:     alias.Enabled,
+			Description: alias.Description,
+		})
+	}
+
+	return out
+}
+
+func isAlias(record Record) bool {
+	return record.RecordType() == endpoint.RecordTypeCNAME
+}
+
+// aliasable reports whether every target of a CNAME endpoint is an override opnsense knows about.
+// Aliases can only be attached to overrides, so any other CNAME cannot be represented in unbound.
+func (u Unbound) aliasable(ep *endpoint.Endpoint) bool {
+	if ep.RecordType != endpoint.RecordTypeCNAME || len(ep.Targets) == 0 {
+		return false
+	}
+
+	for _, target := range ep.Targets {
+		if _, ok := u.knownRecords.overrideUUID(target); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// aliasJSON is the add/set request body for an alias record, attached to the override named by its Server.
+func (u Unbound) aliasJSON(record Record) ([]byte, error) {
+	host, ok := u.knownRecords.overrideUUID(record.Server)
+	if !ok {
+		return nil, fmt.Errorf("%q: %w", record.Server, ErrAliasTarget)
+	}
+
+	request := &AddAliasRequest{
+		Alias: Alias{
+			Host:        host,
+			Hostname:    record.Hostname,
+			Domain:      record.Domain,
+			Enabled:     record.Enabled,
+			Description: record.Description,
+		},
+	}
+
+	jsonOut, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+
+	return jsonOut, nil
+}
+
+// partitionAliases splits endpoints into overrides and aliases, keeping their order.
+// Overrides are created before and deleted after the aliases that may be attached to them.
+func partitionAliases(endpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, []*endpoint.Endpoint) {
+	overrides := make([]*endpoint.Endpoint, 0, len(endpoints))
+	aliases := make([]*endpoint.Endpoint, 0)
+	for _, ep := range endpoints {
+		if ep.RecordType == endpoint.RecordTypeCNAME {
+			aliases = append(aliases, ep)
+		} else {
+			overrides = append(overrides, ep)
+		}
+	}
+	return overrides, aliases
+}
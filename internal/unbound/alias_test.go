@@ -0,0 +1,62 @@
+package unbound
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func Test_aliasRecords(t *testing.T) {
+	t.Parallel()
+
+	overrides := []Record{
+		{UUID: "uuid-1", Hostname: "lb", Domain: "example.domain", Rr: "A", Server: "10.0.0.1"},
+	}
+
+	tests := []struct {
+		name    string
+		aliases []Alias
+		want    []Record
+	}{
+		{
+			name:    "attached by uuid",
+			aliases: []Alias{{UUID: "alias-1", Host: "uuid-1", Hostname: "www", Domain: "example.domain", Enabled: "1"}},
+			want: []Record{
+				{
+					UUID:     "alias-1",
+					Hostname: "www",
+					Domain:   "example.domain",
+					Rr:       endpoint.RecordTypeCNAME,
+					Server:   "lb.example.domain",
+					Enabled:  "1",
+				},
+			},
+		},
+		{
+			name:    "attached by display name",
+			aliases: []Alias{{UUID: "alias-1", Host: "lb.example.domain.", Hostname: "www", Domain: "example.domain"}},
+			want: []Record{
+				{
+					UUID:     "alias-1",
+					Hostname: "www",
+					Domain:   "example.domain",
+					Rr:       endpoint.RecordTypeCNAME,
+					Server:   "lb.example.domain",
+				},
+			},
+		},
+		{
+			name:    "unknown override is dropped",
+			aliases: []Alias{{UUID: "alias-1", Host: "uuid-2", Hostname: "www", Domain: "example.domain"}},
+			want:    []Record{},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, aliasRecords(tt.aliases, overrides))
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package unbound
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+
+	"github.com/MrUsefull/boundation/internal/audit"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+// Actions recorded in the audit log.
+const (
+	auditApply   = "apply"
+	auditEnable  = "enable"
+	auditPurge   = "purge"
+	auditMigrate = "migrate"
+	auditDomains = "domains"
+)
+
+// WithAuditLog appends every change to log, replacing the audit log selected by the config so
+// instances can share one.
+func WithAuditLog(log *audit.Log) Opts {
+	return func(u *Unbound) {
+		u.audit = log
+	}
+}
+
+// audited appends the outcome of action to the audit log: the plan, the hosts asked for, and the
+// rows touched by mutations, including those made by a rollback, with the source recorded in ctx. The changes are already made, so
+// failing to write the log is only logged. Dry runs change nothing and are not audited.
+func (u Unbound) audited(ctx context.Context, action string, changes *plan.Changes, hosts []string, mutations []mutation, err error) {
+	if u.audit == nil || u.dryRun != nil {
+		return
+	}
+
+	entry := audit.Entry{
+		Time:     u.now().UTC(),
+		Source:   audit.SourceFrom(ctx),
+		Instance: u.instance,
+		Action:   action,
+		Plan:     changes,
+		Changes:  make([]audit.Change, 0, len(mutations)),
+		Outcome:  audit.OutcomeSuccess,
+	}
+	if err != nil {
+		entry.Outcome = audit.OutcomeFailure
+		entry.Error = err.Error()
+	}
+
+	names := make(map[string]struct{})
+	for _, host := range hosts {
+		names[audit.NormalizeHost(host)] = struct{}{}
+	}
+	if changes != nil {
+		for _, endpoints := range [][]*endpoint.Endpoint{changes.Create, changes.UpdateOld, changes.UpdateNew, changes.Delete} {
+			for _, ep := range endpoints {
+				// ownership TXT records are kept in the descriptions of the rows
+				if managedType(ep.RecordType) {
+					names[audit.NormalizeHost(ep.DNSName)] = struct{}{}
+				}
+			}
+		}
+	}
+	for _, m := range mutations {
+		change := auditChange(m)
+		names[audit.NormalizeHost(change.DNSName)] = struct{}{}
+		entry.Changes = append(entry.Changes, change)
+	}
+	entry.Hosts = make([]string, 0, len(names))
+	for name := range names {
+		entry.Hosts = append(entry.Hosts, name)
+	}
+	sort.Strings(entry.Hosts)
+
+	if err := u.audit.Append(entry); err != nil {
+		u.logger.ErrorContext(ctx, "unable to write the audit log",
+			slog.String("action", action),
+			slog.Any("error", err))
+	}
+}
+
+// auditChange describes m as written to the audit log. Domain overrides have no record type, their
+// target is the server the domain is forwarded to.
+func auditChange(m mutation) audit.Change {
+	if m.domain != nil {
+		return audit.Change{
+			Kind:     string(m.kind),
+			UUID:     m.domain.UUID,
+			DNSName:  m.domain.Domain,
+			Target:   m.domain.Server,
+			Rollback: m.undo,
+		}
+	}
+
+	return audit.Change{
+		Kind:       string(m.kind),
+		UUID:       m.record.UUID,
+		DNSName:    m.record.DNSName(),
+		RecordType: m.record.RecordType(),
+		Target:     m.record.Target(),
+		Rollback:   m.undo,
+	}
+}
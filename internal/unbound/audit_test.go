@@ -0,0 +1,149 @@
+package unbound
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MrUsefull/boundation/internal/audit"
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestUnbound_audited(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(testhelpers.NewFakeOpnsense())
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	source := audit.Source{Kind: audit.SourceCLI, User: "alice", Hostname: "laptop"}
+	ctx := audit.WithSource(context.Background(), source)
+	now := testClock()
+	cfg := config.Config{
+		Opnsense: config.Opnsense{BaseURL: server.URL, Creds: "foo:bar"},
+		Audit:    config.Audit{Path: path},
+	}
+	u := New(server.Client(), cfg, GetTestLogger(), WithClock(func() time.Time { return now }))
+
+	created := endpoint.NewEndpoint("Host.example.domain", endpoint.RecordTypeA, "10.0.0.1")
+	require.NoError(t, u.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			created,
+			endpoint.NewEndpoint("a-host.example.domain", endpoint.RecordTypeTXT, "heritage=external-dns"),
+		},
+	}))
+
+	// the delete is rolled back when the update fails
+	current, err := u.Records(ctx)
+	require.NoError(t, err)
+	err = u.ApplyChanges(ctx, &plan.Changes{Delete: current, UpdateOld: current})
+	require.ErrorIs(t, err, ErrUnpairedUpdate)
+
+	// dry runs change nothing
+	dryRun := New(server.Client(), cfg, GetTestLogger(), WithDryRun(func(context.Context, PlannedRequest) {}))
+	require.NoError(t, dryRun.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("other.example.domain", endpoint.RecordTypeA, "10.0.0.2")},
+	}))
+
+	entries, err := audit.Read(path, audit.Query{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	applied := entries[0]
+	assert.Equal(t, now.UTC(), applied.Time)
+	assert.Equal(t, source, applied.Source)
+	assert.Equal(t, config.PrimaryInstance, applied.Instance)
+	assert.Equal(t, auditApply, applied.Action)
+	assert.Equal(t, []string{"host.example.domain"}, applied.Hosts)
+	assert.Equal(t, audit.OutcomeSuccess, applied.Outcome)
+	assert.Empty(t, applied.Error)
+	require.NotNil(t, applied.Plan)
+	assert.Len(t, applied.Plan.Create, 2)
+	require.Len(t, applied.Changes, 1)
+	uuid := applied.Changes[0].UUID
+	assert.NotEmpty(t, uuid)
+	assert.Equal(t, audit.Change{
+		Kind:       string(mutationCreate),
+		UUID:       uuid,
+		DNSName:    "Host.example.domain",
+		RecordType: endpoint.RecordTypeA,
+		Target:     "10.0.0.1",
+	}, applied.Changes[0])
+
+	// the rollback re-creates the deleted row under a new uuid, which is logged too
+	failed := entries[1]
+	assert.Equal(t, audit.OutcomeFailure, failed.Outcome)
+	assert.Contains(t, failed.Error, ErrRolledBack.Error())
+	require.Len(t, failed.Changes, 2)
+	assert.Equal(t, string(mutationDelete), failed.Changes[0].Kind)
+	assert.Equal(t, uuid, failed.Changes[0].UUID)
+	assert.False(t, failed.Changes[0].Rollback)
+	restored := failed.Changes[1]
+	assert.Equal(t, string(mutationCreate), restored.Kind)
+	assert.True(t, restored.Rollback)
+	assert.NotEqual(t, uuid, restored.UUID)
+	_, rows, err := u.read(ctx)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, restored.UUID, rows[0].UUID)
+}
+
+func TestUnbound_auditedMigrateAndDomains(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(testhelpers.NewFakeOpnsense())
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	ctx := audit.WithSource(context.Background(), audit.Source{Kind: audit.SourceCLI})
+	cfg := config.Config{
+		Opnsense: config.Opnsense{BaseURL: server.URL, Creds: "foo:bar"},
+		Audit:    config.Audit{Path: path},
+	}
+	u := New(server.Client(), cfg, GetTestLogger(), WithClock(testClock))
+
+	legacy := appendToDescription("aGVyaXRhZ2U9ZXh0ZXJuYWwtZG5zLGV4dGVybmFsLWRucy9vd25lcj1kZWZhdWx0")
+	uuid, err := u.addRecord(ctx, Record{
+		Hostname: "app", Domain: "example.domain", Rr: endpoint.RecordTypeA, Server: "10.0.0.1", Enabled: "1", Description: legacy,
+	})
+	require.NoError(t, err)
+
+	migrated, err := u.MigrateDescriptions(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, migrated)
+
+	require.NoError(t, u.ApplyDomainChanges(ctx, DomainChanges{
+		Create: []DomainOverride{{Domain: "Lab.example", Server: "10.0.0.53"}},
+	}))
+
+	entries, err := audit.Read(path, audit.Query{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, auditMigrate, entries[0].Action)
+	assert.Equal(t, audit.OutcomeSuccess, entries[0].Outcome)
+	assert.Equal(t, []string{"app.example.domain"}, entries[0].Hosts)
+	assert.Equal(t, []audit.Change{{
+		Kind:       string(mutationUpdate),
+		UUID:       uuid,
+		DNSName:    "app.example.domain",
+		RecordType: endpoint.RecordTypeA,
+		Target:     "10.0.0.1",
+	}}, entries[0].Changes)
+
+	assert.Equal(t, auditDomains, entries[1].Action)
+	assert.Equal(t, audit.OutcomeSuccess, entries[1].Outcome)
+	assert.Equal(t, []string{"lab.example"}, entries[1].Hosts)
+	assert.Equal(t, []audit.Change{{
+		Kind:    string(mutationCreate),
+		DNSName: "Lab.example",
+		Target:  "10.0.0.53",
+	}}, entries[1].Changes)
+}
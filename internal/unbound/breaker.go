@@ -0,0 +1,96 @@
+package unbound
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MrUsefull/boundation/internal/config"
+)
+
+// breaker fails requests fast once opnsense has failed too many times in a row, rather than
+// letting every request wait out its own retries. After the cooldown a single request is let
+// through to probe opnsense, closing the breaker again if it succeeds.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(cfg config.Breaker) *breaker {
+	return &breaker{
+		threshold: cfg.Failures,
+		cooldown:  cfg.Cooldown,
+		now:       time.Now,
+	}
+}
+
+// allow returns ErrCircuitOpen while the breaker is open. Every allowed request must be
+// followed by a call to record, or to release when it was cancelled.
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.tripped() {
+		return nil
+	}
+
+	if b.probing || b.now().Sub(b.openedAt) < b.cooldown {
+		return fmt.Errorf("opened at %v: %w", b.openedAt.Format(time.RFC3339), ErrCircuitOpen)
+	}
+
+	b.probing = true
+	return nil
+}
+
+// record the outcome of an allowed request. Requests that got no response and transient
+// responses count as failures, any other response shows opnsense is up.
+func (b *breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.tripped() {
+		b.openedAt = b.now()
+	}
+}
+
+// release ends an allowed request that was cancelled, which says nothing about opnsense. A
+// cancelled probe lets the next request probe instead.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// state is CircuitClosed, CircuitOpen, or CircuitHalfOpen once the cooldown has passed and a
+// probe request may be let through.
+func (b *breaker) state() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case !b.tripped():
+		return CircuitClosed
+	case b.probing || b.now().Sub(b.openedAt) >= b.cooldown:
+		return CircuitHalfOpen
+	default:
+		return CircuitOpen
+	}
+}
+
+func (b *breaker) tripped() bool {
+	return b.threshold > 0 && b.failures >= b.threshold
+}
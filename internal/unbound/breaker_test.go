@@ -0,0 +1,56 @@
+package unbound
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_breaker(t *testing.T) {
+	t.Parallel()
+
+	now := testClock()
+	subject := newBreaker(config.Breaker{Failures: 2, Cooldown: time.Minute})
+	subject.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, subject.allow())
+		subject.record(true)
+	}
+	assert.ErrorIs(t, subject.allow(), ErrCircuitOpen)
+	assert.Equal(t, CircuitOpen, subject.state())
+
+	// a single probe is let through after the cooldown
+	now = now.Add(time.Minute)
+	require.NoError(t, subject.allow())
+	assert.ErrorIs(t, subject.allow(), ErrCircuitOpen)
+
+	// a cancelled probe lets the next request probe
+	subject.release()
+	require.NoError(t, subject.allow())
+	assert.ErrorIs(t, subject.allow(), ErrCircuitOpen)
+
+	// a failed probe opens the breaker for another cooldown
+	subject.record(true)
+	assert.ErrorIs(t, subject.allow(), ErrCircuitOpen)
+
+	now = now.Add(time.Minute)
+	require.NoError(t, subject.allow())
+	subject.record(false)
+	assert.Equal(t, CircuitClosed, subject.state())
+	assert.NoError(t, subject.allow())
+}
+
+func Test_breaker_disabled(t *testing.T) {
+	t.Parallel()
+
+	subject := newBreaker(config.Breaker{})
+	for i := 0; i < 10; i++ {
+		require.NoError(t, subject.allow())
+		subject.record(true)
+	}
+	assert.Equal(t, CircuitClosed, subject.state())
+}
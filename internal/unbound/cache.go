@@ -1,32 +1,63 @@
 package unbound
 
 import (
-	"encoding/base64"
-	"fmt"
 	"log/slog"
+	"maps"
 	"strings"
+	"sync"
 
 	"sigs.k8s.io/external-dns/endpoint"
 	"sigs.k8s.io/external-dns/plan"
 )
 
+// cache is safe for concurrent use. cacheFromSlice, removeRecords and putRows expect mu to be held.
 type cache struct {
-	heritages map[string]string
+	mu sync.Mutex
+	// heritages are the ownership TXT values by dns name and record type. Untyped TXT names
+	// have an empty record type.
+	heritages map[endpoint.EndpointKey]string
+	// rows are the opnsense rows seen by the last read, keyed by uuid.
+	// They are kept so a mutation can be undone with the row exactly as it was.
+	rows map[string]Record
 
+	names  txtNames
 	logger *slog.Logger
 }
 
-func newCache(logger *slog.Logger) *cache {
+func newCache(logger *slog.Logger, names txtNames) *cache {
 	return &cache{
 		logger:    logger,
-		heritages: make(map[string]string),
+		names:     names,
+		heritages: make(map[endpoint.EndpointKey]string),
+		rows:      make(map[string]Record),
 	}
 }
 
+// snapshot copies the heritages so they can be restored if a plan is rolled back.
+func (c *cache) snapshot() map[endpoint.EndpointKey]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return maps.Clone(c.heritages)
+}
+
+func (c *cache) restore(heritages map[endpoint.EndpointKey]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.heritages = heritages
+}
+
 func (c *cache) updateFromPlan(changes *plan.Changes) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.removeRecords(changes.Delete)
 
-	fromCreate := c.cacheFromSlice(append(changes.Create, changes.UpdateNew...))
+	// copy, appending to Create could write into the backing array of the caller's plan
+	created := make([]*endpoint.Endpoint, 0, len(changes.Create)+len(changes.UpdateNew))
+	created = append(append(created, changes.Create...), changes.UpdateNew...)
+	fromCreate := c.cacheFromSlice(created)
 	slog.Debug("updating cache", slog.Any("fromCreate", fromCreate))
 	for k, v := range fromCreate {
 		c.heritages[k] = v
@@ -35,40 +66,146 @@ func (c *cache) updateFromPlan(changes *plan.Changes) {
 	slog.Debug("current cache", slog.Any("cache", c.heritages), slog.Any("plan", changes))
 }
 
-func (c *cache) updateReadRecords(read []*endpoint.Endpoint) {
+func (c *cache) updateReadRecords(read []*endpoint.Endpoint, rows []Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.heritages = c.cacheFromSlice(read)
+
+	c.rows = make(map[string]Record, len(rows))
+	c.putRows(rows)
+}
+
+// mergeReadRecords adds a partial read to the cache, keeping what was already known.
+func (c *cache) mergeReadRecords(read []*endpoint.Endpoint, rows []Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	maps.Copy(c.heritages, c.cacheFromSlice(read))
+	c.putRows(rows)
+}
+
+func (c *cache) row(uuid string) (Record, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	row, ok := c.rows[uuid]
+	return row, ok
+}
+
+func (c *cache) putRow(row Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.putRows([]Record{row})
+}
+
+func (c *cache) putRows(rows []Record) {
+	for _, row := range rows {
+		if row.UUID != "" {
+			c.rows[row.UUID] = row
+		}
+	}
+}
+
+func (c *cache) removeRow(uuid string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.rows, uuid)
+}
+
+// size is the number of rows held.
+func (c *cache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.rows)
+}
+
+// overrideUUID returns the uuid of an override row named dnsName, for attaching aliases.
+// When the name has several rows the lowest uuid is used, so the choice is stable. Live rows
+// are preferred over soft deleted ones.
+func (c *cache) overrideUUID(dnsName string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	found, foundDeleted := "", true
+	for uuid, row := range c.rows {
+		if !addressType(row.RecordType()) || !strings.EqualFold(row.DNSName(), dnsName) {
+			continue
+		}
+		deleted := softDeleted(row)
+		if found == "" || (foundDeleted && !deleted) || (foundDeleted == deleted && uuid < found) {
+			found, foundDeleted = uuid, deleted
+		}
+	}
+	return found, found != ""
 }
 
-func (c *cache) cacheFromSlice(in []*endpoint.Endpoint) map[string]string {
-	out := make(map[string]string)
+// softDeletedRow returns a soft deleted row with the name, type and target of record, so it can be
+// enabled instead of creating a duplicate. The lowest uuid is used when there are several.
+func (c *cache) softDeletedRow(record Record) (Record, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var found Record
+	for uuid, row := range c.rows {
+		if !softDeleted(row) ||
+			!strings.EqualFold(row.DNSName(), record.DNSName()) ||
+			row.RecordType() != record.RecordType() ||
+			row.Target() != record.Target() {
+			continue
+		}
+		if found.UUID == "" || uuid < found.UUID {
+			found = row
+		}
+	}
+	if found.UUID == "" {
+		return Record{}, false
+	}
+	found.Rr = found.RecordType()
+	return found, true
+}
+
+func (c *cache) cacheFromSlice(in []*endpoint.Endpoint) map[endpoint.EndpointKey]string {
+	out := make(map[endpoint.EndpointKey]string)
 	for _, create := range in {
-		if create.RecordType == endpoint.RecordTypeTXT {
-			out[create.DNSName] = create.Targets.String()
-			if strings.HasPrefix(create.DNSName, "a-") {
-				// this could be a case of a-service.foo.com being a real dns entry
-				// or it could be externaldns prepending a- to the record.
-				// Hard to tell which, so give our cache both
-				name := strings.Replace(create.DNSName, "a-", "", 1)
-				out[name] = create.Targets.String()
-			}
+		if create.RecordType != endpoint.RecordTypeTXT {
+			continue
 		}
+
+		key := c.names.endpointKey(create.DNSName)
+		if key.DNSName == "" {
+			continue
+		}
+		out[key] = create.Targets.String()
 	}
 	return out
 }
 
 func (c *cache) removeRecords(toDel []*endpoint.Endpoint) {
 	for _, del := range toDel {
-		if supportedType(del.RecordType) {
-			delete(c.heritages, del.DNSName)
+		if managedType(del.RecordType) {
+			name := c.names.ownerName(del.DNSName)
+			delete(c.heritages, endpoint.EndpointKey{DNSName: name, RecordType: del.RecordType})
+			delete(c.heritages, endpoint.EndpointKey{DNSName: name})
 		}
 	}
 }
 
-func (c *cache) createDescription(dnsName string) string {
-	encoded := base64.StdEncoding.EncodeToString([]byte(c.heritages[dnsName]))
-	return appendToDescription(encoded)
-}
+// heritage is the ownership TXT value planned for dnsName, if any. Like external-dns, the typed
+// TXT name is preferred and the untyped one is used for every type but AAAA.
+func (c *cache) heritage(dnsName string, recordType string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-func appendToDescription(toAppend string) string {
-	return fmt.Sprintf("%v %v", DescriptionPrefix, toAppend)
+	name := c.names.ownerName(dnsName)
+	if heritage, ok := c.heritages[endpoint.EndpointKey{DNSName: name, RecordType: recordType}]; ok {
+		return heritage
+	}
+	if recordType == endpoint.RecordTypeAAAA {
+		return ""
+	}
+	return c.heritages[endpoint.EndpointKey{DNSName: name}]
 }
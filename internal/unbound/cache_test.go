@@ -4,6 +4,7 @@ import (
 	"log/slog"
 	"testing"
 
+	"github.com/MrUsefull/boundation/internal/config"
 	"github.com/stretchr/testify/assert"
 	"sigs.k8s.io/external-dns/endpoint"
 	"sigs.k8s.io/external-dns/plan"
@@ -11,10 +12,12 @@ import (
 
 func Test_cache_updateFromPlan(t *testing.T) {
 	t.Parallel()
+	heritage := "heritage=external-dns,external-dns/owner=default,external-dns/resource=ingress/jellybelly/jellybelly"
 	tests := []struct {
 		name      string
+		txt       config.TXT
 		changes   *plan.Changes
-		wantState map[string]string
+		wantState map[endpoint.EndpointKey]string
 	}{
 		{
 			name: "happy path",
@@ -23,12 +26,12 @@ func Test_cache_updateFromPlan(t *testing.T) {
 					{
 						DNSName:    "a-foo.example.domain",
 						RecordType: endpoint.RecordTypeTXT,
-						Targets:    endpoint.NewTargets("heritage=external-dns,external-dns/owner=default,external-dns/resource=ingress/jellybelly/jellybelly"), //nolint:lll
+						Targets:    endpoint.NewTargets(heritage),
 					},
 					{
 						DNSName:    "foo.example.domain",
 						RecordType: endpoint.RecordTypeTXT,
-						Targets:    endpoint.NewTargets("heritage=external-dns,external-dns/owner=default,external-dns/resource=ingress/jellybelly/jellybelly"), //nolint:lll
+						Targets:    endpoint.NewTargets(heritage),
 					},
 					{
 						DNSName:    "foo.example.domain",
@@ -37,9 +40,30 @@ func Test_cache_updateFromPlan(t *testing.T) {
 					},
 				},
 			},
-			wantState: map[string]string{
-				"foo.example.domain":   "heritage=external-dns,external-dns/owner=default,external-dns/resource=ingress/jellybelly/jellybelly", //nolint:lll
-				"a-foo.example.domain": "heritage=external-dns,external-dns/owner=default,external-dns/resource=ingress/jellybelly/jellybelly", //nolint:lll
+			wantState: map[endpoint.EndpointKey]string{
+				{DNSName: "foo.example.domain"}:                                   heritage,
+				{DNSName: "foo.example.domain", RecordType: endpoint.RecordTypeA}: heritage,
+			},
+		},
+		{
+			name: "templated prefix",
+			txt:  config.TXT{Prefix: "%{record_type}-owner."},
+			changes: &plan.Changes{
+				Create: []*endpoint.Endpoint{
+					{
+						DNSName:    "aaaa-owner.foo.example.domain",
+						RecordType: endpoint.RecordTypeTXT,
+						Targets:    endpoint.NewTargets(heritage),
+					},
+					{
+						DNSName:    "foo.example.domain",
+						RecordType: endpoint.RecordTypeTXT,
+						Targets:    endpoint.NewTargets("unrelated"),
+					},
+				},
+			},
+			wantState: map[endpoint.EndpointKey]string{
+				{DNSName: "foo.example.domain", RecordType: endpoint.RecordTypeAAAA}: heritage,
 			},
 		},
 	}
@@ -47,9 +71,44 @@ func Test_cache_updateFromPlan(t *testing.T) {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			c := newCache(slog.Default())
+			c := newCache(slog.Default(), newTXTNames(tt.txt))
 			c.updateFromPlan(tt.changes)
 			assert.Equal(t, tt.wantState, c.heritages)
 		})
 	}
 }
+
+func Test_cache_heritage(t *testing.T) {
+	t.Parallel()
+	c := newCache(slog.Default(), txtNames{})
+	c.heritages[endpoint.EndpointKey{DNSName: "foo.example.domain"}] = "untyped"
+	c.heritages[endpoint.EndpointKey{DNSName: "foo.example.domain", RecordType: endpoint.RecordTypeCNAME}] = "typed"
+
+	assert.Equal(t, "typed", c.heritage("foo.example.domain", endpoint.RecordTypeCNAME))
+	assert.Equal(t, "untyped", c.heritage("foo.example.domain", endpoint.RecordTypeA))
+	assert.Empty(t, c.heritage("foo.example.domain", endpoint.RecordTypeAAAA), "AAAA never uses the untyped name")
+
+	c.removeRecords([]*endpoint.Endpoint{endpoint.NewEndpoint("foo.example.domain", endpoint.RecordTypeA, "1.2.3.4")})
+	assert.Empty(t, c.heritage("foo.example.domain", endpoint.RecordTypeA))
+}
+
+func Test_cache_snapshotRestore(t *testing.T) {
+	t.Parallel()
+	c := newCache(slog.Default(), txtNames{})
+	c.heritages[endpoint.EndpointKey{DNSName: "foo.example.domain"}] = "heritage=external-dns"
+
+	snapshot := c.snapshot()
+	c.updateFromPlan(&plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{
+				DNSName:    "bar.example.domain",
+				RecordType: endpoint.RecordTypeTXT,
+				Targets:    endpoint.NewTargets("heritage=external-dns"),
+			},
+		},
+	})
+	assert.Len(t, c.heritages, 2)
+
+	c.restore(snapshot)
+	assert.Equal(t, map[endpoint.EndpointKey]string{{DNSName: "foo.example.domain"}: "heritage=external-dns"}, c.heritages)
+}
@@ -0,0 +1,86 @@
+package unbound
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/MrUsefull/boundation/internal/config"
+)
+
+// ErrInvalidCA is returned when the CA file holds no PEM certificates.
+var ErrInvalidCA = errors.New("no certificates found in ca file")
+
+// NewClient builds the http client used to reach opnsense from cfg. The transport otherwise
+// matches http.DefaultTransport, so proxies still come from the environment unless cfg sets one.
+func NewClient(cfg config.Opnsense, logger *slog.Logger) (*http.Client, error) {
+	tlsConfig, err := clientTLS(cfg.TLS, logger)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		transport = &http.Transport{}
+	}
+	transport = transport.Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	if cfg.Proxy != "" {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+	}, nil
+}
+
+func clientTLS(cfg config.TLS, logger *slog.Logger) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cfg.InsecureSkipVerify {
+		logger.Warn("!!! TLS VERIFICATION OF OPNSENSE IS DISABLED !!! " +
+			"Anyone able to intercept the connection can read the api credentials and forge responses. " +
+			"Set opnsense.tls.caFile to trust a private CA instead.")
+		tlsConfig.InsecureSkipVerify = true //nolint:gosec // explicitly requested by the operator
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			logger.Warn("system CAs unavailable, trusting the ca file only", slog.Any("error", err))
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%v: %w", cfg.CAFile, ErrInvalidCA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
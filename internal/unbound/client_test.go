@@ -0,0 +1,137 @@
+package unbound
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClient(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	caFile := path.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	notPEM := path.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0600))
+
+	tests := []struct {
+		name       string
+		cfg        config.Opnsense
+		wantErr    error
+		wantDoFail bool
+	}{
+		{
+			name:       "untrusted certificate",
+			wantDoFail: true,
+		},
+		{
+			name: "trusted ca file",
+			cfg:  config.Opnsense{TLS: config.TLS{CAFile: caFile}},
+		},
+		{
+			name: "insecure skip verify",
+			cfg:  config.Opnsense{TLS: config.TLS{InsecureSkipVerify: true}},
+		},
+		{
+			name:    "ca file without certificates",
+			cfg:     config.Opnsense{TLS: config.TLS{CAFile: notPEM}},
+			wantErr: ErrInvalidCA,
+		},
+		{
+			name:    "missing ca file",
+			cfg:     config.Opnsense{TLS: config.TLS{CAFile: path.Join(t.TempDir(), "missing.pem")}},
+			wantErr: os.ErrNotExist,
+		},
+		{
+			name:    "missing client certificate",
+			cfg:     config.Opnsense{TLS: config.TLS{CertFile: "missing.pem", KeyFile: "missing-key.pem"}},
+			wantErr: os.ErrNotExist,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			client, err := NewClient(tt.cfg, GetTestLogger())
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+			require.NoError(t, err)
+			resp, err := client.Do(req)
+			if tt.wantDoFail {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+func TestNewClient_proxy(t *testing.T) {
+	t.Parallel()
+
+	proxied := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.URL.String()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	client, err := NewClient(config.Opnsense{HTTP: config.HTTP{Proxy: proxy.URL}}, GetTestLogger())
+	require.NoError(t, err)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://opnsense.invalid/api/unbound", nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, "http://opnsense.invalid/api/unbound", <-proxied)
+}
+
+func TestNewClient_timeout(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := NewClient(config.Opnsense{HTTP: config.HTTP{Timeout: 10 * time.Millisecond}}, GetTestLogger())
+	require.NoError(t, err)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	// timeouts are transient, so the request layer retries them
+	assert.True(t, transientError(err))
+}
@@ -0,0 +1,200 @@
+package unbound
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// DomainOverride forwards every query for Domain to the DNS server at Server.
+type DomainOverride struct {
+	UUID        string `json:"uuid,omitempty"`
+	Enabled     string `json:"enabled"`
+	Domain      string `json:"domain"`
+	Server      string `json:"server"`
+	Description string `json:"description"`
+}
+
+type AddDomainOverrideRequest struct {
+	Domain DomainOverride `json:"domain"`
+}
+
+// DomainUpdate forwards an existing domain override to another server.
+type DomainUpdate struct {
+	// Override is the domain override as it is in opnsense.
+	Override DomainOverride
+	Server   string
+}
+
+// DomainChanges are the domain overrides to create, update and delete in a single apply.
+type DomainChanges struct {
+	Create []DomainOverride
+	Update []DomainUpdate
+	Delete []DomainOverride
+}
+
+// HasChanges reports whether there is anything to apply.
+func (c DomainChanges) HasChanges() bool {
+	return len(c.Create) > 0 || len(c.Update) > 0 || len(c.Delete) > 0
+}
+
+// domains returns the domain of every change.
+func (c DomainChanges) domains() []string {
+	out := make([]string, 0, len(c.Create)+len(c.Update)+len(c.Delete))
+	for _, domain := range c.Create {
+		out = append(out, domain.Domain)
+	}
+	for _, update := range c.Update {
+		out = append(out, update.Override.Domain)
+	}
+	for _, domain := range c.Delete {
+		out = append(out, domain.Domain)
+	}
+	return out
+}
+
+// DomainOverrides returns every domain override in opnsense unbound.
+func (u Unbound) DomainOverrides(ctx context.Context) ([]DomainOverride, error) {
+	domains, err := searchAll[DomainOverride](ctx, u, SearchDomainsEndpoint, "")
+	if err != nil {
+		return nil, fmt.Errorf("domain overrides: %w", err)
+	}
+
+	return domains, nil
+}
+
+// ApplyDomainChanges updates, creates and then deletes domain overrides, and reconfigures unbound.
+// Overrides are created before any are deleted so a domain is never left unforwarded, and a
+// failed apply is rolled back like ApplyChanges. Every apply is audited.
+func (u Unbound) ApplyDomainChanges(ctx context.Context, changes DomainChanges) error {
+	if !changes.HasChanges() {
+		u.logger.DebugContext(ctx, "no domain changes to apply")
+
+		return nil
+	}
+
+	leave, err := u.queue.enter(ctx)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	saved := u.checkpoint()
+	tx := newJournal()
+	if err := u.applyDomainChanges(ctx, tx, changes); err != nil {
+		err = u.rollback(ctx, tx, saved, err)
+		u.audited(ctx, auditDomains, nil, changes.domains(), tx.mutations, err)
+
+		return err
+	}
+	u.audited(ctx, auditDomains, nil, changes.domains(), tx.mutations, nil)
+
+	return nil
+}
+
+func (u Unbound) applyDomainChanges(ctx context.Context, tx *journal, changes DomainChanges) error {
+	for _, update := range changes.Update {
+		domain := update.Override
+		domain.Server = update.Server
+		if err := u.setDomain(ctx, domain); err != nil {
+			return fmt.Errorf("update domain %q: %w", domain.Domain, err)
+		}
+		tx.domainUpdated(update.Override)
+	}
+
+	for _, domain := range changes.Create {
+		uuid, err := u.addDomain(ctx, domain)
+		if err != nil {
+			return fmt.Errorf("create domain %q: %w", domain.Domain, err)
+		}
+		domain.UUID = uuid
+		tx.domainCreated(domain)
+	}
+
+	for _, domain := range changes.Delete {
+		if err := u.deleteUUID(ctx, DelDomainEndpoint, domain.UUID, domain.Domain); err != nil {
+			return fmt.Errorf("delete domain %q: %w", domain.Domain, err)
+		}
+		tx.domainDeleted(domain)
+	}
+
+	if err := u.reconfigure(ctx); err != nil {
+		return fmt.Errorf("apply domain changes reconfigure endpoint: %w", err)
+	}
+
+	return nil
+}
+
+// addDomain creates domain and returns the uuid opnsense gave it.
+func (u Unbound) addDomain(ctx context.Context, domain DomainOverride) (string, error) {
+	domain.UUID = ""
+	if domain.Enabled == "" {
+		domain.Enabled = "1"
+	}
+
+	data, err := json.Marshal(&AddDomainOverrideRequest{Domain: domain})
+	if err != nil {
+		return "", fmt.Errorf("marshal: %w", err)
+	}
+
+	u.logger.InfoContext(ctx, "creating domain override", slog.String("data", string(data)))
+
+	var uuid string
+	err = u.retry(ctx, func(ctx context.Context, attempt int) error {
+		// creates are not idempotent, a failed attempt may still have added the override
+		if attempt > 1 {
+			saved, found, err := u.savedDomain(ctx, domain)
+			if err != nil || found {
+				uuid = saved
+				return err
+			}
+		}
+
+		result, err := u.saveRecord(ctx, AddDomainEndpoint, data, domain.Domain)
+		uuid = result.UUID
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return uuid, nil
+}
+
+// setDomain saves domain over the domain override with the same uuid.
+func (u Unbound) setDomain(ctx context.Context, domain DomainOverride) error {
+	uuid := domain.UUID
+	domain.UUID = ""
+
+	data, err := json.Marshal(&AddDomainOverrideRequest{Domain: domain})
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	u.logger.InfoContext(ctx, "updating domain override", slog.String("uuid", uuid), slog.String("data", string(data)))
+
+	return u.retry(ctx, func(ctx context.Context, _ int) error {
+		_, err := u.saveRecord(ctx, path.Join(SetDomainEndpoint, uuid), data, domain.Domain)
+		return err
+	})
+}
+
+// savedDomain returns the uuid of the domain override matching domain, if one exists.
+func (u Unbound) savedDomain(ctx context.Context, domain DomainOverride) (string, bool, error) {
+	domains, err := searchAll[DomainOverride](ctx, u, SearchDomainsEndpoint, domain.Domain)
+	if err != nil {
+		return "", false, fmt.Errorf("find created domain: %w", err)
+	}
+
+	for _, existing := range domains {
+		if strings.EqualFold(existing.Domain, domain.Domain) &&
+			existing.Server == domain.Server &&
+			existing.Description == domain.Description {
+			return existing.UUID, true, nil
+		}
+	}
+	return "", false, nil
+}
@@ -0,0 +1,167 @@
+package unbound
+
+import (
+	"context"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnbound_DomainOverrides(t *testing.T) {
+	t.Parallel()
+
+	handler, _ := testhelpers.TestHandler(t, []string{
+		`{"rows": [{"uuid": "uuid-1", "enabled": "1", "domain": "lab.example", "server": "10.0.0.53", "description": "lab"}]}`,
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	cfg := config.Config{Opnsense: config.Opnsense{BaseURL: server.URL, Creds: "foo:bar"}}
+	u := New(server.Client(), cfg, GetTestLogger())
+
+	got, err := u.DomainOverrides(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []DomainOverride{
+		{UUID: "uuid-1", Enabled: "1", Domain: "lab.example", Server: "10.0.0.53", Description: "lab"},
+	}, got)
+}
+
+func TestUnbound_ApplyDomainChanges(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		changes      DomainChanges
+		serverResps  []string
+		wantErr      error
+		wantRequests map[string][]string
+	}{
+		{
+			name:         "no changes",
+			wantRequests: map[string][]string{},
+		},
+		{
+			name: "update in place",
+			changes: DomainChanges{
+				Update: []DomainUpdate{{
+					Override: DomainOverride{UUID: "uuid-1", Enabled: "1", Domain: "lab.example", Server: "10.0.0.1", Description: "lab"},
+					Server:   "10.0.0.53",
+				}},
+			},
+			serverResps: []string{
+				testhelpers.CreateSuccessServResp,
+				testhelpers.ReconfigureResp,
+			},
+			wantRequests: map[string][]string{
+				path.Join(SetDomainEndpoint, "uuid-1"): {
+					`{"domain":{"enabled":"1","domain":"lab.example","server":"10.0.0.53","description":"lab"}}`,
+				},
+				ApplyChangesEndpoint: {`"{}"`},
+			},
+		},
+		{
+			name: "create and delete",
+			changes: DomainChanges{
+				Create: []DomainOverride{{Domain: "lab.example", Server: "10.0.0.53"}},
+				Delete: []DomainOverride{{UUID: "uuid-1", Domain: "lab.example", Server: "10.0.0.1"}},
+			},
+			serverResps: []string{
+				testhelpers.CreateSuccessServResp,
+				testhelpers.DeleteSuccessServResp,
+				testhelpers.ReconfigureResp,
+			},
+			wantRequests: map[string][]string{
+				path.Join(DelDomainEndpoint, "uuid-1"): {`"{}"`},
+				AddDomainEndpoint: {
+					`{"domain":{"enabled":"1","domain":"lab.example","server":"10.0.0.53","description":""}}`,
+				},
+				ApplyChangesEndpoint: {`"{}"`},
+			},
+		},
+		{
+			name: "create failure",
+			changes: DomainChanges{
+				Create: []DomainOverride{{Domain: "lab.example", Server: "10.0.0.53"}},
+			},
+			serverResps: []string{
+				testhelpers.CreateFailServeResp,
+			},
+			wantRequests: map[string][]string{
+				AddDomainEndpoint: {
+					`{"domain":{"enabled":"1","domain":"lab.example","server":"10.0.0.53","description":""}}`,
+				},
+			},
+			wantErr: ErrRequestFailed,
+		},
+		{
+			name: "delete failure removes the created override",
+			changes: DomainChanges{
+				Create: []DomainOverride{{Domain: "lab.example", Server: "10.0.0.53"}},
+				Delete: []DomainOverride{{UUID: "uuid-1", Domain: "lab.example", Server: "10.0.0.1"}},
+			},
+			serverResps: []string{
+				`{"result":"saved","uuid":"uuid-2"}`,
+				testhelpers.DeleteFailServeResp,
+				testhelpers.DeleteSuccessServResp,
+				testhelpers.ReconfigureResp,
+			},
+			wantRequests: map[string][]string{
+				AddDomainEndpoint: {
+					`{"domain":{"enabled":"1","domain":"lab.example","server":"10.0.0.53","description":""}}`,
+				},
+				path.Join(DelDomainEndpoint, "uuid-1"): {`"{}"`},
+				path.Join(DelDomainEndpoint, "uuid-2"): {`"{}"`},
+				ApplyChangesEndpoint:                   {`"{}"`},
+			},
+			wantErr: ErrRolledBack,
+		},
+		{
+			name: "create failure restores the updated override",
+			changes: DomainChanges{
+				Create: []DomainOverride{{Domain: "other.example", Server: "10.0.0.54"}},
+				Update: []DomainUpdate{{
+					Override: DomainOverride{UUID: "uuid-1", Enabled: "1", Domain: "lab.example", Server: "10.0.0.1"},
+					Server:   "10.0.0.53",
+				}},
+			},
+			serverResps: []string{
+				testhelpers.CreateSuccessServResp,
+				testhelpers.CreateFailServeResp,
+				testhelpers.CreateSuccessServResp,
+				testhelpers.ReconfigureResp,
+			},
+			wantRequests: map[string][]string{
+				path.Join(SetDomainEndpoint, "uuid-1"): {
+					`{"domain":{"enabled":"1","domain":"lab.example","server":"10.0.0.53","description":""}}`,
+					`{"domain":{"enabled":"1","domain":"lab.example","server":"10.0.0.1","description":""}}`,
+				},
+				AddDomainEndpoint: {
+					`{"domain":{"enabled":"1","domain":"other.example","server":"10.0.0.54","description":""}}`,
+				},
+				ApplyChangesEndpoint: {`"{}"`},
+			},
+			wantErr: ErrRolledBack,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler, gotRequests := testhelpers.TestHandler(t, tt.serverResps)
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			cfg := config.Config{Opnsense: config.Opnsense{BaseURL: server.URL, Creds: "foo:bar"}}
+			u := New(server.Client(), cfg, GetTestLogger())
+
+			assert.ErrorIs(t, u.ApplyDomainChanges(context.Background(), tt.changes), tt.wantErr)
+			assert.Equal(t, tt.wantRequests, gotRequests)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package unbound
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// DryRunUUIDPrefix starts the uuids handed out for rows created in dry-run mode.
+const DryRunUUIDPrefix = "dry-run-"
+
+// PlannedRequest is an opnsense api call computed in dry-run mode instead of being sent.
+type PlannedRequest struct {
+	Method string
+	// Path is the api path, without the base url.
+	Path string
+	Body json.RawMessage
+}
+
+func (p PlannedRequest) String() string {
+	return fmt.Sprintf("%s %s %s", p.Method, p.Path, p.Body)
+}
+
+// WithDryRun stops every api call that would change opnsense from being sent. Each one is
+// passed to report instead and answered as if it succeeded. Searches are still sent, so plans
+// are computed against the real records.
+func WithDryRun(report func(ctx context.Context, request PlannedRequest)) Opts {
+	return func(u *Unbound) {
+		u.dryRun = &dryRun{report: report}
+	}
+}
+
+// dryRun answers the mutating calls of an Unbound in dry-run mode. It is shared by every copy.
+type dryRun struct {
+	report func(ctx context.Context, request PlannedRequest)
+	// rows counts the rows created, to give each a distinct uuid.
+	rows atomic.Int64
+}
+
+// logPlanned is the dry-run report used when dry-run is enabled by the config.
+func (u Unbound) logPlanned(ctx context.Context, request PlannedRequest) {
+	u.logger.InfoContext(ctx, "dry run, not sending request",
+		slog.String("method", request.Method),
+		slog.String("path", request.Path),
+		slog.String("body", string(request.Body)))
+}
+
+// respond reports the request and answers it the way opnsense answers a successful call.
+func (d *dryRun) respond(ctx context.Context, method string, apiPath string, body []byte) (*http.Response, []byte, error) {
+	planned := PlannedRequest{Method: method, Path: apiPath}
+	if len(body) > 0 {
+		planned.Body = json.RawMessage(body)
+	}
+	d.report(ctx, planned)
+
+	var result any
+	switch {
+	case apiPath == ApplyChangesEndpoint:
+		result = map[string]string{"status": "ok"}
+	case strings.HasPrefix(apiPath, ToggleOverrideEndpoint), strings.HasPrefix(apiPath, ToggleAliasEndpoint):
+		result = OperationResponse{Result: DisabledResponse}
+		if strings.HasSuffix(apiPath, "/1") {
+			result = OperationResponse{Result: EnabledResponse}
+		}
+	case strings.HasPrefix(apiPath, DelOverrideEndpoint),
+		strings.HasPrefix(apiPath, DelAliasEndpoint),
+		strings.HasPrefix(apiPath, DelDomainEndpoint):
+		result = OperationResponse{Result: DeleteOpSuccessResponse}
+	default:
+		result = OperationResponse{
+			Result: CreateOpSuccessResponse,
+			UUID:   fmt.Sprintf("%s%d", DryRunUUIDPrefix, d.rows.Add(1)),
+		}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, nil, fmt.Errorf("dry run response: %w", err)
+	}
+
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewReader(data)),
+	}, data, nil
+}
+
+// dryRunRegistry computes the descriptions of a registry without storing any ownership.
+type dryRunRegistry struct {
+	Registry
+}
+
+func (r dryRunRegistry) Own(ctx context.Context, key endpoint.EndpointKey, meta Metadata) (string, error) {
+	// the file registry writes its file, the rows only carry the metadata without ownership
+	if _, ok := r.Registry.(*FileRegistry); ok {
+		return meta.withoutOwnership().String(), nil
+	}
+	return r.Registry.Own(ctx, key, meta)
+}
+
+func (dryRunRegistry) Disown(context.Context, endpoint.EndpointKey) error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package unbound
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestWithDryRun(t *testing.T) {
+	t.Parallel()
+
+	fake := testhelpers.NewFakeOpnsense()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ctx := context.Background()
+	cfg := config.Config{
+		Opnsense: config.Opnsense{BaseURL: server.URL, Creds: "foo:bar"},
+		Registry: config.Registry{Type: FileRegistryType, Path: filepath.Join(t.TempDir(), "owners.json")},
+	}
+
+	require.NoError(t, New(server.Client(), config.Config{Opnsense: cfg.Opnsense}, GetTestLogger()).ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("old.example.domain", endpoint.RecordTypeA, "10.0.0.1")},
+	}))
+	before := len(fake.Requests())
+
+	var (
+		mu      sync.Mutex
+		planned []PlannedRequest
+	)
+	subject := New(server.Client(), cfg, GetTestLogger(), WithClock(testClock),
+		WithDryRun(func(_ context.Context, request PlannedRequest) {
+			mu.Lock()
+			defer mu.Unlock()
+			planned = append(planned, request)
+		}))
+
+	current, err := subject.Records(ctx)
+	require.NoError(t, err)
+	require.Len(t, current, 1)
+
+	owned := endpoint.NewEndpoint("new.example.domain", endpoint.RecordTypeA, "10.0.0.2")
+	owned.Labels[endpoint.OwnerLabelKey] = "default"
+	require.NoError(t, subject.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{owned},
+		Delete: current,
+	}))
+
+	assert.Equal(t, []PlannedRequest{
+		{Method: http.MethodPost, Path: DelOverrideEndpoint + "uuid-1", Body: []byte(`"{}"`)},
+		{
+			Method: http.MethodPost,
+			Path:   AddOverrideEndpoint,
+			Body:   []byte(`{"host":{"hostname":"new","domain":"example.domain","rr":"A","server":"10.0.0.2","enabled":"1","description":"extdns/1 t=A c=2024-05-01T10:00:00Z"}}`), //nolint
+		},
+		{Method: http.MethodPost, Path: ApplyChangesEndpoint, Body: []byte(`"{}"`)},
+	}, planned)
+
+	// only searches reached opnsense, and the registry file was never written
+	for _, request := range fake.Requests()[before:] {
+		assert.Contains(t, []string{SearchOverridesEndpoint, SearchAliasesEndpoint}, request)
+	}
+	assert.NoFileExists(t, cfg.Registry.Path)
+
+	got, err := subject.Records(ctx)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, "old.example.domain", got[0].DNSName)
+}
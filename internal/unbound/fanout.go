@@ -0,0 +1,446 @@
+package unbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/MrUsefull/boundation/internal/audit"
+	"github.com/MrUsefull/boundation/internal/config"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+	"sigs.k8s.io/external-dns/provider"
+)
+
+// Instance is a named opnsense node of a FanOut.
+type Instance struct {
+	Name string
+	*Unbound
+}
+
+// FanOut applies every plan to several opnsense instances, eg a CARP pair whose unbound
+// overrides are not synced, and reads records from the primary. Row uuids differ between
+// instances, so a plan is rebased onto the rows of each other instance before it is applied there.
+type FanOut struct {
+	// instances holds the primary first.
+	instances []Instance
+	logger    *slog.Logger
+	// queue serializes reads and applies across all instances.
+	queue queue
+}
+
+var _ provider.Provider = &FanOut{}
+
+// Drift is a record that differs between the primary and another instance.
+type Drift struct {
+	Instance   string
+	DNSName    string
+	RecordType string
+	// Primary and Found are the targets on the primary and on the instance. Either is empty
+	// when the record is missing there.
+	Primary endpoint.Targets
+	Found   endpoint.Targets
+}
+
+func (d Drift) String() string {
+	switch {
+	case len(d.Found) == 0:
+		return fmt.Sprintf("%s %s %s: missing, primary has %v", d.Instance, d.DNSName, d.RecordType, d.Primary)
+	case len(d.Primary) == 0:
+		return fmt.Sprintf("%s %s %s: not on primary, found %v", d.Instance, d.DNSName, d.RecordType, d.Found)
+	default:
+		return fmt.Sprintf("%s %s %s: primary has %v, found %v", d.Instance, d.DNSName, d.RecordType, d.Primary, d.Found)
+	}
+}
+
+// NewFanOut creates a FanOut over every instance in cfg.Opnsense, all reached through client.
+// The instances share a single ownership registry and audit log.
+func NewFanOut(client *http.Client, cfg config.Config, logger *slog.Logger, opts ...Opts) *FanOut {
+	registry, err := NewRegistry(cfg.Registry)
+	if err != nil {
+		logger.Error("using description registry", slog.Any("error", err))
+		registry = DescriptionRegistry{}
+	}
+	opts = append([]Opts{WithRegistry(registry), WithAuditLog(audit.New(cfg.Audit.Path))}, opts...)
+
+	all := cfg.AllInstances()
+	instances := make([]Instance, 0, len(all))
+	for _, instance := range all {
+		instanceCfg := cfg.ForInstance(instance)
+		instanceCfg.Registry = config.Registry{}
+		instanceCfg.Audit = config.Audit{}
+		u := New(client, instanceCfg, logger.With(slog.String("instance", instance.Name)), opts...)
+		u.instance = instance.Name
+		instances = append(instances, Instance{Name: instance.Name, Unbound: u})
+	}
+
+	return &FanOut{
+		instances: instances,
+		logger:    logger,
+		queue:     newQueue(),
+	}
+}
+
+// Records returns the records of the primary. Drift between the primary and the other instances
+// is logged as a warning, and never fails the read.
+func (f FanOut) Records(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	leave, err := f.queue.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	primary := f.instances[0]
+	endpoints, err := primary.Records(ctx)
+	if err != nil {
+		return nil, instanceError(primary.Name, err)
+	}
+
+	drift, err := f.drift(ctx, endpoints)
+	if err != nil {
+		f.logger.WarnContext(ctx, "unable to check opnsense instances for drift", slog.Any("error", err))
+	}
+	for _, d := range drift {
+		f.logger.WarnContext(ctx, "opnsense instance has drifted from the primary", slog.String("drift", d.String()))
+	}
+
+	return endpoints, nil
+}
+
+// Drift compares the records of every instance with the primary.
+func (f FanOut) Drift(ctx context.Context) ([]Drift, error) {
+	leave, err := f.queue.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	primary := f.instances[0]
+	endpoints, err := primary.Records(ctx)
+	if err != nil {
+		return nil, instanceError(primary.Name, err)
+	}
+
+	return f.drift(ctx, endpoints)
+}
+
+// drift returns how every other instance differs from endpoints, the records of the primary.
+// Instances that cannot be read are skipped and reported in the error.
+func (f FanOut) drift(ctx context.Context, endpoints []*endpoint.Endpoint) ([]Drift, error) {
+	var (
+		drift []Drift
+		errs  []error
+	)
+	for _, instance := range f.instances[1:] {
+		found, err := instance.Records(ctx)
+		if err != nil {
+			errs = append(errs, instanceError(instance.Name, err))
+			continue
+		}
+		drift = append(drift, compareRecords(instance.Name, endpoints, found)...)
+	}
+
+	return drift, errors.Join(errs...)
+}
+
+// ApplyChanges applies the plan to every instance, primary first. A failing instance does not
+// stop the plan being applied to the rest. Each failure names its instance, and an instance that
+// failed is rolled back on its own, so instances that succeeded keep the changes.
+func (f FanOut) ApplyChanges(ctx context.Context, changes *plan.Changes) error {
+	if !changes.HasChanges() {
+		f.logger.DebugContext(ctx, "no changes to apply")
+
+		return nil
+	}
+
+	leave, err := f.queue.enter(ctx)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	var errs []error
+	for i, instance := range f.instances {
+		instanceChanges := changes
+		// the plan was made from the primary records, and carries the primary row uuids
+		if i > 0 {
+			current, err := instance.Records(ctx)
+			if err != nil {
+				errs = append(errs, instanceError(instance.Name, fmt.Errorf("read before apply: %w", err)))
+				continue
+			}
+			instanceChanges = rebasePlan(changes, current)
+		}
+
+		if err := instance.ApplyChanges(ctx, instanceChanges); err != nil {
+			errs = append(errs, instanceError(instance.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// FindRecords looks the names up on the primary, the records every plan is made from.
+func (f FanOut) FindRecords(ctx context.Context, dnsNames ...string) ([]*endpoint.Endpoint, error) {
+	leave, err := f.queue.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	primary := f.instances[0]
+	endpoints, err := primary.FindRecords(ctx, dnsNames...)
+	if err != nil {
+		return nil, instanceError(primary.Name, err)
+	}
+
+	return endpoints, nil
+}
+
+// Enable undoes the soft delete of the names on every instance, returning the number of rows
+// enabled on the primary. A failing instance does not stop the rest.
+func (f FanOut) Enable(ctx context.Context, dnsNames ...string) (int, error) {
+	leave, err := f.queue.enter(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer leave()
+
+	enabled := 0
+	var errs []error
+	for i, instance := range f.instances {
+		n, err := instance.Enable(ctx, dnsNames...)
+		if err != nil {
+			errs = append(errs, instanceError(instance.Name, err))
+		}
+		if i == 0 {
+			enabled = n
+		}
+	}
+
+	return enabled, errors.Join(errs...)
+}
+
+// Purge deletes the expired soft deleted rows of every instance, returning the number of rows
+// deleted on the primary. A failing instance does not stop the rest.
+func (f FanOut) Purge(ctx context.Context) (int, error) {
+	leave, err := f.queue.enter(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer leave()
+
+	purged := 0
+	var errs []error
+	for i, instance := range f.instances {
+		n, err := instance.Purge(ctx)
+		if err != nil {
+			errs = append(errs, instanceError(instance.Name, err))
+		}
+		if i == 0 {
+			purged = n
+		}
+	}
+
+	return purged, errors.Join(errs...)
+}
+
+// DomainOverrides returns the domain overrides of the primary.
+func (f FanOut) DomainOverrides(ctx context.Context) ([]DomainOverride, error) {
+	leave, err := f.queue.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	primary := f.instances[0]
+	domains, err := primary.DomainOverrides(ctx)
+	if err != nil {
+		return nil, instanceError(primary.Name, err)
+	}
+
+	return domains, nil
+}
+
+// ApplyDomainChanges applies the domain changes to every instance, primary first, rebasing them
+// onto the overrides of each other instance like ApplyChanges does with plans.
+func (f FanOut) ApplyDomainChanges(ctx context.Context, changes DomainChanges) error {
+	if !changes.HasChanges() {
+		f.logger.DebugContext(ctx, "no domain changes to apply")
+
+		return nil
+	}
+
+	leave, err := f.queue.enter(ctx)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	var errs []error
+	for i, instance := range f.instances {
+		instanceChanges := changes
+		if i > 0 {
+			current, err := instance.DomainOverrides(ctx)
+			if err != nil {
+				errs = append(errs, instanceError(instance.Name, fmt.Errorf("read before apply: %w", err)))
+				continue
+			}
+			instanceChanges = rebaseDomainChanges(changes, current)
+		}
+
+		if err := instance.ApplyDomainChanges(ctx, instanceChanges); err != nil {
+			errs = append(errs, instanceError(instance.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (f FanOut) AdjustEndpoints(endpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
+	return f.instances[0].AdjustEndpoints(endpoints)
+}
+
+func (f FanOut) GetDomainFilter() endpoint.DomainFilter {
+	return f.instances[0].GetDomainFilter()
+}
+
+func instanceError(name string, err error) error {
+	return fmt.Errorf("opnsense instance %q: %w", name, err)
+}
+
+// rebasePlan moves changes onto current, the records of another instance. Updates and deletes
+// use the rows of current, creates of records current already has become updates, and updates
+// of records current is missing become creates. Deletes of missing records are dropped.
+func rebasePlan(changes *plan.Changes, current []*endpoint.Endpoint) *plan.Changes {
+	updateOld, err := pairUpdates(changes.UpdateOld, changes.UpdateNew)
+	if err != nil {
+		// left for ApplyChanges to reject
+		return changes
+	}
+
+	existing := make(map[endpoint.EndpointKey]*endpoint.Endpoint, len(current))
+	for _, ep := range current {
+		if managedType(ep.RecordType) {
+			existing[ep.Key()] = ep
+		}
+	}
+
+	out := &plan.Changes{}
+	for _, ep := range changes.Create {
+		if old, ok := existing[ep.Key()]; ok {
+			out.UpdateOld = append(out.UpdateOld, old)
+			out.UpdateNew = append(out.UpdateNew, ep)
+			continue
+		}
+		out.Create = append(out.Create, ep)
+	}
+
+	for i, newEndpoint := range changes.UpdateNew {
+		old, ok := existing[updateOld[i].Key()]
+		switch {
+		case !managedType(newEndpoint.RecordType):
+			out.UpdateOld = append(out.UpdateOld, updateOld[i])
+			out.UpdateNew = append(out.UpdateNew, newEndpoint)
+		case ok:
+			out.UpdateOld = append(out.UpdateOld, old)
+			out.UpdateNew = append(out.UpdateNew, newEndpoint)
+		default:
+			out.Create = append(out.Create, newEndpoint)
+		}
+	}
+
+	for _, ep := range changes.Delete {
+		if !managedType(ep.RecordType) {
+			out.Delete = append(out.Delete, ep)
+			continue
+		}
+		if old, ok := existing[ep.Key()]; ok {
+			out.Delete = append(out.Delete, old)
+		}
+	}
+
+	return out
+}
+
+// rebaseDomainChanges moves changes onto current, the domain overrides of another instance.
+// Overrides are matched by domain and server. Updates of overrides current is missing become
+// creates, creates current already has are dropped, and so are deletes of missing overrides.
+func rebaseDomainChanges(changes DomainChanges, current []DomainOverride) DomainChanges {
+	type domainServer struct{ domain, server string }
+	existing := make(map[domainServer][]DomainOverride, len(current))
+	for _, domain := range current {
+		key := domainServer{domain.Domain, domain.Server}
+		existing[key] = append(existing[key], domain)
+	}
+	// take removes and returns an override of current matching domain, so each is used once
+	take := func(domain DomainOverride) (DomainOverride, bool) {
+		key := domainServer{domain.Domain, domain.Server}
+		found := existing[key]
+		if len(found) == 0 {
+			return DomainOverride{}, false
+		}
+		existing[key] = found[1:]
+		return found[0], true
+	}
+
+	out := DomainChanges{}
+	for _, update := range changes.Update {
+		if old, ok := take(update.Override); ok {
+			out.Update = append(out.Update, DomainUpdate{Override: old, Server: update.Server})
+			continue
+		}
+		domain := update.Override
+		domain.UUID = ""
+		domain.Server = update.Server
+		out.Create = append(out.Create, domain)
+	}
+
+	for _, domain := range changes.Create {
+		if _, ok := take(domain); !ok {
+			out.Create = append(out.Create, domain)
+		}
+	}
+
+	for _, domain := range changes.Delete {
+		if old, ok := take(domain); ok {
+			out.Delete = append(out.Delete, old)
+		}
+	}
+
+	return out
+}
+
+// compareRecords returns how found, the records of instance, differ from primary.
+func compareRecords(instance string, primary []*endpoint.Endpoint, found []*endpoint.Endpoint) []Drift {
+	foundByKey := make(map[endpoint.EndpointKey]*endpoint.Endpoint, len(found))
+	for _, ep := range found {
+		foundByKey[ep.Key()] = ep
+	}
+	primaryKeys := make(map[endpoint.EndpointKey]struct{}, len(primary))
+
+	var out []Drift
+	for _, ep := range primary {
+		primaryKeys[ep.Key()] = struct{}{}
+		other, ok := foundByKey[ep.Key()]
+		if ok && ep.Targets.Same(other.Targets) {
+			continue
+		}
+
+		drift := Drift{Instance: instance, DNSName: ep.DNSName, RecordType: ep.RecordType, Primary: ep.Targets}
+		if ok {
+			drift.Found = other.Targets
+		}
+		out = append(out, drift)
+	}
+
+	for _, ep := range found {
+		if _, ok := primaryKeys[ep.Key()]; !ok {
+			out = append(out, Drift{Instance: instance, DNSName: ep.DNSName, RecordType: ep.RecordType, Found: ep.Targets})
+		}
+	}
+
+	return out
+}
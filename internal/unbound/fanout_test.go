@@ -0,0 +1,243 @@
+package unbound
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func Test_rebasePlan(t *testing.T) {
+	t.Parallel()
+
+	row := func(uuid string) func(dnsName string, target string) *endpoint.Endpoint {
+		return func(dnsName string, target string) *endpoint.Endpoint {
+			ep := endpoint.NewEndpoint(dnsName, endpoint.RecordTypeA, target)
+			ep.Labels[RowsLabel] = uuid + "=" + target
+			return ep
+		}
+	}
+	primaryRow, instanceRow := row("primary-uuid"), row("instance-uuid")
+	txt := endpoint.NewEndpoint("a-foo.example.domain", endpoint.RecordTypeTXT, "heritage=external-dns")
+
+	tests := []struct {
+		name    string
+		changes *plan.Changes
+		current []*endpoint.Endpoint
+		want    *plan.Changes
+	}{
+		{
+			name: "uses the rows of the instance",
+			changes: &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{primaryRow("foo.example.domain", "10.0.0.1")},
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("foo.example.domain", endpoint.RecordTypeA, "10.0.0.2")},
+				Delete:    []*endpoint.Endpoint{primaryRow("bar.example.domain", "10.0.0.3"), txt},
+			},
+			current: []*endpoint.Endpoint{
+				instanceRow("foo.example.domain", "10.0.0.1"),
+				instanceRow("bar.example.domain", "10.0.0.3"),
+			},
+			want: &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{instanceRow("foo.example.domain", "10.0.0.1")},
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("foo.example.domain", endpoint.RecordTypeA, "10.0.0.2")},
+				Delete:    []*endpoint.Endpoint{instanceRow("bar.example.domain", "10.0.0.3"), txt},
+			},
+		},
+		{
+			name: "create of an existing record becomes an update",
+			changes: &plan.Changes{
+				Create: []*endpoint.Endpoint{endpoint.NewEndpoint("foo.example.domain", endpoint.RecordTypeA, "10.0.0.2"), txt},
+			},
+			current: []*endpoint.Endpoint{instanceRow("foo.example.domain", "10.0.0.1")},
+			want: &plan.Changes{
+				Create:    []*endpoint.Endpoint{txt},
+				UpdateOld: []*endpoint.Endpoint{instanceRow("foo.example.domain", "10.0.0.1")},
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("foo.example.domain", endpoint.RecordTypeA, "10.0.0.2")},
+			},
+		},
+		{
+			name: "missing records are created, not deleted",
+			changes: &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{primaryRow("foo.example.domain", "10.0.0.1")},
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("foo.example.domain", endpoint.RecordTypeA, "10.0.0.2")},
+				Delete:    []*endpoint.Endpoint{primaryRow("bar.example.domain", "10.0.0.3")},
+			},
+			want: &plan.Changes{
+				Create: []*endpoint.Endpoint{endpoint.NewEndpoint("foo.example.domain", endpoint.RecordTypeA, "10.0.0.2")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, rebasePlan(tt.changes, tt.current))
+		})
+	}
+}
+
+func Test_rebaseDomainChanges(t *testing.T) {
+	t.Parallel()
+
+	domain := func(uuid string, server string) DomainOverride {
+		return DomainOverride{UUID: uuid, Enabled: "1", Domain: "lab.example", Server: server}
+	}
+
+	tests := []struct {
+		name    string
+		changes DomainChanges
+		current []DomainOverride
+		want    DomainChanges
+	}{
+		{
+			name: "uses the overrides of the instance",
+			changes: DomainChanges{
+				Update: []DomainUpdate{{Override: domain("primary-1", "10.0.0.1"), Server: "10.0.0.2"}},
+				Delete: []DomainOverride{domain("primary-2", "10.0.0.3")},
+			},
+			current: []DomainOverride{domain("instance-1", "10.0.0.1"), domain("instance-2", "10.0.0.3")},
+			want: DomainChanges{
+				Update: []DomainUpdate{{Override: domain("instance-1", "10.0.0.1"), Server: "10.0.0.2"}},
+				Delete: []DomainOverride{domain("instance-2", "10.0.0.3")},
+			},
+		},
+		{
+			name:    "create of an existing override is dropped",
+			changes: DomainChanges{Create: []DomainOverride{domain("", "10.0.0.1")}},
+			current: []DomainOverride{domain("instance-1", "10.0.0.1")},
+			want:    DomainChanges{},
+		},
+		{
+			name: "missing overrides are created, not deleted",
+			changes: DomainChanges{
+				Update: []DomainUpdate{{Override: domain("primary-1", "10.0.0.1"), Server: "10.0.0.2"}},
+				Delete: []DomainOverride{domain("primary-2", "10.0.0.3")},
+			},
+			want: DomainChanges{Create: []DomainOverride{domain("", "10.0.0.2")}},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, rebaseDomainChanges(tt.changes, tt.current))
+		})
+	}
+}
+
+func Test_compareRecords(t *testing.T) {
+	t.Parallel()
+
+	primary := []*endpoint.Endpoint{
+		endpoint.NewEndpoint("same.example.domain", endpoint.RecordTypeA, "10.0.0.1", "10.0.0.2"),
+		endpoint.NewEndpoint("changed.example.domain", endpoint.RecordTypeA, "10.0.0.1"),
+		endpoint.NewEndpoint("missing.example.domain", endpoint.RecordTypeA, "10.0.0.1"),
+	}
+	found := []*endpoint.Endpoint{
+		endpoint.NewEndpoint("same.example.domain", endpoint.RecordTypeA, "10.0.0.2", "10.0.0.1"),
+		endpoint.NewEndpoint("changed.example.domain", endpoint.RecordTypeA, "10.0.0.9"),
+		endpoint.NewEndpoint("extra.example.domain", endpoint.RecordTypeA, "10.0.0.1"),
+	}
+
+	assert.Equal(t, []Drift{
+		{
+			Instance:   "backup",
+			DNSName:    "changed.example.domain",
+			RecordType: endpoint.RecordTypeA,
+			Primary:    endpoint.NewTargets("10.0.0.1"),
+			Found:      endpoint.NewTargets("10.0.0.9"),
+		},
+		{
+			Instance:   "backup",
+			DNSName:    "missing.example.domain",
+			RecordType: endpoint.RecordTypeA,
+			Primary:    endpoint.NewTargets("10.0.0.1"),
+		},
+		{
+			Instance:   "backup",
+			DNSName:    "extra.example.domain",
+			RecordType: endpoint.RecordTypeA,
+			Found:      endpoint.NewTargets("10.0.0.1"),
+		},
+	}, compareRecords("backup", primary, found))
+}
+
+func TestFanOut_ApplyChanges(t *testing.T) {
+	t.Parallel()
+
+	primary := testhelpers.NewFakeOpnsense()
+	primaryServer := httptest.NewServer(primary)
+	defer primaryServer.Close()
+
+	backup := testhelpers.NewFakeOpnsense()
+	backupServer := httptest.NewServer(backup)
+	defer backupServer.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	ctx := context.Background()
+
+	// the backup already has the record, eg from a manual sync
+	require.NoError(t, New(backupServer.Client(), config.Config{
+		Opnsense: config.Opnsense{BaseURL: backupServer.URL, Creds: "foo:bar"},
+	}, GetTestLogger()).ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("foo.example.domain", endpoint.RecordTypeA, "10.0.0.1")},
+	}))
+
+	subject := NewFanOut(http.DefaultClient, config.Config{
+		Opnsense: config.Opnsense{Instances: []config.Instance{
+			{Name: "broken", BaseURL: broken.URL, Creds: "foo:bar"},
+			{Name: "backup", BaseURL: backupServer.URL, Creds: "foo:bar"},
+			{Name: "main", BaseURL: primaryServer.URL, Creds: "foo:bar", Primary: true},
+		}},
+	}, GetTestLogger())
+
+	drift, err := subject.Drift(ctx)
+	assert.ErrorContains(t, err, `opnsense instance "broken"`)
+	assert.Equal(t, []Drift{{
+		Instance:   "backup",
+		DNSName:    "foo.example.domain",
+		RecordType: endpoint.RecordTypeA,
+		Found:      endpoint.NewTargets("10.0.0.1"),
+	}}, drift)
+
+	err = subject.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("foo.example.domain", endpoint.RecordTypeA, "10.0.0.1")},
+	})
+	assert.ErrorIs(t, err, ErrRequestFailed)
+	assert.ErrorContains(t, err, `opnsense instance "broken"`)
+	assert.NotContains(t, err.Error(), `opnsense instance "backup"`)
+
+	// the record is created on the primary, and not duplicated on the backup
+	assert.Equal(t, 1, countRequests(primary.Requests(), AddOverrideEndpoint))
+	assert.Equal(t, 1, countRequests(backup.Requests(), AddOverrideEndpoint))
+
+	got, err := subject.Records(ctx)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, "foo.example.domain", got[0].DNSName)
+
+	drift, err = subject.Drift(ctx)
+	assert.Error(t, err)
+	assert.Empty(t, drift)
+}
+
+func countRequests(requests []string, path string) int {
+	count := 0
+	for _, request := range requests {
+		if request == path {
+			count++
+		}
+	}
+	return count
+}
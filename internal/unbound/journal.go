@@ -0,0 +1,193 @@
+package unbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// rollbackTimeout bounds undoing a failed plan. Rollback runs detached from the context of the apply,
+// which may already be cancelled, so it needs a limit of its own.
+const rollbackTimeout = time.Minute
+
+// mutationKind is the kind of change made to an opnsense row.
+type mutationKind string
+
+const (
+	mutationCreate mutationKind = "create"
+	mutationUpdate mutationKind = "update"
+	mutationDelete mutationKind = "delete"
+)
+
+// mutation is a single change made to opnsense while applying a plan.
+type mutation struct {
+	kind mutationKind
+	// record is the row created, or the row as it was before an update or delete. For an undo it
+	// is the row as the undo left it.
+	record Record
+	// domain is set instead of record for changes to domain overrides.
+	domain *DomainOverride
+	// undo is set on the mutations made by a rollback, which are appended to the journal.
+	undo bool
+}
+
+func (m mutation) String() string {
+	if m.domain != nil {
+		return fmt.Sprintf("%v domain %v (%v)", m.kind, m.domain.Domain, m.domain.UUID)
+	}
+	return fmt.Sprintf("%v %v (%v)", m.kind, m.record.DNSName(), m.record.UUID)
+}
+
+// journal records every mutation made by ApplyChanges so a failed plan can be undone.
+type journal struct {
+	mutations []mutation
+}
+
+func newJournal() *journal {
+	return &journal{}
+}
+
+func (j *journal) created(record Record) {
+	j.mutations = append(j.mutations, mutation{kind: mutationCreate, record: record})
+}
+
+func (j *journal) updated(before Record) {
+	j.mutations = append(j.mutations, mutation{kind: mutationUpdate, record: before})
+}
+
+func (j *journal) deleted(before Record) {
+	j.mutations = append(j.mutations, mutation{kind: mutationDelete, record: before})
+}
+
+func (j *journal) domainCreated(domain DomainOverride) {
+	j.mutations = append(j.mutations, mutation{kind: mutationCreate, domain: &domain})
+}
+
+func (j *journal) domainUpdated(before DomainOverride) {
+	j.mutations = append(j.mutations, mutation{kind: mutationUpdate, domain: &before})
+}
+
+func (j *journal) domainDeleted(before DomainOverride) {
+	j.mutations = append(j.mutations, mutation{kind: mutationDelete, domain: &before})
+}
+
+func (j *journal) String() string {
+	out := make([]string, 0, len(j.mutations))
+	for _, m := range j.mutations {
+		out = append(out, m.String())
+	}
+	return strings.Join(out, ", ")
+}
+
+// checkpoint is the state kept outside opnsense that a rollback restores along with the rows.
+type checkpoint struct {
+	heritages map[endpoint.EndpointKey]string
+	// owners is nil unless the registry keeps ownership outside opnsense.
+	owners map[endpoint.EndpointKey]string
+}
+
+// checkpoint saves the cached heritages and the registry before anything is changed.
+func (u Unbound) checkpoint() checkpoint {
+	saved := checkpoint{heritages: u.knownRecords.snapshot()}
+	if registry, ok := u.registry.(restorableRegistry); ok {
+		saved.owners = registry.snapshot()
+	}
+	return saved
+}
+
+// rollback undoes tx in reverse order, restores the cached heritages and the registry, and
+// reconfigures unbound. The returned error joins cause with a summary of what was rolled back
+// and anything that could not be. Cancelling ctx does not stop the rollback, see rollbackTimeout.
+func (u Unbound) rollback(ctx context.Context, tx *journal, saved checkpoint, cause error) error {
+	u.knownRecords.restore(saved.heritages)
+
+	errs := []error{cause}
+	if registry, ok := u.registry.(restorableRegistry); ok && saved.owners != nil {
+		if err := registry.restore(saved.owners); err != nil {
+			errs = append(errs, fmt.Errorf("restore registry: %w", err))
+		}
+	}
+
+	if len(tx.mutations) == 0 {
+		if len(errs) == 1 {
+			return cause
+		}
+		return errors.Join(errs...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+
+	u.logger.WarnContext(ctx, "apply failed, rolling back",
+		slog.Any("error", cause),
+		slog.String("journal", tx.String()))
+
+	errs = append(errs, fmt.Errorf("%w: %v", ErrRolledBack, tx))
+	done := tx.mutations
+	for i := len(done) - 1; i >= 0; i-- {
+		undone, err := u.undo(ctx, done[i])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("undo %v: %w", done[i], err))
+			continue
+		}
+		tx.mutations = append(tx.mutations, undone)
+	}
+
+	if err := u.reconfigure(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("rollback reconfigure: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// undo reverts m and returns the mutation it made, so the audit log records the rows as they are
+// in opnsense. A deleted row comes back with a new uuid.
+func (u Unbound) undo(ctx context.Context, m mutation) (mutation, error) {
+	if m.domain != nil {
+		return u.undoDomain(ctx, *m.domain, m.kind)
+	}
+
+	switch m.kind {
+	case mutationCreate:
+		if m.record.UUID == "" {
+			return mutation{}, fmt.Errorf("opnsense returned no uuid: %w", ErrRollbackIncomplete)
+		}
+		return mutation{kind: mutationDelete, record: m.record, undo: true}, u.deleteRow(ctx, m.record)
+	case mutationUpdate:
+		return mutation{kind: mutationUpdate, record: m.record, undo: true}, u.setRecord(ctx, m.record.UUID, m.record)
+	case mutationDelete:
+		if m.record.Target() == "" {
+			return mutation{}, fmt.Errorf("target of deleted row unknown: %w", ErrRollbackIncomplete)
+		}
+		record := m.record
+		uuid, err := u.addRecord(ctx, record)
+		record.UUID = uuid
+		return mutation{kind: mutationCreate, record: record, undo: true}, err
+	default:
+		return mutation{}, nil
+	}
+}
+
+func (u Unbound) undoDomain(ctx context.Context, domain DomainOverride, kind mutationKind) (mutation, error) {
+	switch kind {
+	case mutationCreate:
+		if domain.UUID == "" {
+			return mutation{}, fmt.Errorf("opnsense returned no uuid: %w", ErrRollbackIncomplete)
+		}
+		return mutation{kind: mutationDelete, domain: &domain, undo: true},
+			u.deleteUUID(ctx, DelDomainEndpoint, domain.UUID, domain.Domain)
+	case mutationUpdate:
+		return mutation{kind: mutationUpdate, domain: &domain, undo: true}, u.setDomain(ctx, domain)
+	case mutationDelete:
+		uuid, err := u.addDomain(ctx, domain)
+		domain.UUID = uuid
+		return mutation{kind: mutationCreate, domain: &domain, undo: true}, err
+	default:
+		return mutation{}, nil
+	}
+}
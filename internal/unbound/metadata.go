@@ -0,0 +1,229 @@
+package unbound
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+const (
+	// metadataPrefix starts every description in the versioned metadata format, followed by the version.
+	metadataPrefix = "extdns/"
+	// metadataVersion is the version of the metadata format written by this provider.
+	metadataVersion = "1"
+	// metadataCommentSeparator separates the metadata from a free text comment.
+	metadataCommentSeparator = " | "
+
+	metadataOwner    = "o"
+	metadataResource = "r"
+	metadataType     = "t"
+	metadataCreated  = "c"
+	metadataDeleted  = "d"
+)
+
+var (
+	// ErrUnmanaged is returned when a description was not written by this provider.
+	ErrUnmanaged = errors.New("description is not managed by external-dns")
+	// ErrMetadataVersion is returned for metadata written by an unknown version of the format.
+	ErrMetadataVersion = errors.New("unknown metadata version")
+)
+
+// metadataEscaper escapes the characters that delimit metadata fields.
+var metadataEscaper = strings.NewReplacer("%", "%25", " ", "%20", "|", "%7C", "=", "%3D")
+
+// Metadata is what the provider records in the description of a managed record. It is written
+// as space separated key=value pairs so it stays readable in the OPNsense UI, eg:
+//
+//	extdns/1 o=default r=ingress/default/app t=A c=2024-05-01T10:00:00Z | relay for the lab
+//
+// Descriptions in the legacy "Managed by K8s external-dns <base64>" format are still read.
+type Metadata struct {
+	Owner      string
+	Resource   string
+	RecordType string
+	Created    time.Time
+	// Deleted is when the record was soft deleted, zero while it is live.
+	Deleted time.Time
+	// Comment is free text for operators, kept when the record is rewritten.
+	Comment string
+	// Labels holds any other external-dns labels.
+	Labels map[string]string
+
+	// owned is set when the metadata carries an ownership, which may have an empty owner.
+	owned bool
+	// opaque is a heritage that is not plain labels, eg an encrypted one. It can only be
+	// written in the legacy format.
+	opaque string
+}
+
+// ParseMetadata reads the metadata in description, in either the versioned or the legacy format.
+func ParseMetadata(description string) (Metadata, error) {
+	switch {
+	case strings.HasPrefix(description, metadataPrefix):
+		return parseVersionedMetadata(description)
+	case strings.HasPrefix(description, DescriptionPrefix):
+		heritage, ok := heritageFromDescription(description)
+		if !ok {
+			return Metadata{}, fmt.Errorf("legacy description: %w", ErrUnmanaged)
+		}
+		meta := Metadata{}
+		meta.setHeritage(heritage)
+		return meta, nil
+	default:
+		return Metadata{}, ErrUnmanaged
+	}
+}
+
+func parseVersionedMetadata(description string) (Metadata, error) {
+	head, comment, _ := strings.Cut(description, metadataCommentSeparator)
+	fields := strings.Fields(head)
+
+	if version := strings.TrimPrefix(fields[0], metadataPrefix); version != metadataVersion {
+		return Metadata{}, fmt.Errorf("%q: %w", version, ErrMetadataVersion)
+	}
+
+	meta := Metadata{Comment: comment}
+	for _, field := range fields[1:] {
+		key, escaped, _ := strings.Cut(field, "=")
+		value, err := url.PathUnescape(escaped)
+		if err != nil {
+			return Metadata{}, fmt.Errorf("metadata %q: %w", key, err)
+		}
+
+		switch key {
+		case metadataOwner:
+			meta.Owner = value
+			meta.owned = true
+		case metadataResource:
+			meta.Resource = value
+		case metadataType:
+			meta.RecordType = value
+		case metadataCreated:
+			created, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return Metadata{}, fmt.Errorf("metadata created: %w", err)
+			}
+			meta.Created = created
+		case metadataDeleted:
+			deleted, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return Metadata{}, fmt.Errorf("metadata deleted: %w", err)
+			}
+			meta.Deleted = deleted
+		default:
+			if meta.Labels == nil {
+				meta.Labels = make(map[string]string)
+			}
+			meta.Labels[key] = value
+		}
+	}
+
+	return meta, nil
+}
+
+// String encodes the metadata for the description field.
+func (m Metadata) String() string {
+	if m.opaque != "" {
+		return appendToDescription(base64.StdEncoding.EncodeToString([]byte(m.opaque)))
+	}
+
+	fields := []string{metadataPrefix + metadataVersion}
+	add := func(key, value string) {
+		if value != "" {
+			fields = append(fields, key+"="+metadataEscaper.Replace(value))
+		}
+	}
+
+	if m.owned && m.Owner == "" {
+		// keep the ownership of records owned by the empty owner id
+		fields = append(fields, metadataOwner+"=")
+	}
+	add(metadataOwner, m.Owner)
+	add(metadataResource, m.Resource)
+	add(metadataType, m.RecordType)
+	if !m.Created.IsZero() {
+		add(metadataCreated, m.Created.UTC().Format(time.RFC3339))
+	}
+	if !m.Deleted.IsZero() {
+		add(metadataDeleted, m.Deleted.UTC().Format(time.RFC3339))
+	}
+
+	keys := make([]string, 0, len(m.Labels))
+	for key := range m.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		add(key, m.Labels[key])
+	}
+
+	out := strings.Join(fields, " ")
+	if m.Comment != "" {
+		out += metadataCommentSeparator + m.Comment
+	}
+	return out
+}
+
+// Heritage is the external-dns TXT value for the ownership in the metadata, empty when unowned.
+func (m Metadata) Heritage() string {
+	if m.opaque != "" {
+		return m.opaque
+	}
+
+	labels := endpoint.NewLabels()
+	maps.Copy(labels, m.Labels)
+	if m.Owner != "" {
+		labels[endpoint.OwnerLabelKey] = m.Owner
+	}
+	if m.Resource != "" {
+		labels[endpoint.ResourceLabelKey] = m.Resource
+	}
+	if len(labels) == 0 && !m.owned {
+		return ""
+	}
+
+	return labels.SerializePlain(false)
+}
+
+// setHeritage replaces the ownership in the metadata with heritage. An empty heritage
+// leaves the ownership alone.
+func (m *Metadata) setHeritage(heritage string) {
+	if heritage == "" {
+		return
+	}
+
+	m.Owner, m.Resource, m.Labels, m.owned, m.opaque = "", "", nil, false, ""
+
+	labels, err := endpoint.NewLabelsFromStringPlain(heritage)
+	if err != nil {
+		m.opaque = heritage
+		return
+	}
+
+	m.owned = true
+	m.Owner = labels[endpoint.OwnerLabelKey]
+	m.Resource = labels[endpoint.ResourceLabelKey]
+	delete(labels, endpoint.OwnerLabelKey)
+	delete(labels, endpoint.ResourceLabelKey)
+	if len(labels) > 0 {
+		m.Labels = labels
+	}
+}
+
+// withoutOwnership is the metadata with the ownership removed, for registries storing it elsewhere.
+func (m Metadata) withoutOwnership() Metadata {
+	m.Owner, m.Resource, m.Labels, m.owned, m.opaque = "", "", nil, false, ""
+	return m
+}
+
+// isLegacyDescription reports whether description is in the legacy base64 format.
+func isLegacyDescription(description string) bool {
+	return strings.HasPrefix(description, DescriptionPrefix)
+}
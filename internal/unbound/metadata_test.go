@@ -0,0 +1,168 @@
+package unbound
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMetadata(t *testing.T) {
+	t.Parallel()
+	created := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		description string
+		want        Metadata
+		wantErr     error
+	}{
+		{
+			name:        "versioned",
+			description: "extdns/1 o=default r=ingress/default/app t=A c=2024-05-01T10:00:00Z",
+			want: Metadata{
+				Owner:      "default",
+				Resource:   "ingress/default/app",
+				RecordType: "A",
+				Created:    created,
+				owned:      true,
+			},
+		},
+		{
+			name:        "soft deleted",
+			description: "extdns/1 o=default t=A c=2024-05-01T10:00:00Z d=2024-05-02T10:00:00Z",
+			want: Metadata{
+				Owner:      "default",
+				RecordType: "A",
+				Created:    created,
+				Deleted:    created.Add(24 * time.Hour),
+				owned:      true,
+			},
+		},
+		{
+			name:        "comment and extra labels",
+			description: "extdns/1 o=default t=MX team=infra | relay for the lab",
+			want: Metadata{
+				Owner:      "default",
+				RecordType: "MX",
+				Comment:    "relay for the lab",
+				Labels:     map[string]string{"team": "infra"},
+				owned:      true,
+			},
+		},
+		{
+			name:        "escaped values",
+			description: "extdns/1 o=a%20b%7Cc%3Dd%25",
+			want:        Metadata{Owner: "a b|c=d%", owned: true},
+		},
+		{
+			name:        "empty owner",
+			description: "extdns/1 o= t=A",
+			want:        Metadata{RecordType: "A", owned: true},
+		},
+		{
+			name:        "legacy",
+			description: appendToDescription("aGVyaXRhZ2U9ZXh0ZXJuYWwtZG5zLGV4dGVybmFsLWRucy9vd25lcj1kZWZhdWx0LGV4dGVybmFsLWRucy9yZXNvdXJjZT1pbmdyZXNzL2plbGx5YmVsbHkvamVsbHliZWxseQ=="), //nolint:lll
+			want: Metadata{
+				Owner:    "default",
+				Resource: "ingress/jellybelly/jellybelly",
+				owned:    true,
+			},
+		},
+		{
+			name:        "legacy without ownership",
+			description: DescriptionPrefix + " ",
+			want:        Metadata{},
+		},
+		{
+			name:        "unknown version",
+			description: "extdns/2 o=default",
+			wantErr:     ErrMetadataVersion,
+		},
+		{
+			name:        "unmanaged",
+			description: "added by hand",
+			wantErr:     ErrUnmanaged,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseMetadata(tt.description)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := ParseMetadata("extdns/1 c=yesterday")
+	assert.Error(t, err)
+}
+
+func TestMetadata_String(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		meta Metadata
+		want string
+	}{
+		{
+			name: "empty",
+			want: "extdns/1",
+		},
+		{
+			name: "every field",
+			meta: Metadata{
+				Owner:      "default",
+				Resource:   "ingress/default/app",
+				RecordType: "A",
+				Created:    time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60)),
+				Comment:    "kept | as is",
+				Labels:     map[string]string{"zone": "lab", "team": "infra"},
+			},
+			want: "extdns/1 o=default r=ingress/default/app t=A c=2024-05-01T10:00:00Z team=infra zone=lab | kept | as is",
+		},
+		{
+			name: "escaped values",
+			meta: Metadata{Owner: "a b|c=d%"},
+			want: "extdns/1 o=a%20b%7Cc%3Dd%25",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := tt.meta.String()
+			assert.Equal(t, tt.want, got)
+
+			parsed, err := ParseMetadata(got)
+			require.NoError(t, err)
+			assert.Equal(t, tt.meta.String(), parsed.String())
+		})
+	}
+}
+
+func TestMetadata_Heritage(t *testing.T) {
+	t.Parallel()
+	heritage := "heritage=external-dns,external-dns/owner=default,external-dns/resource=ingress/default/app"
+
+	meta := Metadata{}
+	meta.setHeritage(heritage)
+	assert.Equal(t, Metadata{Owner: "default", Resource: "ingress/default/app", owned: true}, meta)
+	assert.Equal(t, heritage, meta.Heritage())
+
+	meta.setHeritage("")
+	assert.Equal(t, heritage, meta.Heritage(), "an empty heritage keeps the ownership")
+
+	// heritages that are not plain labels, eg encrypted ones, are kept in the legacy format
+	meta.setHeritage("not labels")
+	assert.Equal(t, "not labels", meta.Heritage())
+	assert.Equal(t, appendToDescription("bm90IGxhYmVscw=="), meta.String())
+
+	assert.Empty(t, meta.withoutOwnership().Heritage())
+
+	// external-dns run without an owner id owns records with an empty owner
+	meta.setHeritage("heritage=external-dns")
+	assert.Equal(t, "extdns/1 o=", meta.String())
+	assert.Equal(t, "heritage=external-dns", meta.Heritage())
+}
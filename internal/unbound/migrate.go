@@ -0,0 +1,90 @@
+package unbound
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// MigrateDescriptions rewrites, in place, every override and alias whose description is still in
+// the legacy base64 format, using the versioned metadata format. It returns the number of rows
+// rewritten. If any row fails the rows already rewritten are restored. Migrations that rewrite
+// anything are audited.
+func (u Unbound) MigrateDescriptions(ctx context.Context) (int, error) {
+	leave, err := u.queue.enter(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer leave()
+
+	_, rows, err := u.read(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("read records: %w", err)
+	}
+
+	saved := u.checkpoint()
+	tx := newJournal()
+	for _, row := range rows {
+		if !isLegacyDescription(row.Description) {
+			continue
+		}
+
+		if err := u.migrateRow(ctx, tx, row); err != nil {
+			err = u.rollback(ctx, tx, saved, fmt.Errorf("migrate %v: %w", row.DNSName(), err))
+			u.audited(ctx, auditMigrate, nil, nil, tx.mutations, err)
+
+			return 0, err
+		}
+	}
+
+	if len(tx.mutations) == 0 {
+		return 0, nil
+	}
+
+	if err := u.reconfigure(ctx); err != nil {
+		err = u.rollback(ctx, tx, saved, fmt.Errorf("migrate reconfigure: %w", err))
+		u.audited(ctx, auditMigrate, nil, nil, tx.mutations, err)
+
+		return 0, err
+	}
+	u.audited(ctx, auditMigrate, nil, nil, tx.mutations, nil)
+
+	return len(tx.mutations), nil
+}
+
+func (u Unbound) migrateRow(ctx context.Context, tx *journal, row Record) error {
+	meta, err := ParseMetadata(row.Description)
+	if err != nil {
+		u.logger.WarnContext(ctx, "skipping migration, unreadable description",
+			slog.String("endpoint", row.DNSName()),
+			slog.Any("error", err))
+
+		return nil
+	}
+	meta.RecordType = row.RecordType()
+	// the legacy format has no created time, the migration is the earliest time known
+	meta.Created = u.now().UTC().Truncate(time.Second)
+
+	description, err := u.registry.Own(ctx, ownershipKey(row.DNSName(), row.RecordType()), meta)
+	if err != nil {
+		return err
+	}
+	if description == row.Description {
+		// heritages that are not plain labels can only be written in the legacy format
+		return nil
+	}
+
+	before := row
+	before.Rr = before.RecordType()
+	migrated := before
+	migrated.Description = description
+
+	if err := u.setRecord(ctx, row.UUID, migrated); err != nil {
+		return err
+	}
+
+	tx.updated(before)
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package unbound
+
+import (
+	"context"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnbound_MigrateDescriptions(t *testing.T) {
+	t.Parallel()
+	legacy := "Managed by K8s external-dns aGVyaXRhZ2U9ZXh0ZXJuYWwtZG5zLGV4dGVybmFsLWRucy9vd25lcj1kZWZhdWx0LGV4dGVybmFsLWRucy9yZXNvdXJjZT1pbmdyZXNzL2plbGx5YmVsbHkvamVsbHliZWxseQ==" //nolint:lll
+	tests := []struct {
+		name         string
+		serverResps  []string
+		want         int
+		wantRequests map[string][]string
+		wantErr      error
+	}{
+		{
+			name: "legacy overrides and aliases are rewritten",
+			serverResps: []string{
+				`{"rows": [
+					{"uuid": "uuid-1", "hostname": "app", "domain": "example.domain", "rr": "A (IPv4 address)", "server": "10.0.0.1", "enabled": "1", "description": "` + legacy + `"},
+					{"uuid": "uuid-2", "hostname": "new", "domain": "example.domain", "rr": "A", "server": "10.0.0.2", "enabled": "1", "description": "extdns/1 o=default t=A"},
+					{"uuid": "uuid-3", "hostname": "manual", "domain": "example.domain", "rr": "A", "server": "10.0.0.3", "enabled": "1", "description": "added by hand"}
+				]}`,
+				`{"rows": [{"uuid": "alias-1", "host": "uuid-1", "hostname": "www", "domain": "example.domain", "enabled": "1", "description": "` + legacy + `"}]}`,
+				testhelpers.CreateSuccessServResp,
+				testhelpers.CreateSuccessServResp,
+				testhelpers.ReconfigureResp,
+			},
+			want: 2,
+			wantRequests: map[string][]string{
+				SearchOverridesEndpoint: {""},
+				SearchAliasesEndpoint:   {""},
+				path.Join(SetOverrideEndpoint, "uuid-1"): {
+					`{"host":{"hostname":"app","domain":"example.domain","rr":"A","server":"10.0.0.1","enabled":"1","description":"extdns/1 o=default r=ingress/jellybelly/jellybelly t=A c=2024-05-01T10:00:00Z"}}`, //nolint:lll
+				},
+				path.Join(SetAliasEndpoint, "alias-1"): {
+					`{"alias":{"host":"uuid-1","hostname":"www","domain":"example.domain","enabled":"1","description":"extdns/1 o=default r=ingress/jellybelly/jellybelly t=CNAME c=2024-05-01T10:00:00Z"}}`, //nolint:lll
+				},
+				ApplyChangesEndpoint: {`"{}"`},
+			},
+		},
+		{
+			name: "nothing to migrate",
+			serverResps: []string{
+				`{"rows": [{"uuid": "uuid-2", "hostname": "new", "domain": "example.domain", "rr": "A", "server": "10.0.0.2", "enabled": "1", "description": "extdns/1 o=default t=A"}]}`, //nolint:lll
+				`{"rows": []}`,
+			},
+			wantRequests: map[string][]string{
+				SearchOverridesEndpoint: {""},
+				SearchAliasesEndpoint:   {""},
+			},
+		},
+		{
+			name: "failure restores migrated rows",
+			serverResps: []string{
+				`{"rows": [
+					{"uuid": "uuid-1", "hostname": "app", "domain": "example.domain", "rr": "A", "server": "10.0.0.1", "enabled": "1", "description": "` + legacy + `"},
+					{"uuid": "uuid-2", "hostname": "other", "domain": "example.domain", "rr": "A", "server": "10.0.0.2", "enabled": "1", "description": "` + legacy + `"}
+				]}`,
+				`{"rows": []}`,
+				testhelpers.CreateSuccessServResp,
+				testhelpers.CreateFailServeResp,
+				// rollback
+				testhelpers.CreateSuccessServResp,
+				testhelpers.ReconfigureResp,
+			},
+			wantRequests: map[string][]string{
+				SearchOverridesEndpoint: {""},
+				SearchAliasesEndpoint:   {""},
+				path.Join(SetOverrideEndpoint, "uuid-1"): {
+					`{"host":{"hostname":"app","domain":"example.domain","rr":"A","server":"10.0.0.1","enabled":"1","description":"extdns/1 o=default r=ingress/jellybelly/jellybelly t=A c=2024-05-01T10:00:00Z"}}`, //nolint:lll
+					`{"host":{"hostname":"app","domain":"example.domain","rr":"A","server":"10.0.0.1","enabled":"1","description":"` + legacy + `"}}`,
+				},
+				path.Join(SetOverrideEndpoint, "uuid-2"): {
+					`{"host":{"hostname":"other","domain":"example.domain","rr":"A","server":"10.0.0.2","enabled":"1","description":"extdns/1 o=default r=ingress/jellybelly/jellybelly t=A c=2024-05-01T10:00:00Z"}}`, //nolint:lll
+				},
+				ApplyChangesEndpoint: {`"{}"`},
+			},
+			wantErr: ErrRolledBack,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler, gotRequests := testhelpers.TestHandler(t, tt.serverResps)
+			server := httptest.NewServer(handler)
+			defer server.Close()
+			cfg := config.Config{Opnsense: config.Opnsense{BaseURL: server.URL, Creds: "foo:bar"}}
+			u := New(server.Client(), cfg, GetTestLogger(), WithClock(testClock))
+
+			got, err := u.MigrateDescriptions(context.Background())
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantRequests, gotRequests)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package unbound
+
+import (
+	"strings"
+)
+
+// nameSplitter splits dns names into the opnsense hostname and domain. opnsense has no notion of
+// zones, so the configured domain filters are used as the zones: a name matching one is an apex
+// record, stored with an empty hostname. Wildcards are stored with the hostname "*".
+type nameSplitter struct {
+	zones map[string]struct{}
+}
+
+func newNameSplitter(zones []string) nameSplitter {
+	out := nameSplitter{zones: make(map[string]struct{}, len(zones))}
+	for _, zone := range zones {
+		// external-dns filters may start with a dot to match subdomains only
+		zone = strings.ToLower(strings.Trim(strings.TrimSpace(zone), "."))
+		if zone != "" {
+			out.zones[zone] = struct{}{}
+		}
+	}
+	return out
+}
+
+// split returns the opnsense hostname and domain of dnsName. The first label is the hostname,
+// unless dnsName is a zone apex or has a single label.
+func (s nameSplitter) split(dnsName string) (string, string) {
+	dnsName = strings.TrimSuffix(dnsName, ".")
+	if _, ok := s.zones[strings.ToLower(dnsName)]; ok {
+		return "", dnsName
+	}
+
+	hostname, domain, found := strings.Cut(dnsName, ".")
+	if !found {
+		return "", dnsName
+	}
+	return hostname, domain
+}
+
+// joinDNSName is the dns name of an opnsense hostname and domain, the inverse of split.
+func joinDNSName(hostname string, domain string) string {
+	domain = strings.TrimSuffix(domain, ".")
+	if hostname == "" {
+		return domain
+	}
+	return hostname + "." + domain
+}
@@ -0,0 +1,132 @@
+package unbound
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func Test_nameSplitter_split(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name         string
+		zones        []string
+		dnsName      string
+		wantHostname string
+		wantDomain   string
+	}{
+		{
+			name:         "host",
+			zones:        []string{"example.com"},
+			dnsName:      "foo.example.com",
+			wantHostname: "foo",
+			wantDomain:   "example.com",
+		},
+		{
+			name:         "apex",
+			zones:        []string{"example.com"},
+			dnsName:      "example.com",
+			wantHostname: "",
+			wantDomain:   "example.com",
+		},
+		{
+			name:         "apex of a subdomain filter",
+			zones:        []string{".Apps.Example.com."},
+			dnsName:      "apps.example.com",
+			wantHostname: "",
+			wantDomain:   "apps.example.com",
+		},
+		{
+			name:         "apex without zones",
+			dnsName:      "example.com",
+			wantHostname: "example",
+			wantDomain:   "com",
+		},
+		{
+			name:         "wildcard",
+			zones:        []string{"example.com"},
+			dnsName:      "*.apps.example.com",
+			wantHostname: "*",
+			wantDomain:   "apps.example.com",
+		},
+		{
+			name:         "trailing dot",
+			zones:        []string{"example.com"},
+			dnsName:      "foo.example.com.",
+			wantHostname: "foo",
+			wantDomain:   "example.com",
+		},
+		{
+			name:         "apex with trailing dot",
+			zones:        []string{"example.com"},
+			dnsName:      "example.com.",
+			wantHostname: "",
+			wantDomain:   "example.com",
+		},
+		{
+			name:         "single label",
+			dnsName:      "localhost",
+			wantHostname: "",
+			wantDomain:   "localhost",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			hostname, domain := newNameSplitter(tt.zones).split(tt.dnsName)
+			assert.Equal(t, tt.wantHostname, hostname)
+			assert.Equal(t, tt.wantDomain, domain)
+			assert.Equal(t, strings.TrimSuffix(tt.dnsName, "."), joinDNSName(hostname, domain))
+		})
+	}
+}
+
+func TestUnbound_zoneNames(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	handler, gotRequests := testhelpers.TestHandler(t, []string{
+		`{"result":"saved","uuid":"apex-uuid"}`,
+		`{"result":"saved","uuid":"wildcard-uuid"}`,
+		testhelpers.ReconfigureResp,
+		`{"rows": [
+			{"uuid": "apex-uuid", "hostname": "", "domain": "example.domain", "rr": "A", "server": "10.0.0.1"},
+			{"uuid": "wildcard-uuid", "hostname": "*", "domain": "preview.example.domain", "rr": "A", "server": "10.0.0.2"}
+		]}`,
+		`{"rows": []}`,
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	cfg := config.Config{
+		Opnsense:     config.Opnsense{BaseURL: server.URL, Creds: "foo:bar"},
+		DomainFilter: config.DomainFilter{Filter: []string{"example.domain"}},
+	}
+	u := New(server.Client(), cfg, GetTestLogger(), WithClock(testClock))
+
+	require.NoError(t, u.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("example.domain.", endpoint.RecordTypeA, "10.0.0.1"),
+			endpoint.NewEndpoint("*.preview.example.domain", endpoint.RecordTypeA, "10.0.0.2"),
+		},
+	}))
+	assert.Equal(t, []string{
+		`{"host":{"hostname":"","domain":"example.domain","rr":"A","server":"10.0.0.1","enabled":"1","description":"extdns/1 t=A c=2024-05-01T10:00:00Z"}}`,
+		`{"host":{"hostname":"*","domain":"preview.example.domain","rr":"A","server":"10.0.0.2","enabled":"1","description":"extdns/1 t=A c=2024-05-01T10:00:00Z"}}`, //nolint:lll
+	}, gotRequests[AddOverrideEndpoint])
+
+	got, err := u.Records(ctx)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, "example.domain", got[0].DNSName)
+	assert.Equal(t, "*.preview.example.domain", got[1].DNSName)
+}
@@ -7,13 +7,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"path"
 	"strings"
+	"time"
 
+	"github.com/MrUsefull/boundation/internal/audit"
 	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/metrics"
 	"sigs.k8s.io/external-dns/endpoint"
 	"sigs.k8s.io/external-dns/plan"
 	"sigs.k8s.io/external-dns/provider"
@@ -28,20 +30,63 @@ const (
 	AddOverrideEndpoint = apiPrefix + "/settings/addHostOverride"
 	// DelOverrideEndpoint is the api endpoint for deleting DNS entries.
 	DelOverrideEndpoint = apiPrefix + "/settings/delHostOverride/"
+	// SetOverrideEndpoint is the api endpoint for editing an existing DNS entry in place.
+	SetOverrideEndpoint = apiPrefix + "/settings/setHostOverride/"
+	// SearchAliasesEndpoint is used to get the aliases attached to existing DNS entries.
+	SearchAliasesEndpoint = apiPrefix + "/settings/searchHostAlias"
+	// AddAliasEndpoint attaches a new alias to an existing DNS entry.
+	AddAliasEndpoint = apiPrefix + "/settings/addHostAlias"
+	// SetAliasEndpoint is the api endpoint for editing an existing alias in place.
+	SetAliasEndpoint = apiPrefix + "/settings/setHostAlias/"
+	// DelAliasEndpoint is the api endpoint for deleting aliases.
+	DelAliasEndpoint = apiPrefix + "/settings/delHostAlias/"
+	// SearchDomainsEndpoint is used to get existing domain overrides, which forward a zone to another server.
+	SearchDomainsEndpoint = apiPrefix + "/settings/searchDomainOverride"
+	// AddDomainEndpoint creates domain overrides.
+	AddDomainEndpoint = apiPrefix + "/settings/addDomainOverride"
+	// SetDomainEndpoint edits a domain override in place, followed by its uuid.
+	SetDomainEndpoint = apiPrefix + "/settings/setDomainOverride/"
+	// DelDomainEndpoint is the api endpoint for deleting domain overrides.
+	DelDomainEndpoint = apiPrefix + "/settings/delDomainOverride/"
+	// ToggleOverrideEndpoint enables or disables a host override, followed by its uuid and "1" or "0".
+	ToggleOverrideEndpoint = apiPrefix + "/settings/toggleHostOverride/"
+	// ToggleAliasEndpoint enables or disables a host alias, followed by its uuid and "1" or "0".
+	ToggleAliasEndpoint = apiPrefix + "/settings/toggleHostAlias/"
 
 	ApplyChangesEndpoint = apiPrefix + "/service/reconfigure"
 
 	authHeader = "Authorization"
 
 	CreateOpSuccessResponse = "saved"
+	UpdateOpSuccessResponse = "saved"
 	DeleteOpSuccessResponse = "deleted"
+	// NotFoundResponse is the result of deleting a row that does not exist.
+	NotFoundResponse = "not found"
+	// EnabledResponse and DisabledResponse are the results of a toggle.
+	EnabledResponse  = "Enabled"
+	DisabledResponse = "Disabled"
 
 	DescriptionPrefix = "Managed by K8s external-dns"
 )
 
 var (
-	ErrRequestFailed = errors.New("request failed")
-	ErrMarshalling   = errors.New("marshal response")
+	ErrRequestFailed  = errors.New("request failed")
+	ErrMarshalling    = errors.New("marshal response")
+	ErrUnpairedUpdate = errors.New("update old and new endpoints do not pair up")
+	// ErrAliasTarget is returned when a CNAME does not point at an override an alias can attach to.
+	ErrAliasTarget = errors.New("alias target is not a host override")
+	// ErrInvalidMXTarget is returned for MX targets not in the "priority host" form.
+	ErrInvalidMXTarget = errors.New("invalid MX target")
+	// ErrRolledBack is returned when a failed apply was undone.
+	ErrRolledBack = errors.New("changes rolled back")
+	// ErrRollbackIncomplete is returned when a mutation could not be undone.
+	ErrRollbackIncomplete = errors.New("unable to roll back")
+	// ErrTransient marks a failed request that may succeed if tried again.
+	ErrTransient = errors.New("transient failure")
+	// ErrCircuitOpen is returned without contacting opnsense after too many consecutive failures.
+	ErrCircuitOpen = errors.New("circuit breaker open")
+	// ErrPaging is returned when a search never ends, opnsense ignoring the page requested.
+	ErrPaging = errors.New("search paging ignored")
 )
 
 var _ provider.Provider = &Unbound{}
@@ -51,6 +96,8 @@ var _ provider.Provider = &Unbound{}
 // success response is typically the opSuccessResponse value.
 type OperationResponse struct {
 	Result string `json:"result"`
+	// UUID is set by add operations to the uuid of the new row.
+	UUID string `json:"uuid,omitempty"`
 }
 
 // Unbound is the opnsense unbound dns provider implementation.
@@ -67,54 +114,161 @@ type Unbound struct {
 	// knownRecords tracks dns records we've seen and their associated "TXT Record" - ie description
 	// unbound does not support txt records, so we stuff txt records into the description field
 	knownRecords *cache
+	// queue serializes every call that reads or writes opnsense. Unbound is copied by its value
+	// receivers, the copies share the queue and the cache.
+	queue queue
+	// registry stores the ownership of managed records.
+	registry Registry
+	// txtNames maps records to the ownership TXT names external-dns is configured for.
+	txtNames txtNames
+	// splitter splits dns names into opnsense hostnames and domains.
+	splitter nameSplitter
+	// now is the clock used for the created time of new records.
+	now func() time.Time
+	// retries is how transient request failures are retried.
+	retries retryPolicy
+	// breaker fails requests fast while opnsense keeps failing. It is shared by every copy.
+	breaker *breaker
+	// dryRun, when set, answers every call that would change opnsense instead of sending it.
+	dryRun *dryRun
+	// safeguards are checked before a plan is applied.
+	safeguards safeguards
+	// softDelete is whether deletions only disable rows, and how long disabled rows are kept.
+	softDelete softDeletePolicy
+	// metrics records the api calls, records and applies, labelled with instance. It may be nil.
+	metrics  *metrics.Metrics
+	instance string
+	// applied is the outcome of the last plan, reported by Ready. It is shared by every copy.
+	applied *applyStatus
+	// audit keeps a trail of every change, it may be nil.
+	audit *audit.Log
+}
+
+type Opts func(*Unbound)
+
+// WithClock replaces the clock used for the created time of new records.
+func WithClock(now func() time.Time) Opts {
+	return func(u *Unbound) {
+		u.now = now
+	}
+}
+
+// WithMetrics records the opnsense api calls, the managed records and the applies in m.
+func WithMetrics(m *metrics.Metrics) Opts {
+	return func(u *Unbound) {
+		u.metrics = m
+	}
+}
+
+// WithRegistry replaces the ownership registry selected by the config, so instances can share one.
+func WithRegistry(registry Registry) Opts {
+	return func(u *Unbound) {
+		u.registry = registry
+	}
 }
 
 // New creates an Unbound provider
 // client - the http client to use
 // baseUrl - location of the opnsense unbound API
 // creds - credentials in the form of apiKey:apiSecret.
-func New(client *http.Client, cfg config.Config, logger *slog.Logger) *Unbound {
-	return &Unbound{
+// Names matching a domain in cfg.Filter are treated as zone apexes.
+// The ownership registry is selected by cfg.Registry, falling back to the description registry.
+// Ownership TXT names follow cfg.TXT, which should match the external-dns TXT registry flags.
+// Transient request failures are retried following cfg.Retry, and cfg.Breaker sets when to stop
+// contacting opnsense altogether.
+// cfg.Safeguards protects unmanaged records and caps deletions, lifted by WithForce.
+// cfg.SoftDelete makes deletions disable rows instead, forced on by WithSoftDelete.
+// With cfg.DryRun, changes are logged instead of sent, see WithDryRun.
+// Every change is appended to the audit log at cfg.Audit.Path, when set.
+func New(client *http.Client, cfg config.Config, logger *slog.Logger, opts ...Opts) *Unbound {
+	registry, err := NewRegistry(cfg.Registry)
+	if err != nil {
+		logger.Error("using description registry", slog.Any("error", err))
+		registry = DescriptionRegistry{}
+	}
+
+	names := newTXTNames(cfg.TXT)
+	u := &Unbound{
 		client:       client,
 		baseURL:      cfg.BaseURL,
 		creds:        basicAuthEncoding(cfg.Creds),
 		domainFilter: endpoint.NewDomainFilterWithExclusions(cfg.Filter, cfg.Exclude),
 		logger:       logger,
-		knownRecords: newCache(logger),
+		knownRecords: newCache(logger, names),
+		queue:        newQueue(),
+		registry:     registry,
+		txtNames:     names,
+		splitter:     newNameSplitter(cfg.Filter),
+		now:          time.Now,
+		retries:      newRetryPolicy(cfg.Retry),
+		breaker:      newBreaker(cfg.Breaker),
+		safeguards:   newSafeguards(cfg.Safeguards),
+		softDelete:   newSoftDeletePolicy(cfg.SoftDelete),
+		instance:     config.PrimaryInstance,
+		applied:      &applyStatus{},
+		audit:        audit.New(cfg.Audit.Path),
+	}
+
+	if cfg.DryRun {
+		u.dryRun = &dryRun{report: u.logPlanned}
+	}
+
+	for _, opt := range opts {
+		opt(u)
+	}
+
+	if u.dryRun != nil {
+		u.registry = dryRunRegistry{Registry: u.registry}
 	}
+
+	return u
 }
 
 // Records returns all records or "overrides" in opnsense unbound. Unbound does not support
 // txt record types. If a record is managed by external-dns, it will have the associated txt records
 // in the description field. Records will marshall the txt fields into a separate endpoint.
+// Host aliases are returned as CNAME endpoints targeting the override they are attached to.
+// Records waits for any plan being applied to finish.
 func (u Unbound) Records(ctx context.Context) ([]*endpoint.Endpoint, error) {
-	url := u.baseURL + SearchOverridesEndpoint
-	u.logger.InfoContext(ctx, "records request url", slog.String("URL", url))
-
-	req, err := u.apiRequest(ctx, http.MethodGet, url, nil)
+	leave, err := u.queue.enter(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("records request: %w", err)
+		return nil, err
 	}
+	defer leave()
+
+	endpoints, _, err := u.read(ctx)
+	return endpoints, err
+}
 
-	resp, err := u.client.Do(req)
+// read returns every override and alias, both as endpoints and as the raw rows, refreshing the cache.
+// Soft deleted rows are only returned as rows.
+func (u Unbound) read(ctx context.Context) ([]*endpoint.Endpoint, []Record, error) {
+	rows, err := u.searchRows(ctx, "")
 	if err != nil {
-		return nil, fmt.Errorf("records response: %w", err)
+		return nil, nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("status %v: %w", resp.Status, ErrRequestFailed)
+	aliases, err := u.searchAliases(ctx, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("aliases: %w", err)
 	}
+	rows = append(rows, aliasRecords(aliases, rows)...)
 
-	endpoints, err := u.marshalEndpoints(ctx, resp)
+	endpoints, err := endpointsWithOwners(ctx, liveRows(rows), u.registry, u.txtNames)
 	if err != nil {
-		return nil, errors.Join(ErrMarshalling, err)
+		return nil, nil, err
 	}
+	u.knownRecords.updateReadRecords(endpoints, rows)
+	u.metrics.SetRecords(u.instance, managedCounts(rows))
+	u.metrics.SetCacheRows(u.instance, u.knownRecords.size())
 
-	u.knownRecords.updateReadRecords(endpoints)
-
-	return endpoints, nil
+	return endpoints, rows, nil
 }
 
+// ApplyChanges applies the plan to opnsense. Every mutation is journaled, and if any step fails
+// the journal is undone in reverse so opnsense and the cache are left as they were before the plan.
+// Plans are applied one at a time, in the order they arrive. Plans breaking the safeguards are
+// rejected before anything is sent. Every plan applied or rejected is audited.
 func (u Unbound) ApplyChanges(ctx context.Context, changes *plan.Changes) error {
 	if !changes.HasChanges() {
 		u.logger.DebugContext(ctx, "no changes to apply")
@@ -122,13 +276,89 @@ func (u Unbound) ApplyChanges(ctx context.Context, changes *plan.Changes) error
 		return nil
 	}
 
+	leave, err := u.queue.enter(ctx)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	if err := u.guard(changes); err != nil {
+		u.logger.ErrorContext(ctx, "refusing to apply plan", slog.Any("error", err))
+		u.applied.record(u.now(), err)
+		u.audited(ctx, auditApply, changes, nil, nil, err)
+
+		return err
+	}
+
+	saved := u.checkpoint()
 	u.knownRecords.updateFromPlan(changes)
 
-	if err := u.deleteEndpoints(ctx, append(changes.Delete, changes.UpdateOld...)); err != nil {
+	tx := newJournal()
+	if err := u.applyChanges(ctx, tx, changes); err != nil {
+		err = u.rollback(ctx, tx, saved, err)
+		u.applied.record(u.now(), err)
+		u.audited(ctx, auditApply, changes, nil, tx.mutations, err)
+
+		return err
+	}
+
+	// soft deleted rows keep their owner, so they can be enabled again
+	if !u.softDelete.enabled {
+		u.disown(ctx, changes.Delete)
+	}
+	u.applied.record(u.now(), nil)
+	u.audited(ctx, auditApply, changes, nil, tx.mutations, nil)
+	u.metrics.SetLastApply(u.instance, u.now())
+	u.metrics.SetCacheRows(u.instance, u.knownRecords.size())
+
+	return nil
+}
+
+// disown forgets the owners of deleted endpoints. The rows are already gone, so failures are only logged.
+func (u Unbound) disown(ctx context.Context, deleted []*endpoint.Endpoint) {
+	for _, ep := range deleted {
+		if !managedType(ep.RecordType) {
+			continue
+		}
+		if err := u.registry.Disown(ctx, ownershipKey(ep.DNSName, ep.RecordType)); err != nil {
+			u.logger.WarnContext(ctx, "unable to disown deleted endpoint",
+				slog.String("endpoint", ep.DNSName),
+				slog.Any("error", err))
+		}
+	}
+}
+
+// description is the opnsense description for the rows of ep, recording its ownership in the registry.
+// The created time and comment are kept from previous, the description currently on the rows.
+func (u Unbound) description(ctx context.Context, ep *endpoint.Endpoint, previous string) (string, error) {
+	meta, err := ParseMetadata(previous)
+	if err != nil {
+		meta = Metadata{}
+	}
+	if meta.Created.IsZero() {
+		meta.Created = u.now().UTC().Truncate(time.Second)
+	}
+	meta.RecordType = ep.RecordType
+	meta.Deleted = time.Time{}
+	meta.setHeritage(u.knownRecords.heritage(ep.DNSName, ep.RecordType))
+
+	description, err := u.registry.Own(ctx, ownershipKey(ep.DNSName, ep.RecordType), meta)
+	if err != nil {
+		return "", fmt.Errorf("own %q: %w", ep.DNSName, err)
+	}
+	return description, nil
+}
+
+func (u Unbound) applyChanges(ctx context.Context, tx *journal, changes *plan.Changes) error {
+	if err := u.deleteEndpoints(ctx, tx, changes.Delete); err != nil {
 		return fmt.Errorf("plan delete: %w", err)
 	}
 
-	if err := u.createEndpoints(ctx, append(changes.Create, changes.UpdateNew...)); err != nil {
+	if err := u.updateEndpoints(ctx, tx, changes.UpdateOld, changes.UpdateNew); err != nil {
+		return fmt.Errorf("plan update: %w", err)
+	}
+
+	if err := u.createEndpoints(ctx, tx, changes.Create); err != nil {
 		return fmt.Errorf("plan create: %w", err)
 	}
 
@@ -147,17 +377,20 @@ func (u Unbound) ApplyChanges(ctx context.Context, changes *plan.Changes) error
 // unnecessary (potentially failing) changes. It may also modify other fields, add, or remove
 // Endpoints. It is permitted to modify the supplied endpoints.
 func (u Unbound) AdjustEndpoints(endpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
-	return endpoints, nil
+	return canonicalEndpoints(endpoints), nil
 }
 
 func (u Unbound) GetDomainFilter() endpoint.DomainFilter {
 	return u.domainFilter
 }
 
-func (u Unbound) createEndpoints(ctx context.Context, endpoints []*endpoint.Endpoint) error {
-	for _, ep := range endpoints {
+// createEndpoints creates overrides before aliases, so a CNAME may target an override created by the same plan.
+func (u Unbound) createEndpoints(ctx context.Context, tx *journal, endpoints []*endpoint.Endpoint) error {
+	overrides, aliases := partitionAliases(endpoints)
+
+	for _, ep := range overrides {
 		if supportedType(ep.RecordType) {
-			if err := u.createEndpoint(ctx, ep); err != nil {
+			if err := u.createEndpoint(ctx, tx, ep); err != nil {
 				return fmt.Errorf("create endpoint: %w", err)
 			}
 		} else {
@@ -167,17 +400,33 @@ func (u Unbound) createEndpoints(ctx context.Context, endpoints []*endpoint.Endp
 		}
 	}
 
+	for _, ep := range aliases {
+		if !u.aliasable(ep) {
+			u.logger.WarnContext(ctx, "skipping create, CNAME target is not a host override",
+				slog.Any("endpoint", ep))
+
+			continue
+		}
+		if err := u.createEndpoint(ctx, tx, ep); err != nil {
+			return fmt.Errorf("create alias: %w", err)
+		}
+	}
+
 	return nil
 }
 
-func (u Unbound) createEndpoint(ctx context.Context, endpoint *endpoint.Endpoint) error {
-	requestDatas, err := u.createRequestJSON(endpoint)
+func (u Unbound) createEndpoint(ctx context.Context, tx *journal, endpoint *endpoint.Endpoint) error {
+	description, err := u.description(ctx, endpoint, "")
 	if err != nil {
-		return fmt.Errorf("create endpoint: %w", err)
+		return err
 	}
 
-	for _, data := range requestDatas {
-		if err := u.createTarget(ctx, data, endpoint); err != nil {
+	for _, target := range endpoint.Targets {
+		record, err := u.targetRecord(endpoint, target, description)
+		if err != nil {
+			return err
+		}
+		if err := u.createTarget(ctx, tx, record); err != nil {
 			return err
 		}
 	}
@@ -185,101 +434,257 @@ func (u Unbound) createEndpoint(ctx context.Context, endpoint *endpoint.Endpoint
 	return nil
 }
 
-func (u Unbound) createTarget(ctx context.Context, data []byte, endpoint *endpoint.Endpoint) error {
-	u.logger.InfoContext(ctx, "creating endpoint", slog.String("data", string(data)))
+func (u Unbound) createTarget(ctx context.Context, tx *journal, record Record) error {
+	if before, ok := u.knownRecords.softDeletedRow(record); ok {
+		u.logger.InfoContext(ctx, "enabling soft deleted endpoint",
+			slog.String("endpoint", record.DNSName()),
+			slog.String("uuid", before.UUID))
+
+		return u.enableRow(ctx, tx, before, record)
+	}
 
-	req, err := u.postAPIRequest(ctx,
-		u.baseURL+AddOverrideEndpoint,
-		data)
+	uuid, err := u.addRecord(ctx, record)
 	if err != nil {
-		return fmt.Errorf("create endpoint request: %w", err)
+		return err
 	}
 
-	resp, err := u.client.Do(req)
-	if resp != nil {
-		defer resp.Body.Close()
+	record.UUID = uuid
+	tx.created(record)
+
+	return nil
+}
+
+// setTarget rewrites the row behind previous in place, journaling the row as it was.
+func (u Unbound) setTarget(ctx context.Context, tx *journal, endpoint *endpoint.Endpoint, previous rowRef, record Record) error {
+	before := u.capturedRecord(endpoint, previous)
+	if err := u.setRecord(ctx, previous.UUID, record); err != nil {
+		return err
+	}
+
+	tx.updated(before)
+
+	return nil
+}
+
+// deleteTarget deletes the row behind row, journaling the row as it was. With soft delete the row
+// is disabled instead.
+func (u Unbound) deleteTarget(ctx context.Context, tx *journal, endpoint *endpoint.Endpoint, row rowRef) error {
+	before := u.capturedRecord(endpoint, row)
+	if u.softDelete.enabled {
+		return u.disableRow(ctx, tx, before)
+	}
+
+	if err := u.deleteRow(ctx, before); err != nil {
+		return err
 	}
 
+	tx.deleted(before)
+
+	return nil
+}
+
+// addRecord creates record in opnsense and returns the uuid opnsense assigned to it.
+func (u Unbound) addRecord(ctx context.Context, record Record) (string, error) {
+	apiPath, data, err := u.saveRequest(record, AddOverrideEndpoint, AddAliasEndpoint)
 	if err != nil {
-		return fmt.Errorf("create http do: %w", err)
+		return "", fmt.Errorf("create endpoint: %w", err)
 	}
 
-	body := u.responseBody(ctx, AddOverrideEndpoint, resp.Body)
+	u.logger.InfoContext(ctx, "creating endpoint", slog.String("data", string(data)))
 
-	if resp.StatusCode != http.StatusOK {
-		u.logger.InfoContext(ctx, "response status not OK",
-			slog.Any("status", resp.Status),
-			slog.String("endpoint", endpoint.DNSName),
-		)
+	err = u.retry(ctx, func(ctx context.Context, attempt int) error {
+		// creates are not idempotent, a failed attempt may still have added the row
+		if attempt > 1 {
+			uuid, found, err := u.savedRow(ctx, record)
+			if err != nil || found {
+				record.UUID = uuid
+				return err
+			}
+		}
 
-		return fmt.Errorf("response status: %v: %w", resp.StatusCode, ErrRequestFailed)
+		result, err := u.saveRecord(ctx, apiPath, data, record.DNSName())
+		record.UUID = result.UUID
+		return err
+	})
+	if err != nil {
+		return "", err
 	}
 
-	return u.checkResponse(ctx, body, CreateOpSuccessResponse)
+	u.knownRecords.putRow(record)
+
+	return record.UUID, nil
 }
 
-func (u Unbound) deleteEndpoints(ctx context.Context, endpoints []*endpoint.Endpoint) error {
-	for _, endpoint := range endpoints {
-		if err := u.deleteEndpoint(ctx, endpoint); err != nil {
-			return fmt.Errorf("%q: %w", endpoint.DNSName, err)
+// savedRow looks for a row matching record, returning its uuid if found.
+func (u Unbound) savedRow(ctx context.Context, record Record) (string, bool, error) {
+	searchPhrase := strings.TrimSpace(record.Hostname + " " + record.Domain)
+
+	if isAlias(record) {
+		aliases, err := u.searchAliases(ctx, searchPhrase)
+		if err != nil {
+			return "", false, fmt.Errorf("find created alias: %w", err)
 		}
+		host, _ := u.knownRecords.overrideUUID(record.Server)
+		for _, alias := range aliases {
+			if strings.EqualFold(alias.DNSName(), record.DNSName()) &&
+				alias.Description == record.Description &&
+				(alias.Host == host || strings.EqualFold(strings.TrimSuffix(alias.Host, "."), record.Server)) {
+				return alias.UUID, true, nil
+			}
+		}
+		return "", false, nil
 	}
 
-	return nil
+	rows, err := u.searchRows(ctx, searchPhrase)
+	if err != nil {
+		return "", false, fmt.Errorf("find created override: %w", err)
+	}
+	for _, row := range rows {
+		if strings.EqualFold(row.DNSName(), record.DNSName()) &&
+			row.RecordType() == record.RecordType() &&
+			row.Target() == record.Target() &&
+			row.Description == record.Description {
+			return row.UUID, true, nil
+		}
+	}
+	return "", false, nil
 }
 
-func (u Unbound) deleteEndpoint(ctx context.Context, endpoint *endpoint.Endpoint) error {
-	urlPath := path.Join(DelOverrideEndpoint, endpoint.SetIdentifier)
-	url := u.baseURL + urlPath
-	u.logger.InfoContext(ctx, "delete endpoint request", slog.String("url", url))
+// setRecord rewrites the existing row uuid in place.
+func (u Unbound) setRecord(ctx context.Context, uuid string, record Record) error {
+	apiPath, data, err := u.saveRequest(record, SetOverrideEndpoint, SetAliasEndpoint)
+	if err != nil {
+		return fmt.Errorf("update endpoint: %w", err)
+	}
 
-	reqBody := emptyJSON()
+	u.logger.InfoContext(ctx, "updating endpoint", slog.String("uuid", uuid), slog.String("data", string(data)))
 
-	req, err := u.apiRequest(ctx, http.MethodPost, url, reqBody)
+	err = u.retry(ctx, func(ctx context.Context, _ int) error {
+		_, err := u.saveRecord(ctx, path.Join(apiPath, uuid), data, record.DNSName())
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("delete request: %w", err)
+		return err
 	}
 
-	u.logger.InfoContext(ctx, "making delete request", slog.String("url", req.URL.String()))
+	record.UUID = uuid
+	u.knownRecords.putRow(record)
+
+	return nil
+}
 
-	resp, err := u.client.Do(req)
-	if resp != nil {
-		defer resp.Body.Close()
+// saveRequest picks the override or alias api path for record and builds the request body.
+func (u Unbound) saveRequest(record Record, overridePath string, aliasPath string) (string, []byte, error) {
+	if isAlias(record) {
+		data, err := u.aliasJSON(record)
+		return aliasPath, data, err
 	}
 
+	data, err := recordJSON(record)
+	return overridePath, data, err
+}
+
+// saveRecord posts a host override or alias to apiPath. Both add and set respond with "saved".
+// The request is sent once, callers decide whether it is safe to retry.
+func (u Unbound) saveRecord(ctx context.Context, apiPath string, data []byte, dnsName string) (OperationResponse, error) {
+	resp, body, err := u.send(ctx, http.MethodPost, u.baseURL+apiPath, data)
 	if err != nil {
-		return fmt.Errorf("delete http do: %w", err)
+		return OperationResponse{}, fmt.Errorf("save http do: %w", err)
 	}
 
-	body := u.responseBody(ctx, DelOverrideEndpoint, resp.Body)
+	logResponse(ctx, u.logger, apiPath, body)
 
 	if resp.StatusCode != http.StatusOK {
-		u.logger.InfoContext(ctx, "delete response status not OK",
+		u.logger.InfoContext(ctx, "response status not OK",
 			slog.Any("status", resp.Status),
-			slog.String("endpoint", endpoint.DNSName))
+			slog.String("endpoint", dnsName),
+		)
 
-		return fmt.Errorf("response status: %v: %w", resp.StatusCode, ErrRequestFailed)
+		return OperationResponse{}, fmt.Errorf("response status: %v: %w", resp.StatusCode, ErrRequestFailed)
 	}
 
-	return u.checkResponse(ctx, body, DeleteOpSuccessResponse)
+	return u.checkResponse(ctx, body, CreateOpSuccessResponse)
+}
+
+// deleteEndpoints deletes aliases before the overrides they may be attached to.
+func (u Unbound) deleteEndpoints(ctx context.Context, tx *journal, endpoints []*endpoint.Endpoint) error {
+	overrides, aliases := partitionAliases(endpoints)
+	for _, endpoint := range append(aliases, overrides...) {
+		rows := rowRefs(endpoint)
+		if len(rows) == 0 {
+			u.logger.DebugContext(ctx, "skipping delete, no opnsense rows",
+				slog.String("type", endpoint.RecordType),
+				slog.Any("endpoint", endpoint))
+
+			continue
+		}
+
+		for _, row := range rows {
+			if err := u.deleteTarget(ctx, tx, endpoint, row); err != nil {
+				return fmt.Errorf("%q: %w", endpoint.DNSName, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteRow deletes the override or alias row behind record.
+func (u Unbound) deleteRow(ctx context.Context, record Record) error {
+	apiPath := DelOverrideEndpoint
+	if isAlias(record) {
+		apiPath = DelAliasEndpoint
+	}
+
+	if err := u.deleteUUID(ctx, apiPath, record.UUID, record.DNSName()); err != nil {
+		return err
+	}
+
+	u.knownRecords.removeRow(record.UUID)
+
+	return nil
+}
+
+// deleteUUID posts a delete for the row uuid to apiPath. name is only used for logging.
+// A retried delete finding the row gone counts as deleted, as an earlier attempt may have deleted it.
+func (u Unbound) deleteUUID(ctx context.Context, apiPath string, uuid string, name string) error {
+	urlPath := path.Join(apiPath, uuid)
+	url := u.baseURL + urlPath
+	u.logger.InfoContext(ctx, "making delete request", slog.String("url", url))
+
+	return u.retry(ctx, func(ctx context.Context, attempt int) error {
+		resp, body, err := u.send(ctx, http.MethodPost, url, emptyJSON())
+		if err != nil {
+			return fmt.Errorf("delete http do: %w", err)
+		}
+
+		logResponse(ctx, u.logger, apiPath, body)
+
+		if resp.StatusCode != http.StatusOK {
+			u.logger.InfoContext(ctx, "delete response status not OK",
+				slog.Any("status", resp.Status),
+				slog.String("endpoint", name))
+
+			return fmt.Errorf("response status: %v: %w", resp.StatusCode, ErrRequestFailed)
+		}
+
+		result, err := u.checkResponse(ctx, body, DeleteOpSuccessResponse)
+		if err != nil && attempt > 1 && result.Result == NotFoundResponse {
+			return nil
+		}
+		return err
+	})
 }
 
 // reconfigure calls the same endpoint as the "apply" button in the UI.
 func (u Unbound) reconfigure(ctx context.Context) error {
 	url := u.baseURL + ApplyChangesEndpoint
-	req, err := u.postAPIRequest(ctx, url, emptyJSON())
-	if err != nil {
-		return fmt.Errorf("reconfigure request create: %w", err)
-	}
-	resp, err := u.client.Do(req)
-	if resp != nil && resp.Body != nil {
-		defer resp.Body.Close()
-	}
+	resp, body, err := u.call(ctx, http.MethodPost, url, emptyJSON())
 	if err != nil {
 		return fmt.Errorf("reconfigure: %w", err)
 	}
 
-	body := u.responseBody(ctx, ApplyChangesEndpoint, resp.Body)
+	logResponse(ctx, u.logger, ApplyChangesEndpoint, body)
 
 	if resp.StatusCode != http.StatusOK {
 		u.logger.ErrorContext(ctx, "failed to apply reconfigure",
@@ -288,76 +693,72 @@ func (u Unbound) reconfigure(ctx context.Context) error {
 		)
 		return fmt.Errorf("response status: %v: %w", resp.StatusCode, ErrRequestFailed)
 	}
-	return u.checkResponse(ctx, body, "")
-}
-
-func (u Unbound) marshalEndpoints(ctx context.Context, resp *http.Response) ([]*endpoint.Endpoint, error) {
-	body := resp.Body
-	defer body.Close()
-
-	buff, err := io.ReadAll(body)
-	if err != nil {
-		return nil, fmt.Errorf("body read err: %w", err)
-	}
-
-	u.logger.DebugContext(ctx,
-		"received response from opnsense",
-		slog.String("status", resp.Status),
-		slog.String("body", string(buff)))
-
-	searchResp := &SearchHostResp{}
-	if err := json.Unmarshal(buff, searchResp); err != nil {
-		return nil, fmt.Errorf("unmarshal resp: %w", err)
-	}
-
-	u.logger.DebugContext(ctx, "unmarshalled successfully", slog.Any("searchResp", searchResp))
-
-	return searchResp.ToEndpoints(), nil
+	_, err = u.checkResponse(ctx, body, "")
+	return err
 }
 
 func basicAuthEncoding(creds string) string {
 	return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds))
 }
 
-func (u Unbound) createRequestJSON(endpoint *endpoint.Endpoint) ([][]byte, error) {
-	out := make([][]byte, 0, len(endpoint.Targets))
-	dnsSplit := strings.Split(endpoint.DNSName, ".")
-	for _, target := range endpoint.Targets {
-		record := Record{
-			Enabled:     "1",
-			Hostname:    dnsSplit[0],
-			Domain:      strings.Join(dnsSplit[1:], "."),
-			Server:      target,
-			Rr:          endpoint.RecordType,
-			Description: u.knownRecords.createDescription(endpoint.DNSName),
-		}
-		request := &AddOverrideRequest{
-			Host: record,
-		}
+// targetRecord is the opnsense row for a single target of endpoint.
+func (u Unbound) targetRecord(endpoint *endpoint.Endpoint, target string, description string) (Record, error) {
+	hostname, domain := u.splitter.split(endpoint.DNSName)
+	record := Record{
+		Enabled:     "1",
+		Hostname:    hostname,
+		Domain:      domain,
+		Rr:          endpoint.RecordType,
+		Description: description,
+	}
+	return record.withTarget(target)
+}
 
-		jsonOut, err := json.Marshal(request)
-		if err != nil {
-			return nil, fmt.Errorf("marshal: %w", err)
-		}
-		out = append(out, jsonOut)
+// capturedRecord is the row behind row as it exists in opnsense, used to undo a mutation.
+// Rows seen by the last read are preferred, otherwise the row is rebuilt from endpoint.
+func (u Unbound) capturedRecord(endpoint *endpoint.Endpoint, row rowRef) Record {
+	if record, ok := u.knownRecords.row(row.UUID); ok {
+		record.Rr = record.RecordType()
+		return record
 	}
 
-	return out, nil
-}
+	target := row.Target
+	if target == "" && len(endpoint.Targets) == 1 {
+		target = endpoint.Targets[0]
+	}
 
-func logResponse(ctx context.Context, logger *slog.Logger, requestEndpoint string, body []byte) {
-	logger.InfoContext(ctx, "response", slog.String("request", requestEndpoint), slog.String("body", string(body)))
+	hostname, domain := u.splitter.split(endpoint.DNSName)
+	record := Record{
+		UUID:        row.UUID,
+		Enabled:     "1",
+		Hostname:    hostname,
+		Domain:      domain,
+		Rr:          endpoint.RecordType,
+		Description: endpoint.Labels[DescriptionLabel],
+	}
+	if withTarget, err := record.withTarget(target); err == nil {
+		record = withTarget
+	}
+	return record
 }
 
-func (u Unbound) postAPIRequest(ctx context.Context, path string, body []byte) (*http.Request, error) {
-	req, err := u.apiRequest(ctx, http.MethodPost, path, body)
+// recordJSON is the add/set request body for record. The uuid travels in the url, not the body.
+func recordJSON(record Record) ([]byte, error) {
+	record.UUID = ""
+	request := &AddOverrideRequest{
+		Host: record,
+	}
+
+	jsonOut, err := json.Marshal(request)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("marshal: %w", err)
 	}
 
-	req.Header.Add("Content-Type", "application/json")
+	return jsonOut, nil
+}
 
-	return req, nil
+func logResponse(ctx context.Context, logger *slog.Logger, requestEndpoint string, body []byte) {
+	logger.InfoContext(ctx, "response", slog.String("request", requestEndpoint), slog.String("body", string(body)))
 }
 
 func (u Unbound) apiRequest(ctx context.Context, method string, path string, body []byte) (*http.Request, error) {
@@ -374,26 +775,15 @@ func (u Unbound) apiRequest(ctx context.Context, method string, path string, bod
 	return req, nil
 }
 
-func (u Unbound) responseBody(ctx context.Context, requestID string, reader io.Reader) []byte {
-	body, err := io.ReadAll(reader)
-	if err != nil {
-		u.logger.InfoContext(ctx, "failed to read response body", slog.Any("error", err))
-	}
-
-	logResponse(ctx, u.logger, requestID, body)
-
-	return body
-}
-
-func (u Unbound) checkResponse(ctx context.Context, body []byte, wantResult string) error {
-	result := &OperationResponse{}
-	if err := json.Unmarshal(body, result); err != nil || result.Result != wantResult {
+func (u Unbound) checkResponse(ctx context.Context, body []byte, wantResult string) (OperationResponse, error) {
+	result := OperationResponse{}
+	if err := json.Unmarshal(body, &result); err != nil || result.Result != wantResult {
 		u.logger.WarnContext(ctx, "operation was not a success", slog.Any("error", err), slog.Any("response", result.Result))
 
-		return fmt.Errorf("response %q: %w", result.Result, ErrRequestFailed)
+		return result, fmt.Errorf("response %q: %w", result.Result, ErrRequestFailed)
 	}
 
-	return nil
+	return result, nil
 }
 
 func emptyJSON() []byte {
@@ -1,8 +1,10 @@
 package unbound
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
@@ -11,6 +13,7 @@ import (
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/MrUsefull/boundation/internal/config"
 	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
@@ -24,6 +27,11 @@ func GetTestLogger() *slog.Logger {
 	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug, AddSource: true}))
 }
 
+// testClock is the fixed clock of providers under test, so created times are predictable.
+func testClock() time.Time {
+	return time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+}
+
 type response struct {
 	body string
 	code int
@@ -42,6 +50,7 @@ func TestUnbound_Records(t *testing.T) {
 		name       string
 		fields     fields
 		serverResp response
+		aliasResp  string
 		want       []*endpoint.Endpoint
 		wantErr    error
 	}{
@@ -69,12 +78,12 @@ func TestUnbound_Records(t *testing.T) {
 			},
 			want: []*endpoint.Endpoint{
 				{
-					DNSName:       "foo.example.domain",
-					Targets:       endpoint.NewTargets("10.0.0.4"),
-					RecordType:    "A",
-					SetIdentifier: "some-uuid-here",
+					DNSName:    "foo.example.domain",
+					Targets:    endpoint.NewTargets("10.0.0.4"),
+					RecordType: "A",
 					Labels: map[string]string{
-						"description": appendToDescription("aGVyaXRhZ2U9ZXh0ZXJuYWwtZG5zLGV4dGVybmFsLWRucy9vd25lcj1kZWZhdWx0LGV4dGVybmFsLWRucy9yZXNvdXJjZT1pbmdyZXNzL2plbGx5YmVsbHkvamVsbHliZWxseQ=="),
+						RowsLabel:        "some-uuid-here=10.0.0.4",
+						DescriptionLabel: appendToDescription("aGVyaXRhZ2U9ZXh0ZXJuYWwtZG5zLGV4dGVybmFsLWRucy9vd25lcj1kZWZhdWx0LGV4dGVybmFsLWRucy9yZXNvdXJjZT1pbmdyZXNzL2plbGx5YmVsbHkvamVsbHliZWxseQ=="),
 					},
 				},
 				{
@@ -89,6 +98,130 @@ func TestUnbound_Records(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Multiple targets are merged",
+			fields: fields{
+				logger: GetTestLogger(),
+				cfg: &config.Config{
+					Opnsense: config.Opnsense{
+						Creds: "foo:bar",
+					},
+				},
+			},
+			serverResp: response{
+				body: `{"Rows": [
+	{"uuid": "uuid-2", "hostname": "foo", "domain": "example.domain", "rr": "A (Ipv4 Address)", "Server": "10.0.0.5"},
+	{"uuid": "uuid-3", "hostname": "bar", "domain": "example.domain", "rr": "AAAA (Ipv6 Address)", "Server": "fd00::1"},
+	{"uuid": "uuid-1", "hostname": "foo", "domain": "example.domain", "rr": "A (Ipv4 Address)", "Server": "10.0.0.4"}
+  ]}`,
+				code: http.StatusOK,
+			},
+			want: []*endpoint.Endpoint{
+				{
+					DNSName:    "foo.example.domain",
+					Targets:    endpoint.NewTargets("10.0.0.4", "10.0.0.5"),
+					RecordType: endpoint.RecordTypeA,
+					Labels: map[string]string{
+						RowsLabel: "uuid-1=10.0.0.4,uuid-2=10.0.0.5",
+					},
+				},
+				{
+					DNSName:    "bar.example.domain",
+					Targets:    endpoint.NewTargets("fd00::1"),
+					RecordType: endpoint.RecordTypeAAAA,
+					Labels: map[string]string{
+						RowsLabel: "uuid-3=fd00::1",
+					},
+				},
+			},
+		},
+		{
+			name: "MX priority and host",
+			fields: fields{
+				logger: GetTestLogger(),
+				cfg: &config.Config{
+					Opnsense: config.Opnsense{
+						Creds: "foo:bar",
+					},
+				},
+			},
+			serverResp: response{
+				body: `{"rows": [
+	{"uuid": "uuid-2", "hostname": "relay", "domain": "example.domain", "rr": "MX (Mail server)", "mxprio": "20", "mx": "backup.example.domain", "server": ""},
+	{"uuid": "uuid-1", "hostname": "relay", "domain": "example.domain", "rr": "MX (Mail server)", "mxprio": "10", "mx": "mail.example.domain", "server": ""}
+  ]}`,
+				code: http.StatusOK,
+			},
+			want: []*endpoint.Endpoint{
+				{
+					DNSName:    "relay.example.domain",
+					Targets:    endpoint.NewTargets("10 mail.example.domain", "20 backup.example.domain"),
+					RecordType: endpoint.RecordTypeMX,
+					Labels: map[string]string{
+						RowsLabel: "uuid-1=10 mail.example.domain,uuid-2=20 backup.example.domain",
+					},
+				},
+			},
+		},
+		{
+			name: "Aliases are CNAMEs to their override",
+			fields: fields{
+				logger: GetTestLogger(),
+				cfg: &config.Config{
+					Opnsense: config.Opnsense{
+						Creds: "foo:bar",
+					},
+				},
+			},
+			serverResp: response{
+				body: `{"rows": [
+	{"uuid": "uuid-1", "hostname": "lb", "domain": "example.domain", "rr": "A (Ipv4 Address)", "server": "10.0.0.4"}
+  ]}`,
+				code: http.StatusOK,
+			},
+			aliasResp: `{"rows": [
+	{"uuid": "alias-1", "host": "uuid-1", "hostname": "www", "domain": "example.domain", "enabled": "1", "description": "Managed by K8s external-dns aGVyaXRhZ2U9ZXh0ZXJuYWwtZG5z"},
+	{"uuid": "alias-2", "host": "lb.example.domain", "hostname": "api", "domain": "example.domain", "enabled": "1", "description": ""},
+	{"uuid": "alias-3", "host": "unknown-uuid", "hostname": "lost", "domain": "example.domain", "enabled": "1", "description": ""}
+  ]}`,
+			want: []*endpoint.Endpoint{
+				{
+					DNSName:    "lb.example.domain",
+					Targets:    endpoint.NewTargets("10.0.0.4"),
+					RecordType: endpoint.RecordTypeA,
+					Labels: map[string]string{
+						RowsLabel: "uuid-1=10.0.0.4",
+					},
+				},
+				{
+					DNSName:    "www.example.domain",
+					Targets:    endpoint.NewTargets("lb.example.domain"),
+					RecordType: endpoint.RecordTypeCNAME,
+					Labels: map[string]string{
+						RowsLabel:        "alias-1=lb.example.domain",
+						DescriptionLabel: appendToDescription("aGVyaXRhZ2U9ZXh0ZXJuYWwtZG5z"),
+					},
+				},
+				{
+					DNSName:    "www.example.domain",
+					RecordType: endpoint.RecordTypeTXT,
+					Targets:    endpoint.NewTargets("heritage=external-dns"),
+				},
+				{
+					DNSName:    "cname-www.example.domain",
+					RecordType: endpoint.RecordTypeTXT,
+					Targets:    endpoint.NewTargets("heritage=external-dns"),
+				},
+				{
+					DNSName:    "api.example.domain",
+					Targets:    endpoint.NewTargets("lb.example.domain"),
+					RecordType: endpoint.RecordTypeCNAME,
+					Labels: map[string]string{
+						RowsLabel: "alias-2=lb.example.domain",
+					},
+				},
+			},
+		},
 		{
 			name: "Bad status",
 			fields: fields{
@@ -131,7 +264,17 @@ func TestUnbound_Records(t *testing.T) {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == SearchAliasesEndpoint {
+					aliasResp := tt.aliasResp
+					if aliasResp == "" {
+						aliasResp = `{"rows": []}`
+					}
+					_, err := w.Write([]byte(aliasResp))
+					require.NoError(t, err)
+
+					return
+				}
 				w.WriteHeader(tt.serverResp.code)
 				_, err := w.Write([]byte(tt.serverResp.body))
 				require.NoError(t, err)
@@ -190,6 +333,8 @@ func TestUnbound_ApplyChanges(t *testing.T) {
 						DNSName:       "update.this",
 						Targets:       endpoint.NewTargets("4.3.2.1"),
 						SetIdentifier: "some-uuid-here",
+						RecordType:    "A",
+						Labels:        map[string]string{DescriptionLabel: "extdns/1 t=A c=2024-05-01T10:00:00Z"},
 					},
 				},
 				UpdateNew: []*endpoint.Endpoint{
@@ -209,7 +354,6 @@ func TestUnbound_ApplyChanges(t *testing.T) {
 				},
 			},
 			serverResps: []string{
-				testhelpers.DeleteSuccessServResp,
 				testhelpers.DeleteSuccessServResp,
 				testhelpers.CreateSuccessServResp,
 				testhelpers.CreateSuccessServResp,
@@ -217,14 +361,171 @@ func TestUnbound_ApplyChanges(t *testing.T) {
 			},
 			wantRequests: map[string][]string{
 				AddOverrideEndpoint: {
-					`{"host":{"hostname":"create","domain":"me","rr":"A","server":"1.2.3.4","enabled":"1","description":"Managed by K8s external-dns aGVyaXRhZ2U9ZXh0ZXJuYWwtZG5zLGV4dGVybmFsLWRucy9vd25lcj1kZWZhdWx0LGV4dGVybmFsLWRucy9yZXNvdXJjZT1pbmdyZXNzL2plbGx5YmVsbHkvamVsbHliZWxseQ=="}}`, //nolint:lll
-					`{"host":{"hostname":"update","domain":"this","rr":"A","server":"4.3.2.1","enabled":"1","description":"Managed by K8s external-dns "}}`,                                                                                                                                       //nolint:lll
+					`{"host":{"hostname":"create","domain":"me","rr":"A","server":"1.2.3.4","enabled":"1","description":"extdns/1 o=default r=ingress/jellybelly/jellybelly t=A c=2024-05-01T10:00:00Z"}}`, //nolint:lll
+				},
+				path.Join(SetOverrideEndpoint, "some-uuid-here"): {
+					`{"host":{"hostname":"update","domain":"this","rr":"A","server":"4.3.2.1","enabled":"1","description":"extdns/1 t=A c=2024-05-01T10:00:00Z"}}`,
 				},
 				path.Join(DelOverrideEndpoint, "delete-uuid-goes-here"): {`"{}"`},
-				path.Join(DelOverrideEndpoint, "some-uuid-here"):        {`"{}"`},
 				ApplyChangesEndpoint: {`"{}"`},
 			},
 		},
+		{
+			name: "Update - in place when target count matches",
+			changes: &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{
+					{
+						DNSName:    "update.this",
+						Targets:    endpoint.NewTargets("10.0.0.1", "10.0.0.2"),
+						RecordType: endpoint.RecordTypeA,
+						Labels: map[string]string{
+							RowsLabel:        "uuid-1=10.0.0.1,uuid-2=10.0.0.2",
+							DescriptionLabel: "extdns/1 t=A c=2024-05-01T10:00:00Z",
+						},
+					},
+				},
+				UpdateNew: []*endpoint.Endpoint{
+					{
+						DNSName:    "update.this",
+						Targets:    endpoint.NewTargets("10.0.0.1", "10.0.0.3"),
+						RecordType: endpoint.RecordTypeA,
+					},
+				},
+			},
+			serverResps: []string{
+				testhelpers.CreateSuccessServResp,
+				testhelpers.ReconfigureResp,
+			},
+			wantRequests: map[string][]string{
+				path.Join(SetOverrideEndpoint, "uuid-2"): {
+					`{"host":{"hostname":"update","domain":"this","rr":"A","server":"10.0.0.3","enabled":"1","description":"extdns/1 t=A c=2024-05-01T10:00:00Z"}}`,
+				},
+				ApplyChangesEndpoint: {`"{}"`},
+			},
+		},
+		{
+			name: "Update - target count shrinks",
+			changes: &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{
+					{
+						DNSName:    "update.this",
+						Targets:    endpoint.NewTargets("10.0.0.1", "10.0.0.2"),
+						RecordType: endpoint.RecordTypeA,
+						Labels: map[string]string{
+							RowsLabel:        "uuid-1=10.0.0.1,uuid-2=10.0.0.2",
+							DescriptionLabel: "extdns/1 t=A c=2024-05-01T10:00:00Z",
+						},
+					},
+				},
+				UpdateNew: []*endpoint.Endpoint{
+					{
+						DNSName:    "update.this",
+						Targets:    endpoint.NewTargets("10.0.0.2"),
+						RecordType: endpoint.RecordTypeA,
+					},
+				},
+			},
+			serverResps: []string{
+				testhelpers.DeleteSuccessServResp,
+				testhelpers.ReconfigureResp,
+			},
+			wantRequests: map[string][]string{
+				path.Join(DelOverrideEndpoint, "uuid-1"): {`"{}"`},
+				ApplyChangesEndpoint:                     {`"{}"`},
+			},
+		},
+		{
+			name: "Update - unpaired",
+			changes: &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{
+					{
+						DNSName:       "update.this",
+						Targets:       endpoint.NewTargets("4.3.2.1"),
+						SetIdentifier: "some-uuid-here",
+					},
+				},
+			},
+			serverResps:  []string{},
+			wantRequests: map[string][]string{},
+			wantErr:      ErrUnpairedUpdate,
+		},
+		{
+			name: "Update - misordered plan pairs by key",
+			changes: &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{
+					{
+						DNSName:    "b.this",
+						Targets:    endpoint.NewTargets("10.0.0.2"),
+						RecordType: endpoint.RecordTypeA,
+						Labels: map[string]string{
+							RowsLabel:        "uuid-b=10.0.0.2",
+							DescriptionLabel: "extdns/1 t=A c=2024-05-01T10:00:00Z",
+						},
+					},
+					{
+						DNSName:    "a.this",
+						Targets:    endpoint.NewTargets("10.0.0.1"),
+						RecordType: endpoint.RecordTypeA,
+						Labels: map[string]string{
+							RowsLabel:        "uuid-a=10.0.0.1",
+							DescriptionLabel: "extdns/1 t=A c=2024-05-01T10:00:00Z",
+						},
+					},
+				},
+				UpdateNew: []*endpoint.Endpoint{
+					{
+						DNSName:    "a.this",
+						Targets:    endpoint.NewTargets("10.0.1.1"),
+						RecordType: endpoint.RecordTypeA,
+					},
+					{
+						DNSName:    "b.this",
+						Targets:    endpoint.NewTargets("10.0.1.2"),
+						RecordType: endpoint.RecordTypeA,
+					},
+				},
+			},
+			serverResps: []string{
+				testhelpers.CreateSuccessServResp,
+				testhelpers.CreateSuccessServResp,
+				testhelpers.ReconfigureResp,
+			},
+			wantRequests: map[string][]string{
+				path.Join(SetOverrideEndpoint, "uuid-a"): {
+					`{"host":{"hostname":"a","domain":"this","rr":"A","server":"10.0.1.1","enabled":"1","description":"extdns/1 t=A c=2024-05-01T10:00:00Z"}}`,
+				},
+				path.Join(SetOverrideEndpoint, "uuid-b"): {
+					`{"host":{"hostname":"b","domain":"this","rr":"A","server":"10.0.1.2","enabled":"1","description":"extdns/1 t=A c=2024-05-01T10:00:00Z"}}`,
+				},
+				ApplyChangesEndpoint: {`"{}"`},
+			},
+		},
+		{
+			name: "Update - new endpoint without an old one",
+			changes: &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{
+					{
+						DNSName:    "a.this",
+						Targets:    endpoint.NewTargets("10.0.0.1"),
+						RecordType: endpoint.RecordTypeA,
+						Labels: map[string]string{
+							RowsLabel:        "uuid-a=10.0.0.1",
+							DescriptionLabel: "extdns/1 t=A c=2024-05-01T10:00:00Z",
+						},
+					},
+				},
+				UpdateNew: []*endpoint.Endpoint{
+					{
+						DNSName:    "b.this",
+						Targets:    endpoint.NewTargets("10.0.1.2"),
+						RecordType: endpoint.RecordTypeA,
+					},
+				},
+			},
+			serverResps:  []string{},
+			wantRequests: map[string][]string{},
+			wantErr:      ErrUnpairedUpdate,
+		},
 		{
 			name: "Create failure",
 			changes: &plan.Changes{
@@ -250,6 +551,8 @@ func TestUnbound_ApplyChanges(t *testing.T) {
 						DNSName:       "update.this",
 						Targets:       endpoint.NewTargets("4.3.2.1"),
 						SetIdentifier: "some-uuid-here",
+						RecordType:    "A",
+						Labels:        map[string]string{DescriptionLabel: "extdns/1 t=A c=2024-05-01T10:00:00Z"},
 					},
 				},
 				UpdateNew: []*endpoint.Endpoint{
@@ -270,18 +573,195 @@ func TestUnbound_ApplyChanges(t *testing.T) {
 			},
 			serverResps: []string{
 				testhelpers.DeleteSuccessServResp,
-				testhelpers.DeleteSuccessServResp,
+				testhelpers.CreateSuccessServResp,
 				testhelpers.CreateFailServeResp,
+				// rollback
+				testhelpers.CreateSuccessServResp,
+				testhelpers.CreateSuccessServResp,
+				testhelpers.ReconfigureResp,
 			},
 			wantRequests: map[string][]string{
 				path.Join(DelOverrideEndpoint, "delete-uuid-goes-here"): {`"{}"`},
-				path.Join(DelOverrideEndpoint, "some-uuid-here"):        {`"{}"`},
+				path.Join(SetOverrideEndpoint, "some-uuid-here"): {
+					`{"host":{"hostname":"update","domain":"this","rr":"A","server":"4.3.2.1","enabled":"1","description":"extdns/1 t=A c=2024-05-01T10:00:00Z"}}`,
+					`{"host":{"hostname":"update","domain":"this","rr":"A","server":"4.3.2.1","enabled":"1","description":"extdns/1 t=A c=2024-05-01T10:00:00Z"}}`,
+				},
 				AddOverrideEndpoint: {
-					`{"host":{"hostname":"create","domain":"me","rr":"A","server":"1.2.3.4","enabled":"1","description":"Managed by K8s external-dns aGVyaXRhZ2U9ZXh0ZXJuYWwtZG5zLGV4dGVybmFsLWRucy9vd25lcj1kZWZhdWx0LGV4dGVybmFsLWRucy9yZXNvdXJjZT1pbmdyZXNzL2plbGx5YmVsbHkvamVsbHliZWxseQ=="}}`, //nolint:lll
+					`{"host":{"hostname":"create","domain":"me","rr":"A","server":"1.2.3.4","enabled":"1","description":"extdns/1 o=default r=ingress/jellybelly/jellybelly t=A c=2024-05-01T10:00:00Z"}}`, //nolint:lll
+					`{"host":{"hostname":"delete","domain":"this","server":"5.6.7.8","enabled":"1","description":""}}`,
 				},
+				ApplyChangesEndpoint: {`"{}"`},
 			},
 			wantErr: ErrRequestFailed,
 		},
+		{
+			name: "Reconfigure failure rolls back creates",
+			changes: &plan.Changes{
+				Create: []*endpoint.Endpoint{
+					{
+						DNSName:    "create.me",
+						Targets:    endpoint.NewTargets("1.2.3.4"),
+						RecordType: "A",
+					},
+				},
+			},
+			serverResps: []string{
+				`{"result":"saved","uuid":"new-uuid"}`,
+				`{"result":"failed"}`,
+				// rollback
+				testhelpers.DeleteSuccessServResp,
+				testhelpers.ReconfigureResp,
+			},
+			wantRequests: map[string][]string{
+				AddOverrideEndpoint: {
+					`{"host":{"hostname":"create","domain":"me","rr":"A","server":"1.2.3.4","enabled":"1","description":"extdns/1 t=A c=2024-05-01T10:00:00Z"}}`,
+				},
+				path.Join(DelOverrideEndpoint, "new-uuid"): {`"{}"`},
+				ApplyChangesEndpoint:                       {`"{}"`, `"{}"`},
+			},
+			wantErr: ErrRolledBack,
+		},
+		{
+			name: "Rollback without uuid is incomplete",
+			changes: &plan.Changes{
+				Create: []*endpoint.Endpoint{
+					{
+						DNSName:    "create.me",
+						Targets:    endpoint.NewTargets("1.2.3.4", "5.6.7.8"),
+						RecordType: "A",
+					},
+				},
+			},
+			serverResps: []string{
+				testhelpers.CreateSuccessServResp,
+				testhelpers.CreateFailServeResp,
+				// rollback
+				testhelpers.ReconfigureResp,
+			},
+			wantRequests: map[string][]string{
+				AddOverrideEndpoint: {
+					`{"host":{"hostname":"create","domain":"me","rr":"A","server":"1.2.3.4","enabled":"1","description":"extdns/1 t=A c=2024-05-01T10:00:00Z"}}`,
+					`{"host":{"hostname":"create","domain":"me","rr":"A","server":"5.6.7.8","enabled":"1","description":"extdns/1 t=A c=2024-05-01T10:00:00Z"}}`,
+				},
+				ApplyChangesEndpoint: {`"{}"`},
+			},
+			wantErr: ErrRollbackIncomplete,
+		},
+		{
+			name: "Alias is attached to the override created before it",
+			changes: &plan.Changes{
+				Create: []*endpoint.Endpoint{
+					{
+						DNSName:    "www.me",
+						Targets:    endpoint.NewTargets("lb.me"),
+						RecordType: endpoint.RecordTypeCNAME,
+					},
+					{
+						DNSName:    "lb.me",
+						Targets:    endpoint.NewTargets("1.2.3.4"),
+						RecordType: endpoint.RecordTypeA,
+					},
+				},
+			},
+			serverResps: []string{
+				`{"result":"saved","uuid":"lb-uuid"}`,
+				`{"result":"saved","uuid":"www-uuid"}`,
+				testhelpers.ReconfigureResp,
+			},
+			wantRequests: map[string][]string{
+				AddOverrideEndpoint: {
+					`{"host":{"hostname":"lb","domain":"me","rr":"A","server":"1.2.3.4","enabled":"1","description":"extdns/1 t=A c=2024-05-01T10:00:00Z"}}`,
+				},
+				AddAliasEndpoint: {
+					`{"alias":{"host":"lb-uuid","hostname":"www","domain":"me","enabled":"1","description":"extdns/1 t=CNAME c=2024-05-01T10:00:00Z"}}`,
+				},
+				ApplyChangesEndpoint: {`"{}"`},
+			},
+		},
+		{
+			name: "MX target sets priority and host",
+			changes: &plan.Changes{
+				Create: []*endpoint.Endpoint{
+					{
+						DNSName:    "mx.me",
+						Targets:    endpoint.NewTargets("10 mail.me"),
+						RecordType: endpoint.RecordTypeMX,
+					},
+				},
+			},
+			serverResps: []string{
+				`{"result":"saved","uuid":"mx-uuid"}`,
+				testhelpers.ReconfigureResp,
+			},
+			wantRequests: map[string][]string{
+				AddOverrideEndpoint: {
+					`{"host":{"hostname":"mx","domain":"me","rr":"MX","server":"","mxprio":"10","mx":"mail.me","enabled":"1","description":"extdns/1 t=MX c=2024-05-01T10:00:00Z"}}`,
+				},
+				ApplyChangesEndpoint: {`"{}"`},
+			},
+		},
+		{
+			name: "Invalid MX target",
+			changes: &plan.Changes{
+				Create: []*endpoint.Endpoint{
+					{
+						DNSName:    "mx.me",
+						Targets:    endpoint.NewTargets("mail.me"),
+						RecordType: endpoint.RecordTypeMX,
+					},
+				},
+			},
+			serverResps:  []string{},
+			wantRequests: map[string][]string{},
+			wantErr:      ErrInvalidMXTarget,
+		},
+		{
+			name: "CNAME to a name without an override is skipped",
+			changes: &plan.Changes{
+				Create: []*endpoint.Endpoint{
+					{
+						DNSName:    "www.me",
+						Targets:    endpoint.NewTargets("elsewhere.example"),
+						RecordType: endpoint.RecordTypeCNAME,
+					},
+				},
+			},
+			serverResps: []string{
+				testhelpers.ReconfigureResp,
+			},
+			wantRequests: map[string][]string{
+				ApplyChangesEndpoint: {`"{}"`},
+			},
+		},
+		{
+			name: "Aliases are deleted before their override",
+			changes: &plan.Changes{
+				Delete: []*endpoint.Endpoint{
+					{
+						DNSName:    "lb.me",
+						Targets:    endpoint.NewTargets("1.2.3.4"),
+						RecordType: endpoint.RecordTypeA,
+						Labels:     map[string]string{RowsLabel: "lb-uuid=1.2.3.4", DescriptionLabel: "extdns/1 t=A"},
+					},
+					{
+						DNSName:    "www.me",
+						Targets:    endpoint.NewTargets("lb.me"),
+						RecordType: endpoint.RecordTypeCNAME,
+						Labels:     map[string]string{RowsLabel: "www-uuid=lb.me", DescriptionLabel: "extdns/1 t=CNAME"},
+					},
+				},
+			},
+			serverResps: []string{
+				testhelpers.DeleteSuccessServResp,
+				testhelpers.DeleteSuccessServResp,
+				testhelpers.ReconfigureResp,
+			},
+			wantRequests: map[string][]string{
+				path.Join(DelAliasEndpoint, "www-uuid"):   {`"{}"`},
+				path.Join(DelOverrideEndpoint, "lb-uuid"): {`"{}"`},
+				ApplyChangesEndpoint:                      {`"{}"`},
+			},
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -299,7 +779,7 @@ func TestUnbound_ApplyChanges(t *testing.T) {
 				},
 			}
 
-			u := New(server.Client(), cfg, GetTestLogger())
+			u := New(server.Client(), cfg, GetTestLogger(), WithClock(testClock))
 
 			assert.ErrorIs(t, u.ApplyChanges(ctx, tt.changes), tt.wantErr)
 			assert.Equal(t, tt.wantRequests, gotRequests)
@@ -307,6 +787,102 @@ func TestUnbound_ApplyChanges(t *testing.T) {
 	}
 }
 
+// cancellingTransport cancels the context of an apply once the response to its first change
+// has been read, as if the caller gave up partway through the plan.
+type cancellingTransport struct {
+	next   http.RoundTripper
+	cancel context.CancelFunc
+}
+
+func (c cancellingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := c.next.RoundTrip(r)
+	if err != nil || !strings.Contains(r.URL.Path, "HostOverride/") {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	c.cancel()
+
+	return resp, err
+}
+
+func TestUnbound_ApplyChangesCancelled(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(testhelpers.NewFakeOpnsense())
+	defer server.Close()
+
+	cfg := config.Config{Opnsense: config.Opnsense{BaseURL: server.URL, Creds: "foo:bar"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := server.Client()
+	client.Transport = cancellingTransport{next: client.Transport, cancel: cancel}
+	u := New(client, cfg, GetTestLogger(), WithClock(testClock))
+
+	require.NoError(t, u.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("keep.example.domain", endpoint.RecordTypeA, "10.0.0.1")},
+	}))
+	before, err := u.Records(context.Background())
+	require.NoError(t, err)
+	require.Len(t, before, 1)
+
+	// the delete goes through, then the context is cancelled before the create
+	err = u.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("new.example.domain", endpoint.RecordTypeA, "10.0.0.2")},
+		Delete: before,
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, err, ErrRolledBack)
+	assert.NotErrorIs(t, err, ErrRollbackIncomplete)
+
+	after, err := u.Records(context.Background())
+	require.NoError(t, err)
+	require.Len(t, after, 1)
+	assert.Equal(t, "keep.example.domain", after[0].DNSName)
+	assert.Equal(t, endpoint.NewTargets("10.0.0.1"), after[0].Targets)
+}
+
+func TestUnbound_AdjustEndpoints(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		endpoints []*endpoint.Endpoint
+		want      []*endpoint.Endpoint
+	}{
+		{
+			name:      "no endpoints",
+			endpoints: []*endpoint.Endpoint{},
+			want:      []*endpoint.Endpoint{},
+		},
+		{
+			name: "merges targets by name and type",
+			endpoints: []*endpoint.Endpoint{
+				endpoint.NewEndpointWithTTL("foo.example.domain", endpoint.RecordTypeA, 300, "10.0.0.5"),
+				endpoint.NewEndpoint("foo.example.domain", endpoint.RecordTypeAAAA, "fd00::1"),
+				endpoint.NewEndpoint("foo.example.domain", endpoint.RecordTypeA, "10.0.0.4", "10.0.0.5"),
+			},
+			want: []*endpoint.Endpoint{
+				endpoint.NewEndpoint("foo.example.domain", endpoint.RecordTypeA, "10.0.0.4", "10.0.0.5"),
+				endpoint.NewEndpoint("foo.example.domain", endpoint.RecordTypeAAAA, "fd00::1"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			u := New(http.DefaultClient, config.Config{}, GetTestLogger())
+			got, err := u.AdjustEndpoints(tt.endpoints)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
 func TestUnbound_GetDomainFilter(t *testing.T) {
 	t.Parallel()
 
@@ -356,14 +932,14 @@ func Test_EndToEnd(t *testing.T) {
 
 	wantDelete := make([]*endpoint.Endpoint, 0)
 	for _, endpoint := range got {
-		if strings.HasPrefix(endpoint.Labels["description"], DescriptionPrefix) {
+		if strings.HasPrefix(endpoint.Labels[DescriptionLabel], DescriptionPrefix) {
 			wantDelete = append(wantDelete, endpoint)
 		}
 	}
 
 	slog.Warn("found delete endpoints", slog.Any("num", len(wantDelete)))
 
-	assert.NoError(t, subject.deleteEndpoints(context.Background(), wantDelete))
+	assert.NoError(t, subject.deleteEndpoints(context.Background(), newJournal(), wantDelete))
 }
 
 func assertHasEndpoint(tb testing.TB, dnsName string, target string, endpoints []*endpoint.Endpoint) {
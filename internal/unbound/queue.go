@@ -0,0 +1,25 @@
+package unbound
+
+import (
+	"context"
+	"fmt"
+)
+
+// queue lets a single caller at a time read or write opnsense through the provider, so two plans
+// never interleave their delete, create and reconfigure requests, and a read never replaces the
+// cache halfway through a plan. Waiting callers are served in arrival order.
+type queue chan struct{}
+
+func newQueue() queue {
+	return make(queue, 1)
+}
+
+// enter waits for the caller's turn, giving up when ctx is done. The returned func ends the turn.
+func (q queue) enter(ctx context.Context) (func(), error) {
+	select {
+	case q <- struct{}{}:
+		return func() { <-q }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for queue: %w", ctx.Err())
+	}
+}
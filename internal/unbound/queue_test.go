@@ -0,0 +1,82 @@
+package unbound
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func Test_queue_enter(t *testing.T) {
+	t.Parallel()
+	q := newQueue()
+
+	leave, err := q.enter(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = q.enter(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	leave()
+	leave, err = q.enter(context.Background())
+	require.NoError(t, err)
+	leave()
+}
+
+func TestUnbound_concurrentApplies(t *testing.T) {
+	t.Parallel()
+
+	opnsense := testhelpers.NewFakeOpnsense()
+	server := httptest.NewServer(opnsense)
+	defer server.Close()
+
+	cfg := config.Config{Opnsense: config.Opnsense{BaseURL: server.URL, Creds: "foo:bar"}}
+	u := New(server.Client(), cfg, GetTestLogger())
+
+	const plans = 10
+	var wg sync.WaitGroup
+	for i := 0; i < plans; i++ {
+		i := i
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, u.ApplyChanges(context.Background(), &plan.Changes{
+				Create: []*endpoint.Endpoint{
+					endpoint.NewEndpoint(fmt.Sprintf("host%d.example.domain", i), endpoint.RecordTypeA, "10.0.0.1", "10.0.0.2"),
+				},
+			}))
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := u.Records(context.Background())
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// every plan adds both of its rows and reconfigures before the next one starts
+	applies := make([]string, 0, plans*3)
+	for _, request := range opnsense.Requests() {
+		if request == AddOverrideEndpoint || request == ApplyChangesEndpoint {
+			applies = append(applies, request)
+		}
+	}
+	require.Len(t, applies, plans*3)
+	for i := 0; i < len(applies); i += 3 {
+		assert.Equal(t, []string{AddOverrideEndpoint, AddOverrideEndpoint, ApplyChangesEndpoint}, applies[i:i+3])
+	}
+
+	got, err := u.Records(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, got, plans)
+}
@@ -0,0 +1,118 @@
+package unbound
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ServiceStatusEndpoint reports whether unbound is running. It is the cheapest authenticated
+// call, used to check opnsense can be reached with the configured credentials.
+const ServiceStatusEndpoint = apiPrefix + "/service/status"
+
+// Circuit breaker states reported by a readiness check.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// Readiness is the outcome of a readiness check of an opnsense instance.
+type Readiness struct {
+	Instance string `json:"instance"`
+	// Ready is set when opnsense answered the check and the circuit breaker is not open.
+	Ready bool `json:"ready"`
+	// Reachable is set when opnsense answered at all, Authenticated when it accepted the credentials.
+	Reachable     bool  `json:"reachable"`
+	Authenticated bool  `json:"authenticated"`
+	LatencyMS     int64 `json:"latencyMs"`
+	// Circuit is the state of the circuit breaker, CircuitClosed, CircuitOpen or CircuitHalfOpen.
+	Circuit   string        `json:"circuit"`
+	LastApply *ApplyOutcome `json:"lastApply,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// ApplyOutcome is the result of the last plan applied.
+type ApplyOutcome struct {
+	At    time.Time `json:"at"`
+	Error string    `json:"error,omitempty"`
+}
+
+// applyStatus holds the outcome of the last plan. It is shared by every copy of an Unbound.
+type applyStatus struct {
+	mu      sync.Mutex
+	outcome *ApplyOutcome
+}
+
+func (s *applyStatus) record(at time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outcome = &ApplyOutcome{At: at}
+	if err != nil {
+		s.outcome.Error = err.Error()
+	}
+}
+
+func (s *applyStatus) last() *ApplyOutcome {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.outcome == nil {
+		return nil
+	}
+	outcome := *s.outcome
+	return &outcome
+}
+
+// Ready checks opnsense answers an authenticated request. The request is sent once, through the
+// circuit breaker, without waiting for any plan being applied.
+func (u Unbound) Ready(ctx context.Context) []Readiness {
+	readiness := Readiness{
+		Instance:  u.instance,
+		LastApply: u.applied.last(),
+	}
+
+	start := time.Now()
+	resp, _, err := u.send(ctx, http.MethodGet, u.baseURL+ServiceStatusEndpoint, nil)
+	readiness.LatencyMS = time.Since(start).Milliseconds()
+	readiness.Circuit = u.breaker.state()
+
+	switch {
+	case resp == nil:
+		readiness.Error = err.Error()
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		readiness.Reachable = true
+		readiness.Error = resp.Status
+	case resp.StatusCode != http.StatusOK:
+		readiness.Reachable = true
+		readiness.Authenticated = true
+		readiness.Error = resp.Status
+	default:
+		readiness.Reachable = true
+		readiness.Authenticated = true
+		readiness.Ready = readiness.Circuit != CircuitOpen
+	}
+
+	return []Readiness{readiness}
+}
+
+// readyAll checks every instance.
+func readyAll(ctx context.Context, instances []Instance) []Readiness {
+	out := make([]Readiness, 0, len(instances))
+	for _, instance := range instances {
+		out = append(out, instance.Ready(ctx)...)
+	}
+	return out
+}
+
+// Ready checks every instance answers an authenticated request.
+func (f FanOut) Ready(ctx context.Context) []Readiness {
+	return readyAll(ctx, f.instances)
+}
+
+// Ready checks every routed instance answers an authenticated request.
+func (r Router) Ready(ctx context.Context) []Readiness {
+	return readyAll(ctx, r.instances)
+}
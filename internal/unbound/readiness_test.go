@@ -0,0 +1,119 @@
+package unbound
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestUnbound_Ready(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		statuses []int
+		// tripped fails a request first, opening the breaker
+		tripped bool
+		closed  bool
+		want    Readiness
+	}{
+		{
+			name: "ready",
+			want: Readiness{Ready: true, Reachable: true, Authenticated: true, Circuit: CircuitClosed},
+		},
+		{
+			name:     "wrong credentials",
+			statuses: []int{http.StatusUnauthorized},
+			want:     Readiness{Reachable: true, Circuit: CircuitClosed, Error: "401 Unauthorized"},
+		},
+		{
+			name:     "server error",
+			statuses: []int{http.StatusInternalServerError},
+			want:     Readiness{Reachable: true, Authenticated: true, Circuit: CircuitClosed, Error: "500 Internal Server Error"},
+		},
+		{
+			name:     "circuit open",
+			statuses: []int{http.StatusBadGateway},
+			tripped:  true,
+			want:     Readiness{Circuit: CircuitOpen},
+		},
+		{
+			name:   "unreachable",
+			closed: true,
+			want:   Readiness{Circuit: CircuitClosed},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			opnsense := &flakyOpnsense{next: testhelpers.NewFakeOpnsense(), statuses: tt.statuses}
+			server := httptest.NewServer(opnsense)
+			defer server.Close()
+			if tt.closed {
+				server.Close()
+			}
+
+			cfg := testRetryConfig(server.URL)
+			cfg.Retry.Attempts = 1
+			if tt.tripped {
+				cfg.Breaker = config.Breaker{Failures: 1, Cooldown: time.Hour}
+			}
+			subject := New(server.Client(), cfg, GetTestLogger())
+			if tt.tripped {
+				require.ErrorIs(t, subject.reconfigure(context.Background()), ErrTransient)
+			}
+
+			got := subject.Ready(context.Background())
+			require.Len(t, got, 1)
+			assert.Equal(t, config.PrimaryInstance, got[0].Instance)
+			assert.GreaterOrEqual(t, got[0].LatencyMS, int64(0))
+			if tt.want.Reachable {
+				assert.Equal(t, tt.want.Error, got[0].Error)
+			} else {
+				assert.NotEmpty(t, got[0].Error)
+			}
+			got[0].Instance, got[0].LatencyMS, got[0].Error, tt.want.Error = "", 0, "", ""
+			assert.Equal(t, tt.want, got[0])
+		})
+	}
+}
+
+func TestUnbound_ReadyLastApply(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(testhelpers.NewFakeOpnsense())
+	defer server.Close()
+
+	ctx := context.Background()
+	cfg := config.Config{
+		Opnsense:   config.Opnsense{BaseURL: server.URL, Creds: "foo:bar"},
+		Safeguards: config.Safeguards{MaxDeletions: 1},
+	}
+	subject := New(server.Client(), cfg, GetTestLogger(), WithClock(testClock))
+	assert.Nil(t, subject.Ready(ctx)[0].LastApply)
+
+	create := &plan.Changes{Create: []*endpoint.Endpoint{
+		endpoint.NewEndpoint("one.example.domain", endpoint.RecordTypeA, "10.0.0.1"),
+		endpoint.NewEndpoint("two.example.domain", endpoint.RecordTypeA, "10.0.0.2"),
+	}}
+	require.NoError(t, subject.ApplyChanges(ctx, create))
+	assert.Equal(t, &ApplyOutcome{At: testClock()}, subject.Ready(ctx)[0].LastApply)
+
+	current, err := subject.Records(ctx)
+	require.NoError(t, err)
+	err = subject.ApplyChanges(ctx, &plan.Changes{Delete: current})
+	require.True(t, errors.Is(err, ErrTooManyDeletions))
+	assert.Equal(t, &ApplyOutcome{At: testClock(), Error: err.Error()}, subject.Ready(ctx)[0].LastApply)
+}
@@ -1,90 +1,284 @@
 package unbound
 
 import (
-	"encoding/base64"
+	"context"
 	"fmt"
-	"log/slog"
+	"sort"
+	"strconv"
 	"strings"
 
 	"sigs.k8s.io/external-dns/endpoint"
 )
 
+const (
+	// DescriptionLabel holds the raw opnsense description of a record.
+	DescriptionLabel = "description"
+	// RowsLabel holds the opnsense rows backing an endpoint, one uuid=target pair per row.
+	// OPNsense stores one row per target, while external-dns expects one endpoint per name and type.
+	RowsLabel = "opnsense-rows"
+
+	rowSeparator    = ","
+	rowUUIDSplitter = "="
+)
+
+// SearchHostResp is a page of a searchHostOverride response.
 type SearchHostResp struct {
-	Rows []Record `json:"rows"`
+	Rows     []Record `json:"rows"`
+	RowCount int      `json:"rowCount"`
+	Total    int      `json:"total"`
+	Current  int      `json:"current"`
 }
 
+// ToEndpoints groups the rows by dns name and record type, producing a single
+// endpoint with every target. The uuid of each row is kept in the RowsLabel.
+// Ownership is read from the description, as stored by the DescriptionRegistry.
 func (shr SearchHostResp) ToEndpoints() []*endpoint.Endpoint {
-	out := make([]*endpoint.Endpoint, 0, len(shr.Rows))
-
-	for _, row := range shr.Rows {
-		marshalledEndpoint := &endpoint.Endpoint{
-			DNSName:       fmt.Sprintf("%s.%s", row.Hostname, row.Domain),
-			Targets:       endpoint.NewTargets(row.Server),
-			RecordType:    strings.Split(row.Rr, " ")[0], // likely bug here
-			SetIdentifier: row.UUID,
+	// the description registry never fails
+	out, _ := endpointsWithOwners(context.Background(), shr.Rows, DescriptionRegistry{}, txtNames{})
+	return out
+}
+
+// endpointsWithOwners groups rows into endpoints like ToEndpoints, adding the ownership TXT
+// endpoints, named by names, of every row owned according to registry.
+func endpointsWithOwners(ctx context.Context, rows []Record, registry Registry, names txtNames) ([]*endpoint.Endpoint, error) {
+	order := make([]endpoint.EndpointKey, 0, len(rows))
+	groups := make(map[endpoint.EndpointKey][]Record)
+
+	for _, row := range rows {
+		key := endpoint.EndpointKey{
+			DNSName:    row.DNSName(),
+			RecordType: row.RecordType(),
 		}
-		if row.Description != "" {
-			marshalledEndpoint.Labels = map[string]string{
-				"description": row.Description,
-			}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
 		}
+		groups[key] = append(groups[key], row)
+	}
+
+	out := make([]*endpoint.Endpoint, 0, len(order))
+	for _, key := range order {
+		marshalledEndpoint, description := groupToEndpoint(key, groups[key])
 		out = append(out, marshalledEndpoint)
-		if txtEndpoints, ok := endpointsFromBase64Description(marshalledEndpoint.DNSName, row.Description); ok {
-			out = append(out, txtEndpoints...)
+
+		heritage, ok, err := registry.Owner(ctx, ownershipKey(key.DNSName, key.RecordType), description)
+		if err != nil {
+			return nil, fmt.Errorf("owner of %q: %w", marshalledEndpoint.DNSName, err)
+		}
+		if ok {
+			out = append(out, names.endpoints(marshalledEndpoint.DNSName, key.RecordType, heritage)...)
 		}
 	}
 
-	return out
+	return out, nil
 }
 
-func endpointsFromBase64Description(dnsName string, description string) ([]*endpoint.Endpoint, bool) {
-	if !strings.HasPrefix(description, DescriptionPrefix) {
-		return nil, false
+func groupToEndpoint(key endpoint.EndpointKey, rows []Record) (*endpoint.Endpoint, string) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Target() < rows[j].Target()
+	})
+
+	refs := make([]rowRef, 0, len(rows))
+	targets := make([]string, 0, len(rows))
+	description := ""
+	for _, row := range rows {
+		refs = append(refs, rowRef{UUID: row.UUID, Target: row.Target()})
+		targets = append(targets, row.Target())
+		if description == "" {
+			description = row.Description
+		}
 	}
-	stripped := strings.TrimSpace(strings.Replace(description, DescriptionPrefix, "", 1))
-	decoded, err := base64.StdEncoding.DecodeString(stripped)
-	if err != nil {
-		slog.Error("unable to base64 decode txt records", slog.Any("error", err))
-		return nil, false
-	}
-	foundTxtRecords := []*endpoint.Endpoint{
-		{
-			DNSName:    dnsName,
-			Targets:    endpoint.NewTargets(string(decoded)),
-			RecordType: endpoint.RecordTypeTXT,
-		},
-		{
-			DNSName:    "a-" + dnsName,
-			Targets:    endpoint.NewTargets(string(decoded)),
-			RecordType: endpoint.RecordTypeTXT,
+
+	marshalledEndpoint := &endpoint.Endpoint{
+		DNSName:    key.DNSName,
+		Targets:    endpoint.NewTargets(targets...),
+		RecordType: key.RecordType,
+		Labels: map[string]string{
+			RowsLabel: encodeRowRefs(refs),
 		},
 	}
-	return foundTxtRecords, true
+	if description != "" {
+		marshalledEndpoint.Labels[DescriptionLabel] = description
+	}
+
+	return marshalledEndpoint, description
+}
+
+// rowRef points at a single opnsense row backing one target of an endpoint.
+type rowRef struct {
+	UUID   string
+	Target string
+}
+
+func encodeRowRefs(refs []rowRef) string {
+	encoded := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		encoded = append(encoded, ref.UUID+rowUUIDSplitter+ref.Target)
+	}
+	return strings.Join(encoded, rowSeparator)
+}
+
+// rowRefs returns the opnsense rows backing ep. Endpoints without the RowsLabel
+// fall back to the SetIdentifier, which older versions used to store the uuid.
+func rowRefs(ep *endpoint.Endpoint) []rowRef {
+	encoded := ep.Labels[RowsLabel]
+	if encoded == "" {
+		if ep.SetIdentifier == "" {
+			return nil
+		}
+		return []rowRef{{UUID: ep.SetIdentifier}}
+	}
+
+	out := make([]rowRef, 0, strings.Count(encoded, rowSeparator)+1)
+	for _, pair := range strings.Split(encoded, rowSeparator) {
+		uuid, target, _ := strings.Cut(pair, rowUUIDSplitter)
+		if uuid == "" {
+			continue
+		}
+		out = append(out, rowRef{UUID: uuid, Target: target})
+	}
+	return out
+}
+
+// canonicalEndpoints merges endpoints sharing a dns name, record type and set identifier
+// into a single endpoint with sorted, unique targets. This mirrors the shape Records returns.
+func canonicalEndpoints(endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+	out := make([]*endpoint.Endpoint, 0, len(endpoints))
+	merged := make(map[endpoint.EndpointKey]*endpoint.Endpoint, len(endpoints))
+
+	for _, ep := range endpoints {
+		if existing, ok := merged[ep.Key()]; ok {
+			existing.Targets = append(existing.Targets, ep.Targets...)
+			continue
+		}
+		merged[ep.Key()] = ep
+		out = append(out, ep)
+	}
+
+	for _, ep := range out {
+		ep.DNSName = strings.TrimSuffix(ep.DNSName, ".")
+		if managedType(ep.RecordType) {
+			// unbound overrides and aliases have no ttl
+			ep.RecordTTL = 0
+		}
+		if ep.RecordType == endpoint.RecordTypeCNAME {
+			for i, target := range ep.Targets {
+				ep.Targets[i] = strings.TrimSuffix(target, ".")
+			}
+		}
+		ep.Targets = uniqueTargets(ep.Targets)
+	}
+
+	return out
+}
+
+func uniqueTargets(targets endpoint.Targets) endpoint.Targets {
+	seen := make(map[string]struct{}, len(targets))
+	out := make(endpoint.Targets, 0, len(targets))
+	for _, target := range targets {
+		if _, ok := seen[target]; ok {
+			continue
+		}
+		seen[target] = struct{}{}
+		out = append(out, target)
+	}
+	sort.Strings(out)
+	return out
 }
 
 type Record struct {
-	UUID        string `json:"uuid,omitempty"`
-	Hostname    string `json:"hostname"`
-	Domain      string `json:"domain"`
-	Rr          string `json:"rr,omitempty"`
-	Server      string `json:"server"`
+	UUID     string `json:"uuid,omitempty"`
+	Hostname string `json:"hostname"`
+	Domain   string `json:"domain"`
+	Rr       string `json:"rr,omitempty"`
+	Server   string `json:"server"`
+	// MXPrio and MX are only set on MX records, which have no Server.
+	MXPrio      string `json:"mxprio,omitempty"`
+	MX          string `json:"mx,omitempty"`
 	Enabled     string `json:"enabled"`
 	Description string `json:"description"`
 }
 
+// DNSName is the name of the record. Apex records have an empty hostname.
 func (r Record) DNSName() string {
-	return fmt.Sprintf("%v.%v", r.Hostname, r.Domain)
+	return joinDNSName(r.Hostname, r.Domain)
+}
+
+// RecordType is the bare record type. Search responses describe the type, eg "A (IPv4 address)".
+func (r Record) RecordType() string {
+	return strings.Split(r.Rr, " ")[0]
+}
+
+// Target is the endpoint target of the record. MX records use the external-dns "priority host" form.
+func (r Record) Target() string {
+	if r.RecordType() != endpoint.RecordTypeMX {
+		return r.Server
+	}
+	if r.MX == "" {
+		return ""
+	}
+	return r.MXPrio + " " + r.MX
+}
+
+// withTarget sets target on the fields of r matching its record type.
+func (r Record) withTarget(target string) (Record, error) {
+	if r.RecordType() != endpoint.RecordTypeMX {
+		r.Server = target
+		return r, nil
+	}
+
+	prio, host, err := parseMXTarget(target)
+	if err != nil {
+		return r, err
+	}
+	r.Server = ""
+	r.MXPrio = prio
+	r.MX = host
+	return r, nil
+}
+
+// parseMXTarget splits an external-dns MX target, eg "10 mail.example.com", into priority and host.
+func parseMXTarget(target string) (string, string, error) {
+	fields := strings.Fields(target)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("%q: %w", target, ErrInvalidMXTarget)
+	}
+	if _, err := strconv.ParseUint(fields[0], 10, 16); err != nil {
+		return "", "", fmt.Errorf("%q priority: %w", target, ErrInvalidMXTarget)
+	}
+	return fields[0], fields[1], nil
 }
 
 type AddOverrideRequest struct {
 	Host Record `json:"host"`
 }
 
+// managedType reports whether recordType is stored in opnsense, as an override or an alias.
+func managedType(recordType string) bool {
+	return supportedType(recordType) || recordType == endpoint.RecordTypeCNAME
+}
+
+// addressType reports whether recordType is an address override, which aliases can attach to.
+func addressType(recordType string) bool {
+	return recordType == endpoint.RecordTypeA || recordType == endpoint.RecordTypeAAAA
+}
+
+// supportedType reports whether recordType is stored as a host override.
 func supportedType(recordType string) bool {
 	switch recordType {
-	case endpoint.RecordTypeA, endpoint.RecordTypeAAAA:
+	case endpoint.RecordTypeA, endpoint.RecordTypeAAAA, endpoint.RecordTypeMX:
 		return true
 	default:
 		return false
 	}
 }
+
+// managedCounts counts the live rows carrying external-dns metadata by record type.
+func managedCounts(rows []Record) map[string]int {
+	counts := make(map[string]int)
+	for _, row := range liveRows(rows) {
+		if _, err := ParseMetadata(row.Description); err == nil {
+			counts[row.RecordType()]++
+		}
+	}
+	return counts
+}
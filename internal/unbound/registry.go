@@ -0,0 +1,102 @@
+package unbound
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+const (
+	// DescriptionRegistryType stores ownership in the description metadata of each record.
+	DescriptionRegistryType = "description"
+	// FileRegistryType stores ownership in a local json file, leaving the rest of the metadata in the description.
+	FileRegistryType = "file"
+)
+
+var ErrUnknownRegistry = errors.New("unknown registry type")
+
+// Registry stores the external-dns ownership, the heritage TXT value, of the records this provider manages.
+// opnsense has no TXT records, so ownership has to live either on the record itself or somewhere else.
+type Registry interface {
+	// Own records the ownership in meta for key and returns the description to write on its rows.
+	Own(ctx context.Context, key endpoint.EndpointKey, meta Metadata) (string, error)
+	// Owner returns the heritage of key given the description read from its rows, and whether it is owned.
+	Owner(ctx context.Context, key endpoint.EndpointKey, description string) (string, bool, error)
+	// Disown forgets the owner of key once its rows have been deleted.
+	Disown(ctx context.Context, key endpoint.EndpointKey) error
+}
+
+// restorableRegistry is implemented by registries keeping ownership outside opnsense. Own is called
+// before the rows are written, so a failed apply has to put the ownership back along with the rows.
+type restorableRegistry interface {
+	// snapshot copies the ownership, nil if it cannot be read.
+	snapshot() map[endpoint.EndpointKey]string
+	// restore replaces the ownership with a snapshot.
+	restore(owners map[endpoint.EndpointKey]string) error
+}
+
+// NewRegistry creates the registry selected in cfg. An empty type is the description registry.
+func NewRegistry(cfg config.Registry) (Registry, error) {
+	switch cfg.Type {
+	case "", DescriptionRegistryType:
+		return DescriptionRegistry{}, nil
+	case FileRegistryType:
+		return NewFileRegistry(cfg.Path), nil
+	default:
+		return nil, fmt.Errorf("%q: %w", cfg.Type, ErrUnknownRegistry)
+	}
+}
+
+// DescriptionRegistry writes the ownership into the description metadata, which opnsense
+// limits to 255 characters.
+type DescriptionRegistry struct{}
+
+var _ Registry = DescriptionRegistry{}
+
+func (DescriptionRegistry) Own(_ context.Context, _ endpoint.EndpointKey, meta Metadata) (string, error) {
+	return meta.String(), nil
+}
+
+func (DescriptionRegistry) Owner(_ context.Context, _ endpoint.EndpointKey, description string) (string, bool, error) {
+	meta, err := ParseMetadata(description)
+	if err != nil {
+		return "", false, nil
+	}
+	heritage := meta.Heritage()
+	return heritage, heritage != "", nil
+}
+
+func (DescriptionRegistry) Disown(context.Context, endpoint.EndpointKey) error {
+	return nil
+}
+
+func heritageFromDescription(description string) (string, bool) {
+	if !strings.HasPrefix(description, DescriptionPrefix) {
+		return "", false
+	}
+	stripped := strings.TrimSpace(strings.Replace(description, DescriptionPrefix, "", 1))
+	decoded, err := base64.StdEncoding.DecodeString(stripped)
+	if err != nil {
+		slog.Error("unable to base64 decode txt records", slog.Any("error", err))
+		return "", false
+	}
+	return string(decoded), true
+}
+
+func appendToDescription(toAppend string) string {
+	return fmt.Sprintf("%v %v", DescriptionPrefix, toAppend)
+}
+
+// ownershipKey is the registry key of a record. Ownership is tracked per dns name and record type.
+func ownershipKey(dnsName string, recordType string) endpoint.EndpointKey {
+	return endpoint.EndpointKey{
+		DNSName:    strings.ToLower(dnsName),
+		RecordType: recordType,
+	}
+}
@@ -0,0 +1,195 @@
+package unbound
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// FileRegistry keeps ownership in a local json file keyed by dns name and record type, so the
+// heritage is not bound by the description length limit. Records carry their metadata without
+// the ownership, which also marks them as managed.
+type FileRegistry struct {
+	path string
+
+	mu     sync.Mutex
+	loaded bool
+	owners map[endpoint.EndpointKey]string
+}
+
+var (
+	_ Registry           = &FileRegistry{}
+	_ restorableRegistry = &FileRegistry{}
+)
+
+// fileRegistryEntry is a single owned record in the registry file.
+type fileRegistryEntry struct {
+	DNSName    string `json:"dnsName"`
+	RecordType string `json:"recordType"`
+	Heritage   string `json:"heritage"`
+}
+
+// NewFileRegistry creates a registry stored at path. The file is read on first use and
+// created on the first write.
+func NewFileRegistry(path string) *FileRegistry {
+	return &FileRegistry{
+		path:   path,
+		owners: make(map[endpoint.EndpointKey]string),
+	}
+}
+
+// Own stores the heritage in meta for key. Unowned metadata leaves any existing owner in place.
+func (f *FileRegistry) Own(_ context.Context, key endpoint.EndpointKey, meta Metadata) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.load(); err != nil {
+		return "", err
+	}
+
+	if heritage := meta.Heritage(); heritage != "" && f.owners[key] != heritage {
+		f.owners[key] = heritage
+		if err := f.save(); err != nil {
+			return "", err
+		}
+	}
+
+	return meta.withoutOwnership().String(), nil
+}
+
+// Owner looks key up in the file. Managed records missing from the file fall back to the
+// description, so records written before switching registries keep their owner.
+func (f *FileRegistry) Owner(_ context.Context, key endpoint.EndpointKey, description string) (string, bool, error) {
+	meta, err := ParseMetadata(description)
+	if err != nil {
+		return "", false, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.load(); err != nil {
+		return "", false, err
+	}
+
+	if heritage, ok := f.owners[key]; ok {
+		return heritage, true, nil
+	}
+
+	heritage := meta.Heritage()
+	return heritage, heritage != "", nil
+}
+
+func (f *FileRegistry) Disown(_ context.Context, key endpoint.EndpointKey) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.load(); err != nil {
+		return err
+	}
+
+	if _, ok := f.owners[key]; !ok {
+		return nil
+	}
+
+	delete(f.owners, key)
+	return f.save()
+}
+
+func (f *FileRegistry) snapshot() map[endpoint.EndpointKey]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.load(); err != nil {
+		return nil
+	}
+
+	return maps.Clone(f.owners)
+}
+
+func (f *FileRegistry) restore(owners map[endpoint.EndpointKey]string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if maps.Equal(f.owners, owners) {
+		return nil
+	}
+
+	f.owners = maps.Clone(owners)
+	return f.save()
+}
+
+func (f *FileRegistry) load() error {
+	if f.loaded {
+		return nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		f.loaded = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read registry: %w", err)
+	}
+
+	entries := []fileRegistryEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("parse registry %v: %w", f.path, err)
+	}
+
+	for _, entry := range entries {
+		f.owners[endpoint.EndpointKey{DNSName: entry.DNSName, RecordType: entry.RecordType}] = entry.Heritage
+	}
+	f.loaded = true
+
+	return nil
+}
+
+// save writes the registry to a temporary file and renames it into place, so a crash
+// never leaves a partially written registry behind.
+func (f *FileRegistry) save() error {
+	entries := make([]fileRegistryEntry, 0, len(f.owners))
+	for key, heritage := range f.owners {
+		entries = append(entries, fileRegistryEntry{
+			DNSName:    key.DNSName,
+			RecordType: key.RecordType,
+			Heritage:   heritage,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].DNSName != entries[j].DNSName {
+			return entries[i].DNSName < entries[j].DNSName
+		}
+		return entries[i].RecordType < entries[j].RecordType
+	})
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal registry: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o750); err != nil {
+		return fmt.Errorf("registry dir: %w", err)
+	}
+
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write registry: %w", err)
+	}
+
+	if err := os.Rename(tmp, f.path); err != nil {
+		return fmt.Errorf("replace registry: %w", err)
+	}
+
+	return nil
+}
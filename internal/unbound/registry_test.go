@@ -0,0 +1,174 @@
+package unbound
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestNewRegistry(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		cfg     config.Registry
+		want    Registry
+		wantErr error
+	}{
+		{
+			name: "default",
+			want: DescriptionRegistry{},
+		},
+		{
+			name: "description",
+			cfg:  config.Registry{Type: DescriptionRegistryType},
+			want: DescriptionRegistry{},
+		},
+		{
+			name: "file",
+			cfg:  config.Registry{Type: FileRegistryType, Path: "owners.json"},
+			want: NewFileRegistry("owners.json"),
+		},
+		{
+			name:    "unknown",
+			cfg:     config.Registry{Type: "sqlite"},
+			wantErr: ErrUnknownRegistry,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := NewRegistry(tt.cfg)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFileRegistry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	registryPath := filepath.Join(t.TempDir(), "nested", "owners.json")
+	key := endpoint.EndpointKey{DNSName: "foo.example.domain", RecordType: endpoint.RecordTypeA}
+
+	registry := NewFileRegistry(registryPath)
+	description, err := registry.Own(ctx, key, Metadata{Owner: "default", RecordType: endpoint.RecordTypeA})
+	require.NoError(t, err)
+	assert.Equal(t, "extdns/1 t=A", description)
+
+	// a fresh registry reads what was saved
+	reloaded := NewFileRegistry(registryPath)
+	heritage, ok, err := reloaded.Owner(ctx, key, description)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "heritage=external-dns,external-dns/owner=default", heritage)
+
+	// unmanaged records are never owned
+	_, ok, err = reloaded.Owner(ctx, key, "added by hand")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// records written by the description registry keep their owner
+	legacy := appendToDescription(base64.StdEncoding.EncodeToString([]byte("heritage=external-dns,external-dns/owner=legacy")))
+	other := endpoint.EndpointKey{DNSName: "bar.example.domain", RecordType: endpoint.RecordTypeA}
+	heritage, ok, err = reloaded.Owner(ctx, other, legacy)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "heritage=external-dns,external-dns/owner=legacy", heritage)
+
+	require.NoError(t, reloaded.Disown(ctx, key))
+	_, ok, err = NewFileRegistry(registryPath).Owner(ctx, key, description)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestFileRegistry_corrupt(t *testing.T) {
+	t.Parallel()
+
+	registryPath := filepath.Join(t.TempDir(), "owners.json")
+	require.NoError(t, os.WriteFile(registryPath, []byte("{"), 0o600))
+
+	_, _, err := NewFileRegistry(registryPath).Owner(context.Background(), endpoint.EndpointKey{}, DescriptionPrefix)
+	assert.Error(t, err)
+}
+
+func TestUnbound_fileRegistry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	heritage := "heritage=external-dns,external-dns/owner=a-very-long-owner-id,external-dns/resource=ingress/default/app"
+	handler, gotRequests := testhelpers.TestHandler(t, []string{
+		`{"result":"saved","uuid":"uuid-1"}`,
+		testhelpers.ReconfigureResp,
+		`{"rows": [{"uuid": "uuid-1", "hostname": "app", "domain": "example.domain", "rr": "A", "server": "10.0.0.1", "description": "extdns/1 t=A c=2024-05-01T10:00:00Z"}]}`,
+		`{"rows": []}`,
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	cfg := config.Config{
+		Opnsense: config.Opnsense{BaseURL: server.URL, Creds: "foo:bar"},
+		Registry: config.Registry{Type: FileRegistryType, Path: filepath.Join(t.TempDir(), "owners.json")},
+	}
+	u := New(server.Client(), cfg, GetTestLogger(), WithClock(testClock))
+
+	require.NoError(t, u.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("app.example.domain", endpoint.RecordTypeA, "10.0.0.1"),
+			endpoint.NewEndpoint("app.example.domain", endpoint.RecordTypeTXT, heritage),
+		},
+	}))
+	assert.Equal(t, []string{
+		`{"host":{"hostname":"app","domain":"example.domain","rr":"A","server":"10.0.0.1","enabled":"1","description":"extdns/1 t=A c=2024-05-01T10:00:00Z"}}`,
+	}, gotRequests[AddOverrideEndpoint])
+
+	got, err := u.Records(ctx)
+	require.NoError(t, err)
+	require.Len(t, got, 3)
+	assert.Equal(t, endpoint.NewTargets(heritage), got[1].Targets)
+}
+
+func TestUnbound_fileRegistryRollback(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	heritage := "heritage=external-dns,external-dns/owner=default,external-dns/resource=ingress/default/app"
+	handler, _ := testhelpers.TestHandler(t, []string{
+		testhelpers.CreateFailServeResp,
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	registryPath := filepath.Join(t.TempDir(), "owners.json")
+	cfg := config.Config{
+		Opnsense: config.Opnsense{BaseURL: server.URL, Creds: "foo:bar"},
+		Registry: config.Registry{Type: FileRegistryType, Path: registryPath},
+	}
+	u := New(server.Client(), cfg, GetTestLogger(), WithClock(testClock))
+
+	err := u.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("app.example.domain", endpoint.RecordTypeA, "10.0.0.1"),
+			endpoint.NewEndpoint("app.example.domain", endpoint.RecordTypeTXT, heritage),
+		},
+	})
+	require.ErrorIs(t, err, ErrRequestFailed)
+
+	// the record was never written, so it is not owned
+	key := endpoint.EndpointKey{DNSName: "app.example.domain", RecordType: endpoint.RecordTypeA}
+	_, ok, err := NewFileRegistry(registryPath).Owner(ctx, key, "extdns/1 t=A c=2024-05-01T10:00:00Z")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,173 @@
+package unbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/MrUsefull/boundation/internal/config"
+)
+
+// retryPolicy is how transient failures are retried, see config.Retry.
+type retryPolicy struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+func newRetryPolicy(cfg config.Retry) retryPolicy {
+	return retryPolicy{
+		attempts:   max(cfg.Attempts, 1),
+		backoff:    cfg.Backoff,
+		maxBackoff: max(cfg.MaxBackoff, cfg.Backoff),
+	}
+}
+
+// delay is the wait after the given failed attempt: the backoff doubled for every earlier
+// attempt, capped at the max backoff, with half of it randomised so retries spread out.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	delay := p.backoff
+	for i := 1; i < attempt && delay < p.maxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.maxBackoff)
+
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + rand.Int63n(half+1)) //nolint:gosec // jitter does not need a secure source
+}
+
+// retry calls attempt until it succeeds, fails with an error that is not transient, or the
+// attempts run out, waiting between attempts. attempt is passed its 1 based attempt number.
+func (u Unbound) retry(ctx context.Context, attempt func(ctx context.Context, n int) error) error {
+	for n := 1; ; n++ {
+		err := attempt(ctx, n)
+		if err == nil || !errors.Is(err, ErrTransient) || n >= u.retries.attempts {
+			return err
+		}
+
+		delay := u.retries.delay(n)
+		u.logger.WarnContext(ctx, "retrying opnsense request",
+			slog.Int("attempt", n),
+			slog.Duration("delay", delay),
+			slog.Any("error", err))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// call sends an idempotent request, retrying transient failures.
+func (u Unbound) call(ctx context.Context, method string, url string, body []byte) (*http.Response, []byte, error) {
+	var (
+		resp *http.Response
+		data []byte
+	)
+	err := u.retry(ctx, func(ctx context.Context, _ int) error {
+		var err error
+		resp, data, err = u.send(ctx, method, url, body)
+		return err
+	})
+	return resp, data, err
+}
+
+// send makes a single api request through the circuit breaker and reads the response body.
+// Failures worth retrying wrap ErrTransient, 5xx responses are returned with the error.
+// In dry-run mode only searches are sent.
+func (u Unbound) send(ctx context.Context, method string, url string, body []byte) (*http.Response, []byte, error) {
+	if u.dryRun != nil && method != http.MethodGet {
+		return u.dryRun.respond(ctx, method, strings.TrimPrefix(url, u.baseURL), body)
+	}
+
+	req, err := u.apiRequest(ctx, method, url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	if err := u.breaker.allow(); err != nil {
+		return nil, nil, err
+	}
+
+	start := time.Now()
+	resp, err := u.client.Do(req)
+	// err is checked once the body is read too, a failed read is a failed call
+	defer func() {
+		failed := err != nil || resp == nil || resp.StatusCode != http.StatusOK
+		u.metrics.ObserveAPICall(u.instance, apiAction(strings.TrimPrefix(url, u.baseURL)), time.Since(start), failed)
+	}()
+	if err != nil {
+		if ctx.Err() != nil {
+			u.breaker.release()
+			return nil, nil, err
+		}
+		// no response at all, eg a dns, tls or connection failure
+		u.breaker.record(true)
+		if transientError(err) {
+			return nil, nil, fmt.Errorf("%w: %w", ErrTransient, err)
+		}
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			u.breaker.release()
+			return nil, nil, fmt.Errorf("read response: %w", err)
+		}
+		u.breaker.record(true)
+		return nil, nil, fmt.Errorf("read response: %w: %w", ErrTransient, err)
+	}
+
+	transient := transientStatus(resp.StatusCode)
+	u.breaker.record(transient)
+	if transient {
+		return resp, data, fmt.Errorf("response status: %v: %w: %w", resp.StatusCode, ErrRequestFailed, ErrTransient)
+	}
+
+	return resp, data, nil
+}
+
+// apiAction is the action of an api path, eg delHostOverride for
+// /api/unbound/settings/delHostOverride/<uuid>.
+func apiAction(apiPath string) string {
+	apiPath, _, _ = strings.Cut(apiPath, "?")
+	_, action, _ := strings.Cut(strings.TrimPrefix(apiPath, apiPrefix+"/"), "/")
+	action, _, _ = strings.Cut(action, "/")
+	return action
+}
+
+// transientError reports whether a request failed in a way that may succeed if tried again.
+func transientError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func transientStatus(status int) bool {
+	return status >= http.StatusInternalServerError || status == http.StatusTooManyRequests
+}
@@ -0,0 +1,286 @@
+package unbound
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func Test_retryPolicy_delay(t *testing.T) {
+	t.Parallel()
+
+	subject := newRetryPolicy(config.Retry{Attempts: 5, Backoff: time.Second, MaxBackoff: 5 * time.Second})
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: time.Second},
+		{attempt: 2, want: 2 * time.Second},
+		{attempt: 3, want: 4 * time.Second},
+		{attempt: 4, want: 5 * time.Second},
+		{attempt: 10, want: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		got := subject.delay(tt.attempt)
+		assert.GreaterOrEqual(t, got, tt.want/2, "attempt %d", tt.attempt)
+		assert.LessOrEqual(t, got, tt.want, "attempt %d", tt.attempt)
+	}
+}
+
+// flakyOpnsense answers with the statuses in order, then passes requests on to next.
+// Requests answered with a failure are still passed on when applied is set, as if opnsense
+// saved the change but the response was lost.
+type flakyOpnsense struct {
+	next    http.Handler
+	applied bool
+
+	mu       sync.Mutex
+	statuses []int
+	requests []string
+}
+
+func (f *flakyOpnsense) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.URL.Path)
+	status := 0
+	if len(f.statuses) > 0 {
+		status, f.statuses = f.statuses[0], f.statuses[1:]
+	}
+	f.mu.Unlock()
+
+	if status == 0 {
+		f.next.ServeHTTP(w, r)
+		return
+	}
+	if f.applied {
+		f.next.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	w.WriteHeader(status)
+}
+
+func (f *flakyOpnsense) Requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string{}, f.requests...)
+}
+
+func testRetryConfig(url string) config.Config {
+	return config.Config{
+		Opnsense: config.Opnsense{BaseURL: url, Creds: "foo:bar"},
+		Retry:    config.Retry{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}
+}
+
+func TestUnbound_reconfigureRetries(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantRequests int
+		wantErr      error
+	}{
+		{
+			name:         "succeeds first time",
+			wantRequests: 1,
+		},
+		{
+			name:         "retries server errors",
+			statuses:     []int{http.StatusBadGateway, http.StatusServiceUnavailable},
+			wantRequests: 3,
+		},
+		{
+			name:         "retries rate limiting",
+			statuses:     []int{http.StatusTooManyRequests},
+			wantRequests: 2,
+		},
+		{
+			name:         "gives up after attempts",
+			statuses:     []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			wantRequests: 3,
+			wantErr:      ErrTransient,
+		},
+		{
+			name:         "client errors are not retried",
+			statuses:     []int{http.StatusUnauthorized},
+			wantRequests: 1,
+			wantErr:      ErrRequestFailed,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			opnsense := &flakyOpnsense{next: testhelpers.NewFakeOpnsense(), statuses: tt.statuses}
+			server := httptest.NewServer(opnsense)
+			defer server.Close()
+
+			subject := New(server.Client(), testRetryConfig(server.URL), GetTestLogger())
+
+			err := subject.reconfigure(context.Background())
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Len(t, opnsense.Requests(), tt.wantRequests)
+		})
+	}
+}
+
+func TestUnbound_retryConnectionErrors(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(testhelpers.NewFakeOpnsense())
+	url := server.URL
+	server.Close()
+
+	subject := New(http.DefaultClient, testRetryConfig(url), GetTestLogger())
+
+	assert.ErrorIs(t, subject.reconfigure(context.Background()), ErrTransient)
+}
+
+func TestUnbound_addRecordChecksBeforeRetrying(t *testing.T) {
+	t.Parallel()
+
+	fake := testhelpers.NewFakeOpnsense()
+	opnsense := &flakyOpnsense{next: fake, statuses: []int{http.StatusBadGateway}, applied: true}
+	server := httptest.NewServer(opnsense)
+	defer server.Close()
+
+	subject := New(server.Client(), testRetryConfig(server.URL), GetTestLogger())
+
+	record, err := subject.targetRecord(endpoint.NewEndpoint("foo.example.domain", endpoint.RecordTypeA), "10.0.0.1", "")
+	require.NoError(t, err)
+
+	uuid, err := subject.addRecord(context.Background(), record)
+	require.NoError(t, err)
+	assert.Equal(t, "uuid-1", uuid)
+	// the row saved by the failed attempt is found rather than added again
+	assert.Equal(t, []string{AddOverrideEndpoint, SearchOverridesEndpoint}, opnsense.Requests())
+}
+
+func TestUnbound_circuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	opnsense := &flakyOpnsense{
+		next:     testhelpers.NewFakeOpnsense(),
+		statuses: []int{http.StatusBadGateway, http.StatusBadGateway},
+	}
+	server := httptest.NewServer(opnsense)
+	defer server.Close()
+
+	cfg := testRetryConfig(server.URL)
+	cfg.Retry.Attempts = 1
+	cfg.Breaker = config.Breaker{Failures: 2, Cooldown: time.Hour}
+	subject := New(server.Client(), cfg, GetTestLogger())
+
+	assert.ErrorIs(t, subject.reconfigure(context.Background()), ErrTransient)
+	// the readiness check is the second failure in a row
+	readiness := subject.Ready(context.Background())
+	require.Len(t, readiness, 1)
+	assert.False(t, readiness[0].Ready)
+	assert.Equal(t, CircuitOpen, readiness[0].Circuit)
+
+	// fails fast without contacting opnsense
+	assert.ErrorIs(t, subject.reconfigure(context.Background()), ErrCircuitOpen)
+	assert.Len(t, opnsense.Requests(), 2)
+}
+
+func TestUnbound_circuitBreakerOutcomes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		tls      bool
+		statuses []int
+		cancel   bool
+		wantOpen bool
+	}{
+		{
+			name:     "tls failure counts",
+			tls:      true,
+			wantOpen: true,
+		},
+		{
+			name:     "transient status counts",
+			statuses: []int{http.StatusBadGateway},
+			wantOpen: true,
+		},
+		{
+			name:     "rejected credentials show opnsense is up",
+			statuses: []int{http.StatusUnauthorized},
+		},
+		{
+			name:   "cancelled request is not counted",
+			cancel: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			opnsense := &flakyOpnsense{next: testhelpers.NewFakeOpnsense(), statuses: tt.statuses}
+			server := httptest.NewUnstartedServer(opnsense)
+			if tt.tls {
+				server.StartTLS()
+			} else {
+				server.Start()
+			}
+			defer server.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+
+			cfg := testRetryConfig(server.URL)
+			cfg.Retry.Attempts = 1
+			cfg.Breaker = config.Breaker{Failures: 1, Cooldown: time.Hour}
+			// the default client does not trust the test certificate
+			subject := New(http.DefaultClient, cfg, GetTestLogger())
+
+			assert.Error(t, subject.reconfigure(ctx))
+			want := CircuitClosed
+			if tt.wantOpen {
+				want = CircuitOpen
+			}
+			assert.Equal(t, want, subject.breaker.state())
+		})
+	}
+}
+
+func Test_apiAction(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		apiPath string
+		want    string
+	}{
+		{apiPath: SearchOverridesEndpoint + "?searchPhrase=host", want: "searchHostOverride"},
+		{apiPath: AddOverrideEndpoint, want: "addHostOverride"},
+		{apiPath: DelOverrideEndpoint + "some-uuid", want: "delHostOverride"},
+		{apiPath: ToggleOverrideEndpoint + "some-uuid/0", want: "toggleHostOverride"},
+		{apiPath: ApplyChangesEndpoint, want: "reconfigure"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.want, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, apiAction(tt.apiPath))
+		})
+	}
+}
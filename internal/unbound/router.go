@@ -0,0 +1,273 @@
+package unbound
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/MrUsefull/boundation/internal/audit"
+	"github.com/MrUsefull/boundation/internal/config"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+	"sigs.k8s.io/external-dns/provider"
+)
+
+// Router sends the records of each zone to the opnsense instance serving it, so a single
+// provider manages several sites. Records are merged from every routed instance.
+type Router struct {
+	// instances holds every routed instance, in config order.
+	instances []Instance
+	// routes holds the longest zone first, so the most specific route wins.
+	routes       []route
+	domainFilter endpoint.DomainFilter
+	logger       *slog.Logger
+}
+
+var _ provider.Provider = &Router{}
+
+type route struct {
+	zone     string
+	instance Instance
+}
+
+// NewRouter creates a Router over the instances named by cfg.Routes, all reached through client.
+// Each instance uses its routed zones as its domain filter, and the instances share a single
+// ownership registry and audit log.
+func NewRouter(client *http.Client, cfg config.Config, logger *slog.Logger, opts ...Opts) *Router {
+	registry, err := NewRegistry(cfg.Registry)
+	if err != nil {
+		logger.Error("using description registry", slog.Any("error", err))
+		registry = DescriptionRegistry{}
+	}
+	opts = append([]Opts{WithRegistry(registry), WithAuditLog(audit.New(cfg.Audit.Path))}, opts...)
+
+	zones := make(map[string][]string)
+	allZones := make([]string, 0, len(cfg.Routes))
+	for _, r := range cfg.Routes {
+		zone := strings.ToLower(strings.Trim(r.Zone, "."))
+		zones[r.Instance] = append(zones[r.Instance], zone)
+		allZones = append(allZones, zone)
+	}
+
+	router := &Router{
+		domainFilter: endpoint.NewDomainFilterWithExclusions(allZones, cfg.Exclude),
+		logger:       logger,
+	}
+	for _, instance := range cfg.Instances {
+		if len(zones[instance.Name]) == 0 {
+			continue
+		}
+
+		instanceCfg := cfg.ForInstance(instance)
+		instanceCfg.Registry = config.Registry{}
+		instanceCfg.Audit = config.Audit{}
+		instanceCfg.Filter = zones[instance.Name]
+		u := New(client, instanceCfg, logger.With(slog.String("instance", instance.Name)), opts...)
+		u.instance = instance.Name
+		routed := Instance{Name: instance.Name, Unbound: u}
+
+		router.instances = append(router.instances, routed)
+		for _, zone := range zones[instance.Name] {
+			router.routes = append(router.routes, route{zone: zone, instance: routed})
+		}
+	}
+
+	sort.SliceStable(router.routes, func(i, j int) bool {
+		return len(router.routes[i].zone) > len(router.routes[j].zone)
+	})
+
+	return router
+}
+
+// Records merges the records of every instance, keeping only the names routed to it. A read
+// failing on any instance fails the whole read, so external-dns never plans on partial records.
+func (r Router) Records(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	out := make([]*endpoint.Endpoint, 0)
+	for _, instance := range r.instances {
+		endpoints, err := instance.Records(ctx)
+		if err != nil {
+			return nil, instanceError(instance.Name, err)
+		}
+		out = append(out, r.routed(ctx, instance, endpoints)...)
+	}
+
+	return out, nil
+}
+
+// FindRecords looks each name up on the instance it is routed to. Names outside every zone are not found.
+func (r Router) FindRecords(ctx context.Context, dnsNames ...string) ([]*endpoint.Endpoint, error) {
+	names := r.byInstance(dnsNames)
+
+	out := make([]*endpoint.Endpoint, 0)
+	for _, instance := range r.instances {
+		if len(names[instance.Name]) == 0 {
+			continue
+		}
+
+		endpoints, err := instance.FindRecords(ctx, names[instance.Name]...)
+		if err != nil {
+			return nil, instanceError(instance.Name, err)
+		}
+		out = append(out, r.routed(ctx, instance, endpoints)...)
+	}
+
+	return out, nil
+}
+
+// Enable undoes the soft delete of each name on the instance it is routed to, returning the
+// number of rows enabled. A failing instance does not stop the rest.
+func (r Router) Enable(ctx context.Context, dnsNames ...string) (int, error) {
+	names := r.byInstance(dnsNames)
+
+	enabled := 0
+	var errs []error
+	for _, instance := range r.instances {
+		if len(names[instance.Name]) == 0 {
+			continue
+		}
+
+		n, err := instance.Enable(ctx, names[instance.Name]...)
+		if err != nil {
+			errs = append(errs, instanceError(instance.Name, err))
+		}
+		enabled += n
+	}
+
+	return enabled, errors.Join(errs...)
+}
+
+// Purge deletes the expired soft deleted rows of every instance, returning the number of rows
+// deleted. A failing instance does not stop the rest.
+func (r Router) Purge(ctx context.Context) (int, error) {
+	purged := 0
+	var errs []error
+	for _, instance := range r.instances {
+		n, err := instance.Purge(ctx)
+		if err != nil {
+			errs = append(errs, instanceError(instance.Name, err))
+		}
+		purged += n
+	}
+
+	return purged, errors.Join(errs...)
+}
+
+// ApplyChanges splits the plan by zone and applies each part to its instance. A failing instance
+// does not stop the rest, and every failure names its instance.
+func (r Router) ApplyChanges(ctx context.Context, changes *plan.Changes) error {
+	if !changes.HasChanges() {
+		r.logger.DebugContext(ctx, "no changes to apply")
+
+		return nil
+	}
+
+	split, err := r.split(ctx, changes)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, instance := range r.instances {
+		instanceChanges, ok := split[instance.Name]
+		if !ok {
+			continue
+		}
+		if err := instance.ApplyChanges(ctx, instanceChanges); err != nil {
+			errs = append(errs, instanceError(instance.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r Router) AdjustEndpoints(endpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
+	return canonicalEndpoints(endpoints), nil
+}
+
+// GetDomainFilter matches every routed zone.
+func (r Router) GetDomainFilter() endpoint.DomainFilter {
+	return r.domainFilter
+}
+
+// route returns the instance serving dnsName.
+func (r Router) route(dnsName string) (Instance, bool) {
+	dnsName = strings.ToLower(strings.TrimSuffix(dnsName, "."))
+	for _, candidate := range r.routes {
+		if dnsName == candidate.zone || strings.HasSuffix(dnsName, "."+candidate.zone) {
+			return candidate.instance, true
+		}
+	}
+	return Instance{}, false
+}
+
+// byInstance groups dnsNames by the name of the instance each is routed to, dropping unrouted names.
+func (r Router) byInstance(dnsNames []string) map[string][]string {
+	names := make(map[string][]string)
+	for _, dnsName := range dnsNames {
+		if instance, ok := r.route(dnsName); ok {
+			names[instance.Name] = append(names[instance.Name], dnsName)
+		}
+	}
+	return names
+}
+
+// routed drops the endpoints of instance that belong to another instance, or to no zone at all.
+func (r Router) routed(ctx context.Context, instance Instance, endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+	out := make([]*endpoint.Endpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		if routedTo, ok := r.route(ep.DNSName); !ok || routedTo.Name != instance.Name {
+			r.logger.DebugContext(ctx, "skipping record routed elsewhere",
+				slog.String("instance", instance.Name),
+				slog.String("endpoint", ep.DNSName))
+
+			continue
+		}
+		out = append(out, ep)
+	}
+	return out
+}
+
+// split groups changes by the instance each endpoint is routed to. Endpoints outside every
+// zone are skipped with a warning.
+func (r Router) split(ctx context.Context, changes *plan.Changes) (map[string]*plan.Changes, error) {
+	updateOld, err := pairUpdates(changes.UpdateOld, changes.UpdateNew)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]*plan.Changes)
+	changesFor := func(ep *endpoint.Endpoint) *plan.Changes {
+		instance, ok := r.route(ep.DNSName)
+		if !ok {
+			r.logger.WarnContext(ctx, "skipping change, no route for endpoint", slog.Any("endpoint", ep))
+
+			return nil
+		}
+		if _, ok := out[instance.Name]; !ok {
+			out[instance.Name] = &plan.Changes{}
+		}
+		return out[instance.Name]
+	}
+
+	for _, ep := range changes.Create {
+		if routed := changesFor(ep); routed != nil {
+			routed.Create = append(routed.Create, ep)
+		}
+	}
+	for i, ep := range changes.UpdateNew {
+		if routed := changesFor(ep); routed != nil {
+			routed.UpdateOld = append(routed.UpdateOld, updateOld[i])
+			routed.UpdateNew = append(routed.UpdateNew, ep)
+		}
+	}
+	for _, ep := range changes.Delete {
+		if routed := changesFor(ep); routed != nil {
+			routed.Delete = append(routed.Delete, ep)
+		}
+	}
+
+	return out, nil
+}
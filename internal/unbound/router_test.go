@@ -0,0 +1,136 @@
+package unbound
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestRouter(t *testing.T) {
+	t.Parallel()
+
+	siteA := testhelpers.NewFakeOpnsense()
+	siteAServer := httptest.NewServer(siteA)
+	defer siteAServer.Close()
+
+	siteB := testhelpers.NewFakeOpnsense()
+	siteBServer := httptest.NewServer(siteB)
+	defer siteBServer.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	ctx := context.Background()
+
+	// a record site a serves outside its zones is never reported
+	require.NoError(t, New(siteAServer.Client(), config.Config{
+		Opnsense: config.Opnsense{BaseURL: siteAServer.URL, Creds: "foo:bar"},
+	}, GetTestLogger()).ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("stray.site-b.corp", endpoint.RecordTypeA, "10.0.0.9")},
+	}))
+
+	cfg := config.Config{
+		Opnsense: config.Opnsense{
+			Instances: []config.Instance{
+				{Name: "a", BaseURL: siteAServer.URL, Creds: "foo:bar"},
+				{Name: "b", BaseURL: siteBServer.URL, Creds: "foo:bar"},
+				{Name: "c", BaseURL: broken.URL, Creds: "foo:bar"},
+			},
+			Routes: []config.Route{
+				{Zone: "site-a.corp", Instance: "a"},
+				{Zone: "site-b.corp", Instance: "b"},
+				{Zone: "lab.site-a.corp.", Instance: "b"},
+				{Zone: "site-c.corp", Instance: "c"},
+			},
+		},
+		DomainFilter: config.DomainFilter{Exclude: []string{"private.site-a.corp"}},
+	}
+	subject := NewRouter(http.DefaultClient, cfg, GetTestLogger())
+
+	filter := subject.GetDomainFilter()
+	assert.True(t, filter.Match("host.site-a.corp"))
+	assert.True(t, filter.Match("host.site-c.corp"))
+	assert.False(t, filter.Match("host.private.site-a.corp"))
+	assert.False(t, filter.Match("host.other.corp"))
+
+	err := subject.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("site-a.corp", endpoint.RecordTypeA, "10.0.0.1"),
+			endpoint.NewEndpoint("host.site-b.corp", endpoint.RecordTypeA, "10.0.0.2"),
+			endpoint.NewEndpoint("host.lab.site-a.corp", endpoint.RecordTypeA, "10.0.0.3"),
+			endpoint.NewEndpoint("host.site-c.corp", endpoint.RecordTypeA, "10.0.0.4"),
+			endpoint.NewEndpoint("host.other.corp", endpoint.RecordTypeA, "10.0.0.5"),
+		},
+	})
+	assert.ErrorContains(t, err, `opnsense instance "c"`)
+	assert.NotContains(t, err.Error(), `opnsense instance "a"`)
+	assert.NotContains(t, err.Error(), `opnsense instance "b"`)
+
+	// the stray record and the apex
+	assert.Equal(t, 2, countRequests(siteA.Requests(), AddOverrideEndpoint))
+	assert.Equal(t, 2, countRequests(siteB.Requests(), AddOverrideEndpoint))
+
+	_, err = subject.Records(ctx)
+	assert.ErrorContains(t, err, `opnsense instance "c"`)
+
+	cfg.Instances = cfg.Instances[:2]
+	cfg.Routes = cfg.Routes[:3]
+	subject = NewRouter(http.DefaultClient, cfg, GetTestLogger())
+
+	got, err := subject.Records(ctx)
+	require.NoError(t, err)
+	names := make([]string, 0, len(got))
+	for _, ep := range got {
+		names = append(names, ep.DNSName)
+	}
+	assert.Equal(t, []string{"site-a.corp", "host.site-b.corp", "host.lab.site-a.corp"}, names)
+
+	found, err := subject.FindRecords(ctx, "host.lab.site-a.corp", "stray.site-b.corp", "host.other.corp")
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "host.lab.site-a.corp", found[0].DNSName)
+}
+
+func TestRouter_split(t *testing.T) {
+	t.Parallel()
+
+	subject := NewRouter(http.DefaultClient, config.Config{
+		Opnsense: config.Opnsense{
+			Instances: []config.Instance{{Name: "a"}, {Name: "b"}},
+			Routes: []config.Route{
+				{Zone: "site-a.corp", Instance: "a"},
+				{Zone: "site-b.corp", Instance: "b"},
+			},
+		},
+	}, GetTestLogger())
+
+	hostA := endpoint.NewEndpoint("host.site-a.corp", endpoint.RecordTypeA, "10.0.0.1")
+	hostANew := endpoint.NewEndpoint("host.site-a.corp", endpoint.RecordTypeA, "10.0.0.2")
+	hostB := endpoint.NewEndpoint("host.Site-B.corp", endpoint.RecordTypeA, "10.0.0.3")
+	txtB := endpoint.NewEndpoint("a-host.site-b.corp", endpoint.RecordTypeTXT, "heritage=external-dns")
+
+	got, err := subject.split(context.Background(), &plan.Changes{
+		Create:    []*endpoint.Endpoint{hostB, txtB},
+		UpdateOld: []*endpoint.Endpoint{hostA},
+		UpdateNew: []*endpoint.Endpoint{hostANew},
+		Delete:    []*endpoint.Endpoint{endpoint.NewEndpoint("host.other.corp", endpoint.RecordTypeA, "10.0.0.4")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*plan.Changes{
+		"a": {UpdateOld: []*endpoint.Endpoint{hostA}, UpdateNew: []*endpoint.Endpoint{hostANew}},
+		"b": {Create: []*endpoint.Endpoint{hostB, txtB}},
+	}, got)
+
+	_, err = subject.split(context.Background(), &plan.Changes{UpdateOld: []*endpoint.Endpoint{hostA}})
+	assert.ErrorIs(t, err, ErrUnpairedUpdate)
+}
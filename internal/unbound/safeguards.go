@@ -0,0 +1,84 @@
+package unbound
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+var (
+	// ErrUnmanagedRecord is returned for plans deleting or modifying records external-dns did not create.
+	ErrUnmanagedRecord = errors.New("refusing to change records not managed by external-dns")
+	// ErrTooManyDeletions is returned for plans deleting more records than allowed.
+	ErrTooManyDeletions = errors.New("plan deletes more records than allowed")
+)
+
+// safeguards are the checks a plan must pass before anything is sent to opnsense, see config.Safeguards.
+type safeguards struct {
+	allowUnmanaged bool
+	// maxDeletions is the most records a plan may delete, 0 is unlimited.
+	maxDeletions int
+}
+
+func newSafeguards(cfg config.Safeguards) safeguards {
+	return safeguards{
+		allowUnmanaged: cfg.AllowUnmanaged,
+		maxDeletions:   cfg.MaxDeletions,
+	}
+}
+
+// WithForce lifts the safeguards, so plans may change unmanaged records and delete any number of records.
+func WithForce() Opts {
+	return func(u *Unbound) {
+		u.safeguards = safeguards{allowUnmanaged: true}
+	}
+}
+
+// guard rejects the whole plan when it breaks the safeguards. Only the record types this provider
+// manages are checked, the ownership TXT endpoints follow their records.
+func (u Unbound) guard(changes *plan.Changes) error {
+	deletions := 0
+	for _, ep := range changes.Delete {
+		if managedType(ep.RecordType) {
+			deletions++
+		}
+	}
+	if u.safeguards.maxDeletions > 0 && deletions > u.safeguards.maxDeletions {
+		return fmt.Errorf("%d deletions, at most %d allowed: %w", deletions, u.safeguards.maxDeletions, ErrTooManyDeletions)
+	}
+
+	if u.safeguards.allowUnmanaged {
+		return nil
+	}
+
+	var unmanaged []string
+	for _, ep := range append(append([]*endpoint.Endpoint{}, changes.Delete...), changes.UpdateOld...) {
+		if managedType(ep.RecordType) && !u.managed(ep) {
+			unmanaged = append(unmanaged, ep.DNSName)
+		}
+	}
+	if len(unmanaged) > 0 {
+		return fmt.Errorf("%s: %w", strings.Join(unmanaged, ", "), ErrUnmanagedRecord)
+	}
+
+	return nil
+}
+
+// managed reports whether every row behind ep carries external-dns metadata. The descriptions
+// seen by the last read are trusted over the description label of ep.
+func (u Unbound) managed(ep *endpoint.Endpoint) bool {
+	for _, row := range rowRefs(ep) {
+		description := ep.Labels[DescriptionLabel]
+		if record, ok := u.knownRecords.row(row.UUID); ok {
+			description = record.Description
+		}
+		if _, err := ParseMetadata(description); err != nil {
+			return false
+		}
+	}
+	return true
+}
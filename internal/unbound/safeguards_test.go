@@ -0,0 +1,92 @@
+package unbound
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestUnbound_guard(t *testing.T) {
+	t.Parallel()
+
+	record := func(dnsName string, uuid string, description string) *endpoint.Endpoint {
+		ep := endpoint.NewEndpoint(dnsName, endpoint.RecordTypeA, "10.0.0.1")
+		ep.Labels[RowsLabel] = uuid + "=10.0.0.1"
+		ep.Labels[DescriptionLabel] = description
+		return ep
+	}
+	managed := record("managed.example.domain", "managed-uuid", "extdns/1 t=A")
+	legacy := record("legacy.example.domain", "legacy-uuid", appendToDescription("aGVyaXRhZ2U9ZXh0ZXJuYWwtZG5z"))
+	handMade := record("hand.example.domain", "hand-uuid", "printer in the hall")
+	// the label claims the record is managed, the last read says otherwise
+	relabelled := record("relabelled.example.domain", "relabelled-uuid", "extdns/1 t=A")
+	txt := endpoint.NewEndpoint("a-hand.example.domain", endpoint.RecordTypeTXT, "heritage=external-dns")
+
+	tests := []struct {
+		name       string
+		safeguards config.Safeguards
+		opts       []Opts
+		changes    *plan.Changes
+		wantErr    error
+	}{
+		{
+			name:    "managed records",
+			changes: &plan.Changes{Delete: []*endpoint.Endpoint{managed, legacy, txt}},
+		},
+		{
+			name:    "delete of an unmanaged record",
+			changes: &plan.Changes{Delete: []*endpoint.Endpoint{managed, handMade}},
+			wantErr: ErrUnmanagedRecord,
+		},
+		{
+			name: "update of an unmanaged record",
+			changes: &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{handMade},
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("hand.example.domain", endpoint.RecordTypeA, "10.0.0.2")},
+			},
+			wantErr: ErrUnmanagedRecord,
+		},
+		{
+			name:    "description of the last read wins",
+			changes: &plan.Changes{Delete: []*endpoint.Endpoint{relabelled}},
+			wantErr: ErrUnmanagedRecord,
+		},
+		{
+			name:       "unmanaged records allowed by config",
+			safeguards: config.Safeguards{AllowUnmanaged: true},
+			changes:    &plan.Changes{Delete: []*endpoint.Endpoint{handMade}},
+		},
+		{
+			name:       "too many deletions",
+			safeguards: config.Safeguards{MaxDeletions: 1},
+			changes:    &plan.Changes{Delete: []*endpoint.Endpoint{managed, legacy, txt}},
+			wantErr:    ErrTooManyDeletions,
+		},
+		{
+			name:       "deletions within the limit",
+			safeguards: config.Safeguards{MaxDeletions: 2},
+			changes:    &plan.Changes{Delete: []*endpoint.Endpoint{managed, legacy, txt}},
+		},
+		{
+			name:       "force",
+			safeguards: config.Safeguards{MaxDeletions: 1},
+			opts:       []Opts{WithForce()},
+			changes:    &plan.Changes{Delete: []*endpoint.Endpoint{managed, handMade}},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			u := New(http.DefaultClient, config.Config{Safeguards: tt.safeguards}, GetTestLogger(), tt.opts...)
+			u.knownRecords.putRow(Record{UUID: "relabelled-uuid", Description: "printer in the hall"})
+
+			assert.ErrorIs(t, u.guard(tt.changes), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package unbound
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+const (
+	// searchPageSize is the number of rows requested per page of a search.
+	searchPageSize = 500
+	// maxSearchPages bounds a search, in case opnsense keeps answering full pages.
+	maxSearchPages = 1000
+)
+
+// FindRecords returns the records for dnsNames only. The opnsense search phrase narrows the rows
+// server side, so a handful of hosts can be looked up without reading every override.
+// Unlike Records, the cache is merged with what is found rather than replaced.
+func (u Unbound) FindRecords(ctx context.Context, dnsNames ...string) ([]*endpoint.Endpoint, error) {
+	leave, err := u.queue.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	rows, err := u.findRows(ctx, dnsNames...)
+	if err != nil {
+		return nil, err
+	}
+
+	return endpointsWithOwners(ctx, liveRows(rows), u.registry, u.txtNames)
+}
+
+// findRows returns the override rows for dnsNames, soft deleted ones included, merging them and
+// their endpoints into the cache.
+func (u Unbound) findRows(ctx context.Context, dnsNames ...string) ([]Record, error) {
+	rows := make([]Record, 0, len(dnsNames))
+	seen := make(map[string]struct{})
+
+	for _, dnsName := range dnsNames {
+		hostname, domain := u.splitter.split(dnsName)
+		found, err := u.searchRows(ctx, strings.TrimSpace(hostname+" "+domain))
+		if err != nil {
+			return nil, fmt.Errorf("find %q: %w", dnsName, err)
+		}
+
+		// the search phrase is a substring match on any column, keep exact matches only
+		for _, row := range found {
+			if _, ok := seen[row.UUID]; ok || !strings.EqualFold(row.DNSName(), joinDNSName(hostname, domain)) {
+				continue
+			}
+			seen[row.UUID] = struct{}{}
+			rows = append(rows, row)
+		}
+	}
+
+	endpoints, err := endpointsWithOwners(ctx, liveRows(rows), u.registry, u.txtNames)
+	if err != nil {
+		return nil, err
+	}
+	u.knownRecords.mergeReadRecords(endpoints, rows)
+
+	return rows, nil
+}
+
+// searchResult is a page of any opnsense search response.
+type searchResult[T any] struct {
+	Rows  []T `json:"rows"`
+	Total int `json:"total"`
+}
+
+// searchRows pages through every override matching searchPhrase. An empty phrase matches everything.
+func (u Unbound) searchRows(ctx context.Context, searchPhrase string) ([]Record, error) {
+	return searchAll[Record](ctx, u, SearchOverridesEndpoint, searchPhrase)
+}
+
+// searchAliases pages through every host alias matching searchPhrase.
+func (u Unbound) searchAliases(ctx context.Context, searchPhrase string) ([]Alias, error) {
+	return searchAll[Alias](ctx, u, SearchAliasesEndpoint, searchPhrase)
+}
+
+func searchAll[T any](ctx context.Context, u Unbound, apiPath string, searchPhrase string) ([]T, error) {
+	rows := make([]T, 0)
+
+	var previous []T
+	for current := 1; current <= maxSearchPages; current++ {
+		page := &searchResult[T]{}
+		if err := u.searchPage(ctx, apiPath, searchPhrase, current, page); err != nil {
+			return nil, err
+		}
+
+		// a server ignoring current answers the first page again, and would never run short
+		if current > 1 && reflect.DeepEqual(page.Rows, previous) {
+			return nil, fmt.Errorf("page %d repeats page %d: %w", current, current-1, ErrPaging)
+		}
+		previous = page.Rows
+		rows = append(rows, page.Rows...)
+
+		// a short page is the last one. Total guards against servers ignoring the page size.
+		if len(page.Rows) < searchPageSize || (page.Total > 0 && len(rows) >= page.Total) {
+			return rows, nil
+		}
+	}
+
+	return nil, fmt.Errorf("more than %d pages: %w", maxSearchPages, ErrPaging)
+}
+
+func (u Unbound) searchPage(ctx context.Context, apiPath string, searchPhrase string, current int, out any) error {
+	query := url.Values{}
+	query.Set("current", strconv.Itoa(current))
+	query.Set("rowCount", strconv.Itoa(searchPageSize))
+	query.Set("searchPhrase", searchPhrase)
+
+	reqURL := u.baseURL + apiPath + "?" + query.Encode()
+	u.logger.InfoContext(ctx, "records request url", slog.String("URL", reqURL))
+
+	resp, body, err := u.call(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("records response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %v: %w", resp.Status, ErrRequestFailed)
+	}
+
+	if err := u.unmarshalSearchResp(ctx, resp, body, out); err != nil {
+		return errors.Join(ErrMarshalling, err)
+	}
+
+	return nil
+}
+
+func (u Unbound) unmarshalSearchResp(ctx context.Context, resp *http.Response, buff []byte, out any) error {
+	u.logger.DebugContext(ctx,
+		"received response from opnsense",
+		slog.String("status", resp.Status),
+		slog.String("body", string(buff)))
+
+	if err := json.Unmarshal(buff, out); err != nil {
+		return fmt.Errorf("unmarshal resp: %w", err)
+	}
+
+	u.logger.DebugContext(ctx, "unmarshalled successfully", slog.Any("searchResp", out))
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package unbound
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// pagedServer serves rows the way searchHostOverride does, honoring current, rowCount and searchPhrase.
+// Any other search is answered with no rows.
+func pagedServer(tb testing.TB, rows []Record, gotQueries *[]string) *httptest.Server {
+	tb.Helper()
+	var mu sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != SearchOverridesEndpoint {
+			require.NoError(tb, json.NewEncoder(w).Encode(searchResult[Alias]{}))
+
+			return
+		}
+		*gotQueries = append(*gotQueries, r.URL.RawQuery)
+
+		current, err := strconv.Atoi(r.URL.Query().Get("current"))
+		require.NoError(tb, err)
+		rowCount, err := strconv.Atoi(r.URL.Query().Get("rowCount"))
+		require.NoError(tb, err)
+
+		start := min((current-1)*rowCount, len(rows))
+		end := min(start+rowCount, len(rows))
+		resp := SearchHostResp{
+			Rows:     rows[start:end],
+			RowCount: end - start,
+			Total:    len(rows),
+			Current:  current,
+		}
+		require.NoError(tb, json.NewEncoder(w).Encode(resp))
+	}))
+}
+
+func TestUnbound_Records_paginated(t *testing.T) {
+	t.Parallel()
+
+	rows := make([]Record, 0, searchPageSize+2)
+	for i := 0; i < searchPageSize+2; i++ {
+		rows = append(rows, Record{
+			UUID:     fmt.Sprintf("uuid-%d", i),
+			Hostname: fmt.Sprintf("host%d", i),
+			Domain:   "example.domain",
+			Rr:       "A (IPv4 address)",
+			Server:   "10.0.0.1",
+		})
+	}
+
+	gotQueries := []string{}
+	server := pagedServer(t, rows, &gotQueries)
+	defer server.Close()
+
+	cfg := config.Config{Opnsense: config.Opnsense{BaseURL: server.URL, Creds: "foo:bar"}}
+	u := New(server.Client(), cfg, GetTestLogger())
+
+	got, err := u.Records(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, got, searchPageSize+2)
+	assert.Equal(t, []string{
+		"current=1&rowCount=500&searchPhrase=",
+		"current=2&rowCount=500&searchPhrase=",
+	}, gotQueries)
+}
+
+func TestUnbound_Records_pagingIgnored(t *testing.T) {
+	t.Parallel()
+
+	rows := make([]Record, 0, searchPageSize)
+	for i := 0; i < searchPageSize; i++ {
+		rows = append(rows, Record{UUID: fmt.Sprintf("uuid-%d", i), Hostname: fmt.Sprintf("host%d", i), Domain: "example.domain"})
+	}
+
+	// answers every search with the same full page, and no total
+	requests := 0
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		require.NoError(t, json.NewEncoder(w).Encode(SearchHostResp{Rows: rows}))
+	}))
+	defer server.Close()
+
+	cfg := config.Config{Opnsense: config.Opnsense{BaseURL: server.URL, Creds: "foo:bar"}}
+	u := New(server.Client(), cfg, GetTestLogger())
+
+	_, err := u.Records(context.Background())
+	assert.ErrorIs(t, err, ErrPaging)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, requests)
+}
+
+func TestUnbound_FindRecords(t *testing.T) {
+	t.Parallel()
+
+	rows := []Record{
+		{UUID: "uuid-1", Hostname: "foo", Domain: "example.domain", Rr: "A", Server: "10.0.0.1"},
+		{UUID: "uuid-2", Hostname: "foobar", Domain: "example.domain", Rr: "A", Server: "10.0.0.2"},
+	}
+
+	gotQueries := []string{}
+	server := pagedServer(t, rows, &gotQueries)
+	defer server.Close()
+
+	cfg := config.Config{Opnsense: config.Opnsense{BaseURL: server.URL, Creds: "foo:bar"}}
+	u := New(server.Client(), cfg, GetTestLogger())
+
+	got, err := u.FindRecords(context.Background(), "foo.example.domain")
+	require.NoError(t, err)
+	assert.Equal(t, []*endpoint.Endpoint{
+		{
+			DNSName:    "foo.example.domain",
+			Targets:    endpoint.NewTargets("10.0.0.1"),
+			RecordType: endpoint.RecordTypeA,
+			Labels: map[string]string{
+				RowsLabel: "uuid-1=10.0.0.1",
+			},
+		},
+	}, got)
+	assert.Equal(t, []string{"current=1&rowCount=500&searchPhrase=foo+example.domain"}, gotQueries)
+}
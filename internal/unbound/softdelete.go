@@ -0,0 +1,250 @@
+package unbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// softDeletePolicy is whether deletions only disable rows, and how long disabled rows are kept,
+// see config.SoftDelete.
+type softDeletePolicy struct {
+	enabled   bool
+	retention time.Duration
+}
+
+func newSoftDeletePolicy(cfg config.SoftDelete) softDeletePolicy {
+	return softDeletePolicy{
+		enabled:   cfg.Enabled,
+		retention: cfg.Retention,
+	}
+}
+
+// WithSoftDelete makes deletions disable rows instead of deleting them, whatever the config says.
+func WithSoftDelete() Opts {
+	return func(u *Unbound) {
+		u.softDelete.enabled = true
+	}
+}
+
+// softDeleted reports whether record is a disabled row carrying external-dns metadata. Such rows
+// are hidden from external-dns, and are enabled again rather than duplicated when recreated.
+// Rows disabled without metadata, eg by hand in the UI, are left alone.
+func softDeleted(record Record) bool {
+	if record.Enabled != "0" {
+		return false
+	}
+	_, err := ParseMetadata(record.Description)
+	return err == nil
+}
+
+// liveRows drops the soft deleted rows.
+func liveRows(rows []Record) []Record {
+	out := make([]Record, 0, len(rows))
+	for _, row := range rows {
+		if !softDeleted(row) {
+			out = append(out, row)
+		}
+	}
+	return out
+}
+
+// disableRow soft deletes before, the row as it is: the deletion time is written to its metadata,
+// then the row is disabled. The row is journaled so the plan can still be rolled back.
+func (u Unbound) disableRow(ctx context.Context, tx *journal, before Record) error {
+	meta, err := ParseMetadata(before.Description)
+	if err != nil {
+		// a forced soft delete of a record made by hand keeps its description as the comment
+		meta = Metadata{RecordType: before.RecordType(), Comment: before.Description}
+	}
+	meta.Deleted = u.now().UTC().Truncate(time.Second)
+
+	record := before
+	record.Description = meta.String()
+	if err := u.setRecord(ctx, before.UUID, record); err != nil {
+		return err
+	}
+	tx.updated(before)
+
+	return u.toggleRow(ctx, record, false)
+}
+
+// enableRow undoes the soft delete of before, writing record over it and enabling the row.
+func (u Unbound) enableRow(ctx context.Context, tx *journal, before Record, record Record) error {
+	record.UUID = before.UUID
+	record.Enabled = before.Enabled
+	if err := u.setRecord(ctx, before.UUID, record); err != nil {
+		return err
+	}
+	tx.updated(before)
+
+	return u.toggleRow(ctx, record, true)
+}
+
+// toggleRow enables or disables the row behind record. The state is part of the url, so the
+// request is safe to retry.
+func (u Unbound) toggleRow(ctx context.Context, record Record, enabled bool) error {
+	apiPath := ToggleOverrideEndpoint
+	if isAlias(record) {
+		apiPath = ToggleAliasEndpoint
+	}
+	state, wantResult := "0", DisabledResponse
+	if enabled {
+		state, wantResult = "1", EnabledResponse
+	}
+
+	url := u.baseURL + path.Join(apiPath, record.UUID, state)
+	u.logger.InfoContext(ctx, "making toggle request", slog.String("url", url))
+
+	resp, body, err := u.call(ctx, http.MethodPost, url, emptyJSON())
+	if err != nil {
+		return fmt.Errorf("toggle http do: %w", err)
+	}
+
+	logResponse(ctx, u.logger, apiPath, body)
+
+	if resp.StatusCode != http.StatusOK {
+		u.logger.InfoContext(ctx, "toggle response status not OK",
+			slog.Any("status", resp.Status),
+			slog.String("endpoint", record.DNSName()))
+
+		return fmt.Errorf("response status: %v: %w", resp.StatusCode, ErrRequestFailed)
+	}
+
+	if _, err := u.checkResponse(ctx, body, wantResult); err != nil {
+		return err
+	}
+
+	record.Enabled = state
+	u.knownRecords.putRow(record)
+
+	return nil
+}
+
+// Enable undoes the soft delete of the overrides named by dnsNames, returning the number of rows
+// enabled. Like a plan, a failure rolls back the rows already enabled, and the outcome is audited.
+func (u Unbound) Enable(ctx context.Context, dnsNames ...string) (int, error) {
+	leave, err := u.queue.enter(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer leave()
+
+	rows, err := u.findRows(ctx, dnsNames...)
+	if err != nil {
+		return 0, err
+	}
+
+	saved := u.checkpoint()
+	tx := newJournal()
+	enabled := 0
+	for _, row := range rows {
+		if !softDeleted(row) {
+			continue
+		}
+
+		// soft deleted rows always carry metadata
+		meta, _ := ParseMetadata(row.Description)
+		meta.Deleted = time.Time{}
+
+		record := row
+		record.Rr = row.RecordType()
+		record.Description = meta.String()
+		if err := u.enableRow(ctx, tx, row, record); err != nil {
+			err = u.rollback(ctx, tx, saved, fmt.Errorf("enable %q: %w", row.DNSName(), err))
+			u.audited(ctx, auditEnable, nil, dnsNames, tx.mutations, err)
+
+			return 0, err
+		}
+		enabled++
+	}
+
+	if enabled == 0 {
+		return 0, nil
+	}
+
+	if err := u.reconfigure(ctx); err != nil {
+		err = u.rollback(ctx, tx, saved, fmt.Errorf("enable reconfigure endpoint: %w", err))
+		u.audited(ctx, auditEnable, nil, dnsNames, tx.mutations, err)
+
+		return 0, err
+	}
+	u.audited(ctx, auditEnable, nil, dnsNames, tx.mutations, nil)
+
+	return enabled, nil
+}
+
+// Purge deletes the rows soft deleted longer ago than the retention, and forgets their owners.
+// It returns the number of rows deleted. Rows are purged one at a time, a failure does not stop
+// the rest. The rows purged are audited.
+func (u Unbound) Purge(ctx context.Context) (int, error) {
+	leave, err := u.queue.enter(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer leave()
+
+	_, rows, err := u.read(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := u.now().Add(-u.softDelete.retention)
+	expired := make([]Record, 0)
+	// live holds the records still served, whose owners must be kept
+	live := make(map[endpoint.EndpointKey]struct{})
+	for _, row := range rows {
+		if !softDeleted(row) {
+			live[ownershipKey(row.DNSName(), row.RecordType())] = struct{}{}
+			continue
+		}
+		if meta, _ := ParseMetadata(row.Description); !meta.Deleted.IsZero() && !meta.Deleted.After(cutoff) {
+			expired = append(expired, row)
+		}
+	}
+	// aliases before the overrides they may be attached to
+	sort.SliceStable(expired, func(i, j int) bool {
+		return isAlias(expired[i]) && !isAlias(expired[j])
+	})
+
+	var errs []error
+	purged := make([]mutation, 0, len(expired))
+	for _, row := range expired {
+		if err := u.deleteRow(ctx, row); err != nil {
+			errs = append(errs, fmt.Errorf("purge %q: %w", row.DNSName(), err))
+			continue
+		}
+		purged = append(purged, mutation{kind: mutationDelete, record: row})
+
+		key := ownershipKey(row.DNSName(), row.RecordType())
+		if _, ok := live[key]; ok {
+			continue
+		}
+		if err := u.registry.Disown(ctx, key); err != nil {
+			u.logger.WarnContext(ctx, "unable to disown purged endpoint",
+				slog.String("endpoint", row.DNSName()),
+				slog.Any("error", err))
+		}
+	}
+
+	if len(purged) > 0 {
+		if err := u.reconfigure(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("purge reconfigure endpoint: %w", err))
+		}
+	}
+
+	err = errors.Join(errs...)
+	if len(expired) > 0 {
+		u.audited(ctx, auditPurge, nil, nil, purged, err)
+	}
+
+	return len(purged), err
+}
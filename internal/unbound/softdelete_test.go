@@ -0,0 +1,95 @@
+package unbound
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/MrUsefull/boundation/internal/unbound/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestUnbound_softDelete(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(testhelpers.NewFakeOpnsense())
+	defer server.Close()
+
+	ctx := context.Background()
+	now := testClock()
+	cfg := config.Config{
+		Opnsense:   config.Opnsense{BaseURL: server.URL, Creds: "foo:bar"},
+		SoftDelete: config.SoftDelete{Enabled: true, Retention: 24 * time.Hour},
+	}
+	u := New(server.Client(), cfg, GetTestLogger(), WithClock(func() time.Time { return now }))
+
+	rows := func() []Record {
+		_, rows, err := u.read(ctx)
+		require.NoError(t, err)
+		return rows
+	}
+	remove := func() {
+		current, err := u.Records(ctx)
+		require.NoError(t, err)
+		require.NoError(t, u.ApplyChanges(ctx, &plan.Changes{Delete: current}))
+	}
+
+	require.NoError(t, u.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("host.example.domain", endpoint.RecordTypeA, "10.0.0.1")},
+	}))
+
+	// deleting disables the row, external-dns no longer sees it
+	remove()
+	got, err := u.Records(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+	found, err := u.FindRecords(ctx, "host.example.domain")
+	require.NoError(t, err)
+	assert.Empty(t, found)
+	require.Len(t, rows(), 1)
+	assert.Equal(t, "0", rows()[0].Enabled)
+	assert.Equal(t, "extdns/1 t=A c=2024-05-01T10:00:00Z d=2024-05-01T10:00:00Z", rows()[0].Description)
+
+	// creating it again enables the same row
+	require.NoError(t, u.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("host.example.domain", endpoint.RecordTypeA, "10.0.0.1")},
+	}))
+	require.Len(t, rows(), 1)
+	assert.Equal(t, Record{
+		UUID:        "uuid-1",
+		Enabled:     "1",
+		Hostname:    "host",
+		Domain:      "example.domain",
+		Rr:          "A",
+		Server:      "10.0.0.1",
+		Description: "extdns/1 t=A c=2024-05-01T10:00:00Z",
+	}, rows()[0])
+
+	remove()
+	enabled, err := u.Enable(ctx, "host.example.domain", "missing.example.domain")
+	require.NoError(t, err)
+	assert.Equal(t, 1, enabled)
+	got, err = u.Records(ctx)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, "host.example.domain", got[0].DNSName)
+
+	// rows are only purged once the retention has passed
+	remove()
+	now = now.Add(time.Hour)
+	purged, err := u.Purge(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, purged)
+	require.Len(t, rows(), 1)
+
+	now = now.Add(24 * time.Hour)
+	purged, err = u.Purge(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Empty(t, rows())
+}
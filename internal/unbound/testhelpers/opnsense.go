@@ -0,0 +1,110 @@
+package testhelpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FakeOpnsense is an in memory opnsense unbound api, safe for concurrent use. Host overrides
+// are stored as they are added, set and toggled, every other search returns no rows.
+type FakeOpnsense struct {
+	mu       sync.Mutex
+	requests []string
+	rows     map[string]map[string]any
+	nextUUID int
+}
+
+func NewFakeOpnsense() *FakeOpnsense {
+	return &FakeOpnsense{
+		rows: make(map[string]map[string]any),
+	}
+}
+
+// Requests returns the path of every request served, in the order they arrived.
+func (f *FakeOpnsense) Requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string{}, f.requests...)
+}
+
+func (f *FakeOpnsense) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, r.URL.Path)
+	_, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/unbound/"), "/")
+	action, uuid, _ := strings.Cut(action, "/")
+
+	var resp any
+	switch {
+	case action == "searchHostOverride":
+		resp = f.search()
+	case strings.HasPrefix(action, "search"):
+		resp = map[string]any{"rows": []any{}, "total": 0}
+	case action == "addHostOverride":
+		request := struct {
+			Host map[string]any `json:"host"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.nextUUID++
+		uuid = fmt.Sprintf("uuid-%d", f.nextUUID)
+		request.Host["uuid"] = uuid
+		f.rows[uuid] = request.Host
+		resp = map[string]string{"result": "saved", "uuid": uuid}
+	case action == "setHostOverride":
+		request := struct {
+			Host map[string]any `json:"host"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := f.rows[uuid]; ok {
+			request.Host["uuid"] = uuid
+			f.rows[uuid] = request.Host
+		}
+		resp = map[string]string{"result": "saved"}
+	case strings.HasPrefix(action, "add") || strings.HasPrefix(action, "set"):
+		resp = map[string]string{"result": "saved"}
+	case strings.HasPrefix(action, "toggle"):
+		uuid, enabled, _ := strings.Cut(uuid, "/")
+		resp = map[string]string{"result": "Disabled"}
+		if enabled == "1" {
+			resp = map[string]string{"result": "Enabled"}
+		}
+		if row, ok := f.rows[uuid]; ok {
+			row["enabled"] = enabled
+		}
+	case strings.HasPrefix(action, "del"):
+		delete(f.rows, uuid)
+		resp = map[string]string{"result": "deleted"}
+	default:
+		resp = map[string]string{"result": ""}
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (f *FakeOpnsense) search() map[string]any {
+	uuids := make([]string, 0, len(f.rows))
+	for uuid := range f.rows {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+
+	rows := make([]any, 0, len(uuids))
+	for _, uuid := range uuids {
+		rows = append(rows, f.rows[uuid])
+	}
+	return map[string]any{"rows": rows, "total": len(rows)}
+}
@@ -0,0 +1,184 @@
+package unbound
+
+import (
+	"strings"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// recordTemplate is replaced by the lower case record type in the txt prefix and suffix.
+const recordTemplate = "%{record_type}"
+
+// txtTypes are the record types external-dns encodes in ownership TXT names.
+// MX is included as this provider manages MX overrides.
+var txtTypes = []string{
+	endpoint.RecordTypeA,
+	endpoint.RecordTypeAAAA,
+	endpoint.RecordTypeCNAME,
+	endpoint.RecordTypeNS,
+	endpoint.RecordTypeMX,
+}
+
+// txtNames maps dns names to the ownership TXT names of the external-dns TXT registry and back.
+// It follows the --txt-prefix, --txt-suffix and --txt-wildcard-replacement flags the same way
+// external-dns does, so the TXT endpoints reported match the ones the registry looks for.
+type txtNames struct {
+	prefix              string
+	suffix              string
+	wildcardReplacement string
+}
+
+func newTXTNames(cfg config.TXT) txtNames {
+	return txtNames{
+		prefix:              strings.ToLower(cfg.Prefix),
+		suffix:              strings.ToLower(cfg.Suffix),
+		wildcardReplacement: strings.ToLower(cfg.WildcardReplacement),
+	}
+}
+
+// endpoints are the ownership TXT endpoints external-dns expects for an owned record.
+// The registry keeps updating owned records whose typed TXT name is missing.
+func (n txtNames) endpoints(dnsName string, recordType string, heritage string) []*endpoint.Endpoint {
+	out := make([]*endpoint.Endpoint, 0, 2)
+	// external-dns only writes the untyped name when the type is not in the affix, and never for AAAA
+	if !n.typeInAffix() && recordType != endpoint.RecordTypeAAAA {
+		out = append(out, txtEndpoint(n.name(dnsName), heritage))
+	}
+	return append(out, txtEndpoint(n.typedName(dnsName, recordType), heritage))
+}
+
+func txtEndpoint(dnsName string, heritage string) *endpoint.Endpoint {
+	return &endpoint.Endpoint{
+		DNSName:    dnsName,
+		Targets:    endpoint.NewTargets(heritage),
+		RecordType: endpoint.RecordTypeTXT,
+	}
+}
+
+// name is the untyped TXT name of dnsName, the format used before external-dns v0.12.
+func (n txtNames) name(dnsName string) string {
+	return n.affix(dnsName, dropTemplate(n.prefix), dropTemplate(n.suffix), "")
+}
+
+// typedName is the TXT name of dnsName that includes the record type, eg a-foo.example.com.
+func (n txtNames) typedName(dnsName string, recordType string) string {
+	recordType = strings.ToLower(recordType)
+	typePrefix := ""
+	if !n.typeInAffix() {
+		typePrefix = recordType + "-"
+	}
+
+	return n.affix(dnsName,
+		strings.ReplaceAll(n.prefix, recordTemplate, recordType),
+		strings.ReplaceAll(n.suffix, recordTemplate, recordType),
+		typePrefix)
+}
+
+// affix wraps the first label of dnsName in prefix and suffix.
+func (n txtNames) affix(dnsName string, prefix string, suffix string, typePrefix string) string {
+	label, domain, found := strings.Cut(dnsName, ".")
+	if n.wildcardReplacement != "" && label == "*" {
+		label = n.wildcardReplacement
+	}
+
+	name := prefix + typePrefix + label + suffix
+	if !found {
+		return name
+	}
+	return name + "." + domain
+}
+
+// endpointKey returns the name and record type txtName holds the ownership of, as named by ownerName.
+// The record type is empty for untyped names, and the name is empty when txtName is not an
+// ownership name at all.
+func (n txtNames) endpointKey(txtName string) endpoint.EndpointKey {
+	txtName = strings.ToLower(txtName)
+
+	if n.suffix == "" {
+		name, recordType := n.dropAffix(txtName)
+		return endpoint.EndpointKey{DNSName: name, RecordType: recordType}
+	}
+
+	// the suffix is attached to the first label, and may contain dots itself
+	dots := strings.Count(n.suffix, ".")
+	labels := strings.SplitN(txtName, ".", dots+2)
+	if len(labels) < dots+2 {
+		return endpoint.EndpointKey{}
+	}
+
+	name, recordType := n.dropAffix(strings.Join(labels[:dots+1], "."))
+	if name == "" {
+		return endpoint.EndpointKey{}
+	}
+	return endpoint.EndpointKey{DNSName: name + "." + labels[dots+1], RecordType: recordType}
+}
+
+// ownerName is the name the ownership of dnsName is keyed by, which has any wildcard replaced.
+func (n txtNames) ownerName(dnsName string) string {
+	dnsName = strings.ToLower(dnsName)
+	if n.wildcardReplacement == "" || !strings.HasPrefix(dnsName, "*") {
+		return dnsName
+	}
+	if dnsName == "*" || strings.HasPrefix(dnsName, "*.") {
+		return n.wildcardReplacement + strings.TrimPrefix(dnsName, "*")
+	}
+	return dnsName
+}
+
+func (n txtNames) dropAffix(name string) (string, string) {
+	prefix, suffix := n.prefix, n.suffix
+
+	if n.typeInAffix() {
+		for _, recordType := range txtTypes {
+			lower := strings.ToLower(recordType)
+			typedPrefix := strings.ReplaceAll(prefix, recordTemplate, lower)
+			typedSuffix := strings.ReplaceAll(suffix, recordTemplate, lower)
+
+			if suffix == "" && strings.HasPrefix(name, typedPrefix) {
+				return strings.TrimPrefix(name, typedPrefix), recordType
+			}
+			if suffix != "" && strings.HasSuffix(name, typedSuffix) {
+				return strings.TrimSuffix(name, typedSuffix), recordType
+			}
+		}
+
+		// untyped names have the template dropped
+		prefix, suffix = dropTemplate(prefix), dropTemplate(suffix)
+	}
+
+	if suffix == "" {
+		if !strings.HasPrefix(name, prefix) {
+			return "", ""
+		}
+		return typeFromName(strings.TrimPrefix(name, prefix))
+	}
+
+	if !strings.HasSuffix(name, suffix) {
+		return "", ""
+	}
+	return typeFromName(strings.TrimSuffix(name, suffix))
+}
+
+func (n txtNames) typeInAffix() bool {
+	return strings.Contains(n.prefix, recordTemplate) || strings.Contains(n.suffix, recordTemplate)
+}
+
+// typeFromName splits the record type external-dns puts in front of the name, eg the a- of a-foo.
+func typeFromName(name string) (string, string) {
+	label, rest, found := strings.Cut(name, "-")
+	if !found {
+		return name, ""
+	}
+
+	for _, recordType := range txtTypes {
+		if label == strings.ToLower(recordType) {
+			return rest, recordType
+		}
+	}
+	return name, ""
+}
+
+func dropTemplate(affix string) string {
+	return strings.ReplaceAll(affix, recordTemplate, "")
+}
@@ -0,0 +1,144 @@
+package unbound
+
+import (
+	"testing"
+
+	"github.com/MrUsefull/boundation/internal/config"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func Test_txtNames(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		txt        config.TXT
+		dnsName    string
+		recordType string
+		want       []string
+	}{
+		{
+			name:       "default A",
+			dnsName:    "foo.example.domain",
+			recordType: endpoint.RecordTypeA,
+			want:       []string{"foo.example.domain", "a-foo.example.domain"},
+		},
+		{
+			name:       "default AAAA has no untyped name",
+			dnsName:    "foo.example.domain",
+			recordType: endpoint.RecordTypeAAAA,
+			want:       []string{"aaaa-foo.example.domain"},
+		},
+		{
+			name:       "default CNAME",
+			dnsName:    "www.example.domain",
+			recordType: endpoint.RecordTypeCNAME,
+			want:       []string{"www.example.domain", "cname-www.example.domain"},
+		},
+		{
+			name:       "prefix",
+			txt:        config.TXT{Prefix: "Owner-"},
+			dnsName:    "foo.example.domain",
+			recordType: endpoint.RecordTypeMX,
+			want:       []string{"owner-foo.example.domain", "owner-mx-foo.example.domain"},
+		},
+		{
+			name:       "suffix",
+			txt:        config.TXT{Suffix: "-owner"},
+			dnsName:    "foo.example.domain",
+			recordType: endpoint.RecordTypeA,
+			want:       []string{"foo-owner.example.domain", "a-foo-owner.example.domain"},
+		},
+		{
+			name:       "templated prefix",
+			txt:        config.TXT{Prefix: "%{record_type}-owner."},
+			dnsName:    "foo.example.domain",
+			recordType: endpoint.RecordTypeA,
+			want:       []string{"a-owner.foo.example.domain"},
+		},
+		{
+			name:       "templated suffix",
+			txt:        config.TXT{Suffix: ".%{record_type}"},
+			dnsName:    "foo.example.domain",
+			recordType: endpoint.RecordTypeCNAME,
+			want:       []string{"foo.cname.example.domain"},
+		},
+		{
+			name:       "wildcard replacement",
+			txt:        config.TXT{WildcardReplacement: "any"},
+			dnsName:    "*.example.domain",
+			recordType: endpoint.RecordTypeA,
+			want:       []string{"any.example.domain", "a-any.example.domain"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			names := newTXTNames(tt.txt)
+			got := make([]string, 0, len(tt.want))
+			for _, txt := range names.endpoints(tt.dnsName, tt.recordType, "heritage=external-dns") {
+				got = append(got, txt.DNSName)
+
+				// every generated name maps back to the record it owns
+				key := names.endpointKey(txt.DNSName)
+				assert.Equal(t, names.ownerName(tt.dnsName), key.DNSName)
+				if key.RecordType != "" {
+					assert.Equal(t, tt.recordType, key.RecordType)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_txtNames_endpointKey(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		txt     config.TXT
+		txtName string
+		want    endpoint.EndpointKey
+	}{
+		{
+			name:    "untyped",
+			txtName: "foo.example.domain",
+			want:    endpoint.EndpointKey{DNSName: "foo.example.domain"},
+		},
+		{
+			name:    "typed",
+			txtName: "AAAA-foo.example.domain",
+			want:    endpoint.EndpointKey{DNSName: "foo.example.domain", RecordType: endpoint.RecordTypeAAAA},
+		},
+		{
+			name:    "not a type",
+			txtName: "api-foo.example.domain",
+			want:    endpoint.EndpointKey{DNSName: "api-foo.example.domain"},
+		},
+		{
+			name:    "missing prefix",
+			txt:     config.TXT{Prefix: "owner-"},
+			txtName: "foo.example.domain",
+			want:    endpoint.EndpointKey{},
+		},
+		{
+			name:    "missing suffix",
+			txt:     config.TXT{Suffix: "-owner"},
+			txtName: "foo.example.domain",
+			want:    endpoint.EndpointKey{},
+		},
+		{
+			name:    "untyped name with templated prefix",
+			txt:     config.TXT{Prefix: "%{record_type}-owner."},
+			txtName: "-owner.foo.example.domain",
+			want:    endpoint.EndpointKey{DNSName: "foo.example.domain"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, newTXTNames(tt.txt).endpointKey(tt.txtName))
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package unbound
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// rowUpdates is the opnsense work needed to move a set of rows onto a new set of targets.
+type rowUpdates struct {
+	// set holds rows to rewrite in place. Target is the new target for the row.
+	set []rowRef
+	// create holds targets that have no row left to reuse.
+	create []string
+	// delete holds rows that are no longer needed.
+	delete []rowRef
+}
+
+// pairUpdates orders oldEndpoints to match newEndpoints by Key, so a misordered plan never rewrites
+// the rows of one record with the targets of another. Every UpdateNew endpoint needs exactly one
+// UpdateOld endpoint with the same key.
+func pairUpdates(oldEndpoints, newEndpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
+	if len(oldEndpoints) != len(newEndpoints) {
+		return nil, fmt.Errorf("%d old, %d new: %w", len(oldEndpoints), len(newEndpoints), ErrUnpairedUpdate)
+	}
+
+	byKey := make(map[endpoint.EndpointKey]*endpoint.Endpoint, len(oldEndpoints))
+	for _, ep := range oldEndpoints {
+		if _, ok := byKey[ep.Key()]; ok {
+			return nil, fmt.Errorf("%s %s updated twice: %w", ep.DNSName, ep.RecordType, ErrUnpairedUpdate)
+		}
+		byKey[ep.Key()] = ep
+	}
+
+	paired := make([]*endpoint.Endpoint, 0, len(newEndpoints))
+	for _, ep := range newEndpoints {
+		old, ok := byKey[ep.Key()]
+		if !ok {
+			return nil, fmt.Errorf("%s %s has no old endpoint: %w", ep.DNSName, ep.RecordType, ErrUnpairedUpdate)
+		}
+		delete(byKey, ep.Key())
+		paired = append(paired, old)
+	}
+
+	return paired, nil
+}
+
+// updateEndpoints edits the rows behind each UpdateOld endpoint to match its UpdateNew pair.
+func (u Unbound) updateEndpoints(ctx context.Context, tx *journal, oldEndpoints, newEndpoints []*endpoint.Endpoint) error {
+	oldEndpoints, err := pairUpdates(oldEndpoints, newEndpoints)
+	if err != nil {
+		return err
+	}
+
+	for i, newEndpoint := range newEndpoints {
+		if !managedType(newEndpoint.RecordType) {
+			u.logger.DebugContext(ctx, "skipping update, wrong record type",
+				slog.String("type", newEndpoint.RecordType),
+				slog.Any("endpoint", newEndpoint))
+
+			continue
+		}
+
+		if newEndpoint.RecordType == endpoint.RecordTypeCNAME && !u.aliasable(newEndpoint) {
+			u.logger.WarnContext(ctx, "skipping update, CNAME target is not a host override",
+				slog.Any("endpoint", newEndpoint))
+
+			continue
+		}
+
+		if err := u.updateEndpoint(ctx, tx, oldEndpoints[i], newEndpoint); err != nil {
+			return fmt.Errorf("update endpoint %q: %w", newEndpoint.DNSName, err)
+		}
+	}
+
+	return nil
+}
+
+func (u Unbound) updateEndpoint(ctx context.Context, tx *journal, oldEndpoint, newEndpoint *endpoint.Endpoint) error {
+	description, err := u.description(ctx, newEndpoint, oldEndpoint.Labels[DescriptionLabel])
+	if err != nil {
+		return err
+	}
+	refresh := description != oldEndpoint.Labels[DescriptionLabel]
+	rows := rowRefs(oldEndpoint)
+	updates := planRowUpdates(rows, newEndpoint.Targets, refresh)
+
+	previous := make(map[string]rowRef, len(rows))
+	for _, row := range rows {
+		previous[row.UUID] = row
+	}
+
+	for _, row := range updates.set {
+		record, err := u.targetRecord(newEndpoint, row.Target, description)
+		if err != nil {
+			return err
+		}
+		if err := u.setTarget(ctx, tx, oldEndpoint, previous[row.UUID], record); err != nil {
+			return err
+		}
+	}
+
+	// create before deleting so the name keeps resolving throughout.
+	for _, target := range updates.create {
+		record, err := u.targetRecord(newEndpoint, target, description)
+		if err != nil {
+			return err
+		}
+		if err := u.createTarget(ctx, tx, record); err != nil {
+			return err
+		}
+	}
+
+	for _, row := range updates.delete {
+		if err := u.deleteTarget(ctx, tx, oldEndpoint, row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// planRowUpdates pairs the existing rows with the wanted targets. Rows already pointing at a
+// wanted target are left alone unless refresh is set. The remaining rows are reused for the
+// remaining targets, so rows are only created or deleted when the number of targets changes.
+func planRowUpdates(rows []rowRef, targets endpoint.Targets, refresh bool) rowUpdates {
+	out := rowUpdates{}
+
+	pending := make(map[string]struct{}, len(targets))
+	ordered := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if _, ok := pending[target]; ok {
+			continue
+		}
+		pending[target] = struct{}{}
+		ordered = append(ordered, target)
+	}
+
+	unmatched := make([]rowRef, 0, len(rows))
+	for _, row := range rows {
+		if _, ok := pending[row.Target]; ok {
+			delete(pending, row.Target)
+			if refresh {
+				out.set = append(out.set, row)
+			}
+
+			continue
+		}
+		unmatched = append(unmatched, row)
+	}
+
+	for _, target := range ordered {
+		if _, ok := pending[target]; !ok {
+			continue
+		}
+		if len(unmatched) > 0 {
+			out.set = append(out.set, rowRef{UUID: unmatched[0].UUID, Target: target})
+			unmatched = unmatched[1:]
+		} else {
+			out.create = append(out.create, target)
+		}
+	}
+
+	if len(unmatched) > 0 {
+		out.delete = unmatched
+	}
+
+	return out
+}
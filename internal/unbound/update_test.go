@@ -0,0 +1,88 @@
+package unbound
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func Test_planRowUpdates(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		rows    []rowRef
+		targets endpoint.Targets
+		refresh bool
+		want    rowUpdates
+	}{
+		{
+			name:    "nothing changed",
+			rows:    []rowRef{{UUID: "uuid-1", Target: "10.0.0.1"}},
+			targets: endpoint.NewTargets("10.0.0.1"),
+			want:    rowUpdates{},
+		},
+		{
+			name:    "nothing changed - refresh",
+			rows:    []rowRef{{UUID: "uuid-1", Target: "10.0.0.1"}},
+			targets: endpoint.NewTargets("10.0.0.1"),
+			refresh: true,
+			want: rowUpdates{
+				set: []rowRef{{UUID: "uuid-1", Target: "10.0.0.1"}},
+			},
+		},
+		{
+			name:    "target changed in place",
+			rows:    []rowRef{{UUID: "uuid-1", Target: "10.0.0.1"}},
+			targets: endpoint.NewTargets("10.0.0.2"),
+			want: rowUpdates{
+				set: []rowRef{{UUID: "uuid-1", Target: "10.0.0.2"}},
+			},
+		},
+		{
+			name:    "target added",
+			rows:    []rowRef{{UUID: "uuid-1", Target: "10.0.0.1"}},
+			targets: endpoint.NewTargets("10.0.0.1", "10.0.0.2"),
+			want: rowUpdates{
+				create: []string{"10.0.0.2"},
+			},
+		},
+		{
+			name: "target removed",
+			rows: []rowRef{
+				{UUID: "uuid-1", Target: "10.0.0.1"},
+				{UUID: "uuid-2", Target: "10.0.0.2"},
+			},
+			targets: endpoint.NewTargets("10.0.0.2"),
+			want: rowUpdates{
+				delete: []rowRef{{UUID: "uuid-1", Target: "10.0.0.1"}},
+			},
+		},
+		{
+			name: "duplicate rows are cleaned up",
+			rows: []rowRef{
+				{UUID: "uuid-1", Target: "10.0.0.1"},
+				{UUID: "uuid-2", Target: "10.0.0.1"},
+			},
+			targets: endpoint.NewTargets("10.0.0.1"),
+			want: rowUpdates{
+				delete: []rowRef{{UUID: "uuid-2", Target: "10.0.0.1"}},
+			},
+		},
+		{
+			name:    "legacy set identifier row",
+			rows:    []rowRef{{UUID: "uuid-1"}},
+			targets: endpoint.NewTargets("10.0.0.1"),
+			want: rowUpdates{
+				set: []rowRef{{UUID: "uuid-1", Target: "10.0.0.1"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, planRowUpdates(tt.rows, tt.targets, tt.refresh))
+		})
+	}
+}